@@ -2,19 +2,32 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/hashicorp/vault/api"
+	"golang.org/x/time/rate"
+
 	"github.com/kubermatic-labs/aquayman/pkg/config"
 	"github.com/kubermatic-labs/aquayman/pkg/export"
 	"github.com/kubermatic-labs/aquayman/pkg/publisher"
 	"github.com/kubermatic-labs/aquayman/pkg/quay"
+	"github.com/kubermatic-labs/aquayman/pkg/statefile"
 	"github.com/kubermatic-labs/aquayman/pkg/sync"
+	"github.com/kubermatic-labs/aquayman/pkg/util"
 )
 
+// driftExitCode is used in -check mode when the organization
+// does not match the configuration.
+const driftExitCode = 2
+
 // These variables are set by goreleaser during build time.
 var (
 	version = "dev"
@@ -25,30 +38,89 @@ func main() {
 	ctx := context.Background()
 
 	var (
-		configFile         = ""
+		configFiles        = stringsFlag{}
 		showVersion        = false
 		confirm            = false
 		validate           = false
 		checkNames         = false
 		exportMode         = false
+		exportWildcards    = false
+		exportGroups       = false
 		createRepositories = false
 		deleteRepositories = false
+		registry           = ""
+		apiURL             = ""
+		planFile           = ""
+		applyFile          = ""
+		outputFormat       = ""
+		checkDrift         = false
+		maxRetries         = quay.DefaultRetryPolicy().MaxRetries
+		rateLimit          = 0.0
+		parallelism        = 1
+		rotateRobots       = ""
+		stateFile          = ""
+		onlyOrganizations  = ""
 
 		// Set this to enable vault integration; as the Vault API
-		// client uses VAULT_ADDR and VAULT_TOKEN env vars already,
-		// we simply do the same.
+		// client uses the VAULT_ADDR env var already, we simply do
+		// the same. VAULT_TOKEN is only needed for token auth.
 		enableVault = false
+
+		// Set this to remove Vault secrets of robots that are not
+		// configured anymore; requires a state file.
+		pruneVaultSecrets = false
+
+		// These override the Vault settings from the config file.
+		vaultNamespace  = ""
+		vaultAuthMethod = ""
+		vaultAuthMount  = ""
+		vaultAuthRole   = ""
+
+		// Set this to publish robot credentials as Kubernetes Secrets;
+		// without a kubeconfig, the in-cluster configuration is used.
+		enableKubernetes = false
+		kubeconfig       = ""
+
+		// Set this to write robot credentials into a local directory.
+		publishDir          = ""
+		publishFileMode     = "0600"
+		publishAgeRecipient = ""
 	)
 
-	flag.StringVar(&configFile, "config", configFile, "path to the config.yaml")
+	flag.Var(&configFiles, "config", "path to the config.yaml or a configuration directory; can be given multiple times to include more files")
 	flag.BoolVar(&showVersion, "version", showVersion, "show the Aquayman version and exit")
 	flag.BoolVar(&confirm, "confirm", confirm, "must be set to actually perform any changes on quay.io")
 	flag.BoolVar(&validate, "validate", validate, "validate the given configuration syntax and then exit")
-	flag.BoolVar(&checkNames, "check-names", checkNames, "(only with -validate) validate that users actually exist (requires valid quay.io credentials)")
+	flag.BoolVar(&checkNames, "check-names", checkNames, "(only with -validate) validate that users and the Quay accounts of all people actually exist (requires valid quay.io credentials)")
 	flag.BoolVar(&exportMode, "export", exportMode, "export quay.io state and update the config file (-config flag)")
+	flag.BoolVar(&exportWildcards, "export-wildcards", exportWildcards, "(only with -export) group repositories with identical settings into wildcard rules")
+	flag.BoolVar(&exportGroups, "export-groups", exportGroups, "(only with -export) combine users that are used together in multiple teams or repositories into groups")
 	flag.BoolVar(&createRepositories, "create-repos", createRepositories, "create repositories listed in the config file but not existing on quay.io yet")
 	flag.BoolVar(&deleteRepositories, "delete-repos", deleteRepositories, "delete repositories on quay.io that are not listed in the config file")
-	flag.BoolVar(&enableVault, "enable-vault", enableVault, "enable Vault integration (VAULT_ADDR and VAULT_TOKEN env vars must be set also)")
+	flag.StringVar(&planFile, "plan", planFile, "compute the required changes and write them as a plan into the given file, without performing them")
+	flag.StringVar(&applyFile, "apply", applyFile, "apply a plan file previously created with -plan (implies -confirm)")
+	flag.BoolVar(&checkDrift, "check", checkDrift, fmt.Sprintf("only compare the organization against the configuration and exit with code %d if they differ", driftExitCode))
+	flag.BoolVar(&checkDrift, "detect-drift", checkDrift, "alias for -check")
+	flag.StringVar(&outputFormat, "output", outputFormat, "additionally print the planned changes to stdout in a machine-readable format (json, yaml or markdown)")
+	flag.IntVar(&maxRetries, "max-retries", maxRetries, "number of times failed API requests are retried (0 disables retries)")
+	flag.Float64Var(&rateLimit, "rate-limit", rateLimit, "maximum number of API requests per second (0 means unlimited)")
+	flag.IntVar(&parallelism, "parallelism", parallelism, "number of repositories to synchronize or export concurrently")
+	flag.StringVar(&rotateRobots, "rotate-robot", rotateRobots, "regenerate and republish the tokens of the given robots (comma-separated short names) and exit")
+	flag.StringVar(&onlyOrganizations, "org", onlyOrganizations, "only work on these organizations of a multi-organization configuration (comma-separated); with -export, the organization of a new configuration")
+	flag.StringVar(&stateFile, "state-file", stateFile, "path to a JSON file where Aquayman keeps track of robot token rotations and published Vault secrets")
+	flag.StringVar(&registry, "registry", registry, "hostname of a self-hosted Quay registry (overrides the config file)")
+	flag.StringVar(&apiURL, "api-url", apiURL, "base URL of the Quay API, e.g. https://quay.example.com/api/v1 (overrides the config file)")
+	flag.BoolVar(&enableVault, "enable-vault", enableVault, "enable Vault integration (VAULT_ADDR env var must be set also, VAULT_TOKEN unless another -vault-auth-method is used)")
+	flag.BoolVar(&pruneVaultSecrets, "prune-secrets", pruneVaultSecrets, "(only with -enable-vault and -state-file) remove Vault secrets of robots that are not configured anymore")
+	flag.StringVar(&vaultNamespace, "vault-namespace", vaultNamespace, "(only with -enable-vault) Vault Enterprise namespace (overrides the config file)")
+	flag.StringVar(&vaultAuthMethod, "vault-auth-method", vaultAuthMethod, fmt.Sprintf("(only with -enable-vault) Vault auth method, one of %v (overrides the config file)", config.AllVaultAuthMethods))
+	flag.StringVar(&vaultAuthMount, "vault-auth-mount", vaultAuthMount, "(only with -enable-vault) path where the Vault auth method is mounted (overrides the config file)")
+	flag.StringVar(&vaultAuthRole, "vault-auth-role", vaultAuthRole, "(only with -enable-vault) role for Kubernetes/JWT auth, role ID for AppRole auth (overrides the config file)")
+	flag.BoolVar(&enableKubernetes, "enable-kubernetes", enableKubernetes, "enable publishing robot credentials as Kubernetes image pull Secrets")
+	flag.StringVar(&kubeconfig, "kubeconfig", kubeconfig, "(only with -enable-kubernetes) path to a kubeconfig file, if not running inside a cluster")
+	flag.StringVar(&publishDir, "publish-dir", publishDir, "write robot tokens and Docker config.json files into this directory")
+	flag.StringVar(&publishFileMode, "publish-file-mode", publishFileMode, "(only with -publish-dir) file permissions for the written files, in octal notation")
+	flag.StringVar(&publishAgeRecipient, "publish-age-recipient", publishAgeRecipient, "(only with -publish-dir) encrypt files for these age recipients (comma-separated public keys)")
 	flag.Parse()
 
 	if showVersion {
@@ -56,32 +128,126 @@ func main() {
 		return
 	}
 
-	if enableVault {
-		if os.Getenv("VAULT_ADDR") == "" || os.Getenv("VAULT_TOKEN") == "" {
-			log.Fatal("⚠ Both VAULT_ADDR and VAULT_TOKEN environment variables need to be set if -enable-vault is used.")
-		}
+	if planFile != "" && applyFile != "" {
+		log.Fatal("⚠ -plan and -apply cannot be used at the same time.")
+	}
+
+	if exportMode && (planFile != "" || applyFile != "") {
+		log.Fatal("⚠ -export cannot be combined with -plan or -apply.")
+	}
+
+	if rotateRobots != "" && (planFile != "" || applyFile != "") {
+		log.Fatal("⚠ -rotate-robot cannot be combined with -plan or -apply.")
+	}
+
+	if checkDrift && (exportMode || applyFile != "" || confirm) {
+		log.Fatal("⚠ -check cannot be combined with -export, -apply or -confirm.")
+	}
+
+	if outputFormat != "" && !validOutputFormat(outputFormat) {
+		log.Fatalf("⚠ Invalid -output format %q, must be one of %v.", outputFormat, sync.AllOutputFormats)
+	}
+
+	if pruneVaultSecrets && (!enableVault || stateFile == "") {
+		log.Fatal("⚠ -prune-secrets requires -enable-vault and -state-file.")
+	}
+
+	// a plan file has already been reviewed, so applying it
+	// must actually perform the changes
+	if applyFile != "" {
+		confirm = true
+	}
+
+	// VAULT_TOKEN is only required for token auth, which is
+	// checked when creating the Vault client
+	if enableVault && os.Getenv("VAULT_ADDR") == "" {
+		log.Fatal("⚠ The VAULT_ADDR environment variable needs to be set if -enable-vault is used.")
 	}
 
-	if configFile == "" {
+	if len(configFiles) == 0 {
 		log.Print("⚠ No configuration (-config) specified.\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	cfg, err := config.LoadFromFile(configFile)
+	// exporting can start a new configuration directory
+	if exportMode && isNewDirectory(configFiles[0]) {
+		if err := os.MkdirAll(configFiles[0], 0755); err != nil {
+			log.Fatalf("⚠ Failed to create configuration directory: %v.", err)
+		}
+	}
+
+	cfg, err := config.LoadFromFiles(configFiles...)
 	if err != nil {
-		log.Fatalf("⚠ Failed to load config %q: %v.", configFile, err)
+		log.Fatalf("⚠ Failed to load config: %v.", err)
+	}
+
+	// a new configuration does not name its organization yet
+	if exportMode && cfg.Organization == "" && !cfg.IsMultiOrganization() {
+		if onlyOrganizations == "" || strings.Contains(onlyOrganizations, ",") {
+			log.Fatal("⚠ The configuration has no organization, select the one to export with -org.")
+		}
+
+		cfg.Organization = strings.TrimSpace(onlyOrganizations)
 	}
 
+	if registry != "" || apiURL != "" {
+		if cfg.Registry == nil {
+			cfg.Registry = &config.RegistryConfig{}
+		}
+
+		if registry != "" {
+			cfg.Registry.Host = registry
+		}
+
+		if apiURL != "" {
+			cfg.Registry.APIURL = apiURL
+		}
+	}
+
+	if vaultNamespace != "" || vaultAuthMethod != "" || vaultAuthMount != "" || vaultAuthRole != "" {
+		if cfg.Vault == nil {
+			cfg.Vault = &config.VaultConfig{}
+		}
+
+		if vaultNamespace != "" {
+			cfg.Vault.Namespace = vaultNamespace
+		}
+
+		if vaultAuthMethod != "" || vaultAuthMount != "" || vaultAuthRole != "" {
+			if cfg.Vault.Auth == nil {
+				cfg.Vault.Auth = &config.VaultAuthConfig{}
+			}
+
+			if vaultAuthMethod != "" {
+				cfg.Vault.Auth.Method = vaultAuthMethod
+			}
+
+			if vaultAuthMount != "" {
+				cfg.Vault.Auth.Mount = vaultAuthMount
+			}
+
+			if vaultAuthRole != "" {
+				if cfg.Vault.AuthMethod() == config.VaultAppRoleAuth {
+					cfg.Vault.Auth.RoleID = vaultAuthRole
+				} else {
+					cfg.Vault.Auth.Role = vaultAuthRole
+				}
+			}
+		}
+	}
+
+	// client must remain a nil interface unless a client is actually
+	// created, as Validate() only checks names against Quay if it is set
 	var (
-		client *quay.Client
+		client quay.API
 	)
 
 	// validate config unless in export mode, where an incomplete
 	// configuration is allowed and even expected
 	if !exportMode {
 		if checkNames {
-			client, err = quay.NewClient(getToken(), 30*time.Second, true)
+			client, err = newClient(cfg, true, maxRetries, rateLimit)
 			if err != nil {
 				log.Fatalf("⚠ Failed to create quay.io API client: %v.", err)
 			}
@@ -97,57 +263,602 @@ func main() {
 		return
 	}
 
+	orgs := cfg.OrganizationNames()
+	if onlyOrganizations != "" {
+		orgs = []string{}
+		for _, name := range strings.Split(onlyOrganizations, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+
+			if !util.StringSliceContains(cfg.OrganizationNames(), name) {
+				log.Fatalf("⚠ Organization %s is not part of the configuration.", name)
+			}
+
+			orgs = append(orgs, name)
+		}
+	}
+
+	if len(orgs) > 1 && (planFile != "" || applyFile != "" || rotateRobots != "") {
+		log.Fatal("⚠ -plan, -apply and -rotate-robot work on a single organization, select one with -org.")
+	}
+
 	if client == nil {
-		client, err = quay.NewClient(getToken(), 30*time.Second, !confirm)
+		client, err = newClient(cfg, !confirm, maxRetries, rateLimit)
 		if err != nil {
 			log.Fatalf("⚠ Failed to create quay.io API client: %v.", err)
 		}
 	}
 
 	if exportMode {
-		log.Printf("► Exporting organization %s…", cfg.Organization)
+		exported := map[string]*config.Config{}
+
+		for _, org := range orgs {
+			log.Printf("► Exporting organization %s…", org)
 
-		newConfig, err := export.ExportConfiguration(ctx, cfg.Organization, client)
+			newConfig, err := export.ExportConfiguration(ctx, org, client, export.Options{
+				Parallelism:    parallelism,
+				InferWildcards: exportWildcards,
+				InferGroups:    exportGroups,
+			})
+			if err != nil {
+				log.Fatalf("⚠ Failed to export: %v.", err)
+			}
+
+			exported[org] = newConfig
+		}
+
+		// merge into the configuration as it is on disk, without
+		// the overrides from flags
+		original, err := config.LoadFromFiles(configFiles...)
 		if err != nil {
-			log.Fatalf("⚠ Failed to export: %v.", err)
+			log.Fatalf("⚠ Failed to load config: %v.", err)
+		}
+
+		if original.Organization == "" && !original.IsMultiOrganization() {
+			original.Organization = cfg.Organization
+		}
+
+		if err := export.MergeOrganizations(original, exported); err != nil {
+			log.Fatalf("⚠ Failed to merge the export into the configuration: %v.", err)
 		}
 
-		if err := config.SaveToFile(newConfig, configFile); err != nil {
+		changed, err := config.UpdateFile(original, configFiles[0])
+		if err != nil {
 			log.Fatalf("⚠ Failed to update config file: %v.", err)
 		}
 
-		log.Println("✓ Export successful.")
+		logClientStats(client)
+
+		if changed {
+			log.Println("✓ Export successful.")
+		} else {
+			log.Println("✓ Export successful, the configuration was already up-to-date.")
+		}
 		return
 	}
 
-	var pub publisher.Publisher
+	var state *statefile.StateFile
+	if stateFile != "" {
+		state, err = statefile.Load(stateFile)
+		if err != nil {
+			log.Fatalf("⚠ Failed to load state file %q: %v.", stateFile, err)
+		}
+	}
+
+	fileMode, err := strconv.ParseUint(publishFileMode, 8, 32)
+	if err != nil {
+		log.Fatalf("⚠ Invalid -publish-file-mode %q: %v.", publishFileMode, err)
+	}
+
+	opts := runOptions{
+		confirm:      confirm,
+		checkDrift:   checkDrift,
+		outputFormat: outputFormat,
+		pruneSecrets: pruneVaultSecrets,
+		stateFile:    stateFile,
+		publish: publishOptions{
+			enableKubernetes: enableKubernetes,
+			kubeconfig:       kubeconfig,
+			dir:              publishDir,
+			fileMode:         os.FileMode(fileMode),
+		},
+		sync: sync.Options{
+			CreateMissingRepositories:  createRepositories,
+			DeleteDanglingRepositories: deleteRepositories,
+			Parallelism:                parallelism,
+		},
+	}
+
+	if publishAgeRecipient != "" {
+		opts.publish.ageRecipients = strings.Split(publishAgeRecipient, ",")
+	}
+
 	if enableVault {
-		pub, err = publisher.NewVaultPublisher(cfg.Organization)
+		opts.publish.vaultClient, err = publisher.NewVaultClient(ctx, cfg.Vault)
 		if err != nil {
 			log.Fatalf("⚠ Failed to create Vault client: %v.", err)
 		}
 	}
 
-	log.Printf("► Updating organization %s…", cfg.Organization)
+	if rotateRobots != "" || applyFile != "" || planFile != "" {
+		cfg = cfg.ForOrganization(orgs[0])
 
-	options := sync.Options{
-		CreateMissingRepositories:  createRepositories,
-		DeleteDanglingRepositories: deleteRepositories,
-		Publisher:                  pub,
+		pub, _, _, err := newPublisher(ctx, cfg, state, opts.publish)
+		if err != nil {
+			log.Fatalf("⚠ %v.", err)
+		}
+
+		opts.sync.Publisher = pub
 	}
 
-	err = sync.Sync(ctx, cfg, client, options)
-	if err != nil {
-		log.Fatalf("⚠ Failed to sync state: %v.", err)
+	if rotateRobots != "" {
+		names := []string{}
+		for _, name := range strings.Split(rotateRobots, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+
+		log.Printf("► Rotating robot tokens in organization %s…", cfg.Organization)
+
+		if !confirm {
+			for _, name := range names {
+				log.Printf("  ↻ ⚛ %s", name)
+			}
+
+			log.Println("⚠ Run again with -confirm to rotate the tokens above.")
+			return
+		}
+
+		rotated, err := sync.RotateRobots(ctx, cfg, client, names, opts.sync.Publisher, state)
+		saveState(state, stateFile)
+
+		if err != nil {
+			log.Fatalf("⚠ Failed to rotate robot tokens (rotated %v): %v.", rotated, err)
+		}
+
+		log.Printf("✓ Rotated %d robot token(s): %s.", len(rotated), strings.Join(rotated, ", "))
+		return
 	}
 
-	if confirm {
+	if applyFile != "" {
+		plan, err := sync.LoadPlan(applyFile)
+		if err != nil {
+			log.Fatalf("⚠ Failed to load plan %q: %v.", applyFile, err)
+		}
+
+		if plan.Organization != cfg.Organization {
+			log.Fatalf("⚠ Plan was created for organization %s, but the configuration is for %s.", plan.Organization, cfg.Organization)
+		}
+
+		log.Printf("► Verifying organization %s…", cfg.Organization)
+
+		if err := sync.CheckDrift(ctx, client, plan, opts.sync); err != nil {
+			log.Fatalf("⚠ Refusing to apply plan: %v.", err)
+		}
+
+		log.Printf("► Applying plan to organization %s…", cfg.Organization)
+
+		err = sync.Apply(ctx, client, plan, opts.sync)
+		saveState(state, stateFile)
+
+		if err != nil {
+			log.Fatalf("⚠ Failed to apply plan: %v.", err)
+		}
+
+		// due robots are not part of the plan, as the plan might be
+		// applied long after it was created
+		if err := rotateDueRobots(ctx, cfg, client, opts.sync.Publisher, state, stateFile, confirm); err != nil {
+			log.Fatalf("⚠ %v.", err)
+		}
+
+		logClientStats(client)
+		log.Println("✓ Plan successfully applied.")
+		return
+	}
+
+	if planFile != "" {
+		log.Printf("► Updating organization %s…", cfg.Organization)
+
+		plan, err := sync.BuildPlan(ctx, cfg, client, opts.sync)
+		if err != nil {
+			log.Fatalf("⚠ Failed to plan changes: %v.", err)
+		}
+
+		if outputFormat != "" {
+			if err := sync.NewChangeset(plan).Write(os.Stdout, sync.OutputFormat(outputFormat)); err != nil {
+				log.Fatalf("⚠ Failed to print changes: %v.", err)
+			}
+		}
+
+		sync.LogPlan(plan)
+
+		if err := sync.SavePlan(plan, planFile); err != nil {
+			log.Fatalf("⚠ Failed to write plan: %v.", err)
+		}
+
+		log.Printf("✓ Plan with %d action(s) written to %s, use -apply to perform it.", len(plan.Actions), planFile)
+		return
+	}
+
+	report := &sync.Report{}
+
+	for i, org := range orgs {
+		opts.firstOutput = i == 0
+
+		changeset, err := syncOrganization(ctx, cfg.ForOrganization(org), client, state, opts)
+		report.Add(org, changeset, confirm && !checkDrift, err)
+
+		// a single organization behaves like it always did
+		if err != nil && len(orgs) == 1 {
+			log.Fatalf("⚠ Failed to synchronize organization %s: %v.", org, err)
+		}
+	}
+
+	logClientStats(client)
+
+	if len(orgs) > 1 {
+		report.LogSummary()
+
+		if report.Failed() {
+			os.Exit(1)
+		}
+	}
+
+	switch {
+	case checkDrift && report.Changed():
+		os.Exit(driftExitCode)
+	case checkDrift:
+		if len(orgs) > 1 {
+			log.Println("✓ All organizations match the configuration.")
+		}
+	case confirm:
 		log.Println("✓ Permissions successfully synchronized.")
-	} else {
+	default:
 		log.Println("⚠ Run again with -confirm to apply the changes above.")
 	}
 }
 
+// runOptions control how each organization is synchronized.
+type runOptions struct {
+	confirm      bool
+	checkDrift   bool
+	outputFormat string
+	pruneSecrets bool
+	stateFile    string
+	publish      publishOptions
+	sync         sync.Options
+
+	// firstOutput is set for the first organization, so that
+	// multiple YAML documents are correctly separated.
+	firstOutput bool
+}
+
+type publishOptions struct {
+	// vaultClient is shared by all organizations, so that
+	// Aquayman only logs into Vault once; nil if Vault is
+	// not enabled.
+	vaultClient      *api.Client
+	enableKubernetes bool
+	kubeconfig       string
+	dir              string
+	fileMode         os.FileMode
+	ageRecipients    []string
+}
+
+// newPublisher creates all enabled publishers for the organization.
+// The Vault and file publishers are returned separately, as they are
+// also used for pruning; they are nil if they are not enabled.
+func newPublisher(ctx context.Context, cfg *config.Config, state *statefile.StateFile, opts publishOptions) (publisher.Publisher, *publisher.Vault, *publisher.File, error) {
+	targets := []publisher.Target{}
+
+	var vault *publisher.Vault
+	if opts.vaultClient != nil {
+		vault = publisher.NewVaultPublisher(opts.vaultClient, cfg.Organization, cfg.RegistryHost(), cfg.Vault, state)
+		targets = append(targets, publisher.Target{Name: config.VaultPublisher, Publisher: vault})
+	}
+
+	if opts.enableKubernetes {
+		kubeClient, err := publisher.NewKubernetesClient(opts.kubeconfig)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+		}
+
+		kubernetes := publisher.NewKubernetesPublisher(kubeClient, cfg.Organization, cfg.RegistryHost())
+		targets = append(targets, publisher.Target{Name: config.KubernetesPublisher, Publisher: kubernetes})
+	}
+
+	var files *publisher.File
+	if opts.dir != "" {
+		var err error
+
+		files, err = publisher.NewFilePublisher(opts.dir, opts.fileMode, opts.ageRecipients, cfg.Organization, cfg.RegistryHost())
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to create file publisher: %w", err)
+		}
+
+		targets = append(targets, publisher.Target{Name: config.FilePublisher, Publisher: files})
+	}
+
+	if len(targets) == 0 {
+		return nil, nil, nil, nil
+	}
+
+	return publisher.NewMultiPublisher(targets...), vault, files, nil
+}
+
+// syncOrganization plans the changes for a single organization and
+// applies them (with -confirm), checks for drift (with -check) or
+// just prints them. The changeset is returned for the final report.
+func syncOrganization(ctx context.Context, cfg *config.Config, client quay.API, state *statefile.StateFile, opts runOptions) (*sync.Changeset, error) {
+	if opts.checkDrift {
+		log.Printf("► Checking organization %s…", cfg.Organization)
+	} else {
+		log.Printf("► Updating organization %s…", cfg.Organization)
+	}
+
+	pub, vault, files, err := newPublisher(ctx, cfg, state, opts.publish)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.sync.Publisher = pub
+
+	plan, err := sync.BuildPlan(ctx, cfg, client, opts.sync)
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan changes: %w", err)
+	}
+
+	changeset := sync.NewChangeset(plan)
+
+	if opts.outputFormat != "" {
+		format := sync.OutputFormat(opts.outputFormat)
+		if format == sync.YAMLOutput && !opts.firstOutput {
+			fmt.Println("---")
+		}
+
+		if err := changeset.Write(os.Stdout, format); err != nil {
+			return changeset, fmt.Errorf("failed to print changes: %w", err)
+		}
+	}
+
+	if opts.checkDrift {
+		if changeset.Empty() {
+			log.Println("✓ Organization matches the configuration.")
+		} else {
+			log.Printf("⚠ Organization has drifted from the configuration:")
+			changeset.LogSummary()
+		}
+
+		return changeset, nil
+	}
+
+	if opts.confirm {
+		err := sync.Apply(ctx, client, plan, opts.sync)
+		saveState(state, opts.stateFile)
+
+		if err != nil {
+			return changeset, fmt.Errorf("failed to sync state: %w", err)
+		}
+	} else {
+		sync.LogPlan(plan)
+	}
+
+	if err := rotateDueRobots(ctx, cfg, client, pub, state, opts.stateFile, opts.confirm); err != nil {
+		return changeset, err
+	}
+
+	if opts.pruneSecrets {
+		if err := pruneSecrets(ctx, cfg, vault, state, opts.stateFile, opts.confirm); err != nil {
+			return changeset, err
+		}
+	}
+
+	if files != nil {
+		if err := pruneFiles(ctx, cfg, files, opts.confirm); err != nil {
+			return changeset, err
+		}
+	}
+
+	return changeset, nil
+}
+
+// stringsFlag is a flag that can be given multiple times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func newClient(cfg *config.Config, dryMode bool, maxRetries int, rateLimit float64) (*quay.Client, error) {
+	client, err := quay.NewClient(getToken(), 30*time.Second, dryMode, cfg.Endpoint())
+	if err != nil {
+		return nil, err
+	}
+
+	client.Retries.MaxRetries = maxRetries
+
+	if rateLimit > 0 {
+		// allow short bursts, but never less than a single request
+		burst := int(rateLimit)
+		if burst < 1 {
+			burst = 1
+		}
+
+		client.Limiter = rate.NewLimiter(rate.Limit(rateLimit), burst)
+	}
+
+	return client, nil
+}
+
+// rotateDueRobots regenerates all robot tokens whose rotateAfter
+// interval has passed. Without a state file, it is impossible to
+// know when tokens were last rotated, so nothing happens then.
+func rotateDueRobots(ctx context.Context, cfg *config.Config, client quay.API, pub publisher.Publisher, state *statefile.StateFile, stateFile string, confirm bool) error {
+	if state == nil {
+		for _, robot := range cfg.Robots {
+			if robot.RotateAfter != "" {
+				log.Println("⚠ Robots with rotateAfter are configured, but no -state-file was given; skipping token rotation.")
+				break
+			}
+		}
+
+		return nil
+	}
+
+	due, err := sync.DueRobots(ctx, cfg, client, state, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to determine robots due for token rotation: %w", err)
+	}
+
+	// robots of unknown age have been recorded in the state
+	if confirm {
+		saveState(state, stateFile)
+	}
+
+	if len(due) == 0 {
+		return nil
+	}
+
+	if pub == nil {
+		log.Println("⚠ Robot tokens are due for rotation, but no publisher is enabled; skipping token rotation.")
+		return nil
+	}
+
+	log.Println("⇄ Rotating robot tokens…")
+
+	if !confirm {
+		for _, name := range due {
+			log.Printf("  ↻ ⚛ %s", name)
+		}
+
+		return nil
+	}
+
+	rotated, err := sync.RotateRobots(ctx, cfg, client, due, pub, state)
+	saveState(state, stateFile)
+
+	if err != nil {
+		return fmt.Errorf("failed to rotate robot tokens (rotated %v): %w", rotated, err)
+	}
+
+	log.Printf("✓ Rotated %d robot token(s): %s.", len(rotated), strings.Join(rotated, ", "))
+
+	return nil
+}
+
+// pruneSecrets removes Vault secret fields that were written for
+// robots, but are not part of the configuration anymore.
+func pruneSecrets(ctx context.Context, cfg *config.Config, vault *publisher.Vault, state *statefile.StateFile, stateFile string, confirm bool) error {
+	stale, err := vault.StaleSecrets(ctx, cfg.Robots)
+	if err != nil {
+		return fmt.Errorf("failed to determine stale Vault secrets: %w", err)
+	}
+
+	if len(stale) == 0 {
+		return nil
+	}
+
+	log.Println("⇄ Pruning Vault secrets…")
+
+	for _, secret := range stale {
+		log.Printf("  - ⚿ %s: %s (%s)", secret.Path, strings.Join(secret.Fields, ", "), secret.Robot)
+	}
+
+	if !confirm {
+		return nil
+	}
+
+	err = vault.PruneSecrets(ctx, stale)
+	saveState(state, stateFile)
+
+	if err != nil {
+		return fmt.Errorf("failed to prune Vault secrets: %w", err)
+	}
+
+	return nil
+}
+
+// pruneFiles removes the published files of robots that are not part
+// of the configuration anymore. Unlike Vault secrets, these do not need
+// a state file, as the directory belongs to Aquayman alone.
+func pruneFiles(ctx context.Context, cfg *config.Config, files *publisher.File, confirm bool) error {
+	stale, err := files.StaleRobots(cfg.Robots)
+	if err != nil {
+		return fmt.Errorf("failed to determine stale files: %w", err)
+	}
+
+	if len(stale) == 0 {
+		return nil
+	}
+
+	log.Println("⇄ Pruning published files…")
+
+	for _, name := range stale {
+		log.Printf("  - ⚛ %s", name)
+	}
+
+	if !confirm {
+		return nil
+	}
+
+	for _, name := range stale {
+		if _, err := files.DeleteRobot(ctx, &config.RobotConfig{Name: name}); err != nil {
+			return fmt.Errorf("failed to prune files of robot %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func saveState(state *statefile.StateFile, filename string) {
+	if state == nil {
+		return
+	}
+
+	if err := statefile.Save(state, filename); err != nil {
+		log.Fatalf("⚠ Failed to save state file %q: %v.", filename, err)
+	}
+}
+
+func logClientStats(client quay.API) {
+	c, ok := client.(*quay.Client)
+	if !ok {
+		return
+	}
+
+	stats := c.Stats()
+	log.Printf("ℹ Sent %d API request(s), %d retried (%d due to rate limiting).", stats.Requests, stats.Retries, stats.RateLimited)
+}
+
+// isNewDirectory returns true if the -config path does not exist yet
+// and is not named like a YAML file.
+func isNewDirectory(path string) bool {
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+
+	ext := filepath.Ext(path)
+
+	return ext != ".yaml" && ext != ".yml"
+}
+
+func validOutputFormat(format string) bool {
+	for _, f := range sync.AllOutputFormats {
+		if string(f) == format {
+			return true
+		}
+	}
+
+	return false
+}
+
 func getToken() string {
 	envName := "AQUAYMAN_TOKEN"
 	token := os.Getenv(envName)
@@ -2,12 +2,14 @@ package config
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"text/template"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
@@ -16,10 +18,178 @@ import (
 )
 
 type Config struct {
-	Organization string             `yaml:"organization"`
+	Organization string   `yaml:"organization,omitempty"`
+	Include      []string `yaml:"include,omitempty"`
+
+	// Organizations can be used instead of Organization to manage
+	// multiple organizations with one configuration. Entries apply to
+	// all of them, unless they are restricted to some organizations.
+	Organizations []string `yaml:"organizations,omitempty"`
+
+	Registry     *RegistryConfig    `yaml:"registry,omitempty"`
+	People       []PersonConfig     `yaml:"people,omitempty"`
+	Groups       []GroupConfig      `yaml:"groups,omitempty"`
 	Teams        []TeamConfig       `yaml:"teams,omitempty"`
 	Repositories []RepositoryConfig `yaml:"repositories,omitempty"`
 	Robots       []RobotConfig      `yaml:"robots,omitempty"`
+	Vault        *VaultConfig       `yaml:"vault,omitempty"`
+
+	DefaultPermissions []DefaultPermissionConfig `yaml:"defaultPermissions,omitempty"`
+
+	// source is the file the configuration was loaded from.
+	source string
+
+	// includes maps all included files to their own include lists.
+	includes map[string][]string
+}
+
+// RegistryConfig allows to use Aquayman with self-hosted Quay
+// installations. If it is not configured, quay.io is used.
+type RegistryConfig struct {
+	// Host is the hostname of the registry, as it is used in
+	// Docker configs, e.g. "quay.example.com". Defaults to "quay.io".
+	Host string `yaml:"host,omitempty"`
+
+	// APIURL is the base URL of the Quay API. If empty, it is
+	// derived from the Host ("https://<host>/api/v1").
+	APIURL string `yaml:"apiURL,omitempty"`
+
+	// CABundle is the path to a PEM file containing additional
+	// CA certificates to trust.
+	CABundle string `yaml:"caBundle,omitempty"`
+
+	// ClientCertificate and ClientKey are paths to PEM files
+	// that are used for TLS client authentication.
+	ClientCertificate string `yaml:"clientCertificate,omitempty"`
+	ClientKey         string `yaml:"clientKey,omitempty"`
+}
+
+// RegistryHost returns the configured registry hostname, falling
+// back to quay.io.
+func (c *Config) RegistryHost() string {
+	if c.Registry != nil {
+		if c.Registry.Host != "" {
+			return c.Registry.Host
+		}
+
+		if c.Registry.APIURL != "" {
+			if u, err := url.Parse(c.Registry.APIURL); err == nil && u.Host != "" {
+				return u.Host
+			}
+		}
+	}
+
+	return quay.DefaultRegistry
+}
+
+// Endpoint returns the Quay API endpoint configuration.
+func (c *Config) Endpoint() quay.Endpoint {
+	if c.Registry == nil {
+		return quay.Endpoint{}
+	}
+
+	apiURL := c.Registry.APIURL
+	if apiURL == "" && c.Registry.Host != "" {
+		apiURL = fmt.Sprintf("https://%s/api/v1", c.Registry.Host)
+	}
+
+	return quay.Endpoint{
+		APIURL:            apiURL,
+		CABundle:          c.Registry.CABundle,
+		ClientCertificate: c.Registry.ClientCertificate,
+		ClientKey:         c.Registry.ClientKey,
+	}
+}
+
+// VaultConfig controls how robot credentials are stored in Vault.
+type VaultConfig struct {
+	// Fields maps key names to values that are written into each
+	// robot's Vault secret. Both names and values are Go templates,
+	// see the README for the available variables. If empty, the
+	// "<key>-token" and "<key>-config" fields are written.
+	Fields map[string]string `yaml:"fields,omitempty"`
+
+	// Namespace is the Vault Enterprise namespace to use. This can
+	// also be set via the VAULT_NAMESPACE env variable.
+	Namespace string `yaml:"namespace,omitempty"`
+
+	// CheckAndSet enables check-and-set for writes to KV v2 mounts,
+	// so that concurrent changes to a secret are not overwritten.
+	// The mount must allow check-and-set for this to work.
+	CheckAndSet bool `yaml:"checkAndSet,omitempty"`
+
+	// Auth configures how Aquayman logs into Vault. If not set,
+	// the token from VAULT_TOKEN is used.
+	Auth *VaultAuthConfig `yaml:"auth,omitempty"`
+}
+
+const (
+	VaultTokenAuth      = "token"
+	VaultAppRoleAuth    = "approle"
+	VaultKubernetesAuth = "kubernetes"
+	VaultJWTAuth        = "jwt"
+)
+
+var AllVaultAuthMethods = []string{VaultTokenAuth, VaultAppRoleAuth, VaultKubernetesAuth, VaultJWTAuth}
+
+// VaultAuthConfig configures a Vault auth method. Secrets like the
+// AppRole secret ID or the JWT are never part of the configuration,
+// but are read from files or env variables.
+type VaultAuthConfig struct {
+	// Method is one of AllVaultAuthMethods, defaults to "token".
+	Method string `yaml:"method,omitempty"`
+
+	// Mount is the path where the auth method is mounted,
+	// defaults to the method name (e.g. "approle").
+	Mount string `yaml:"mount,omitempty"`
+
+	// Role is the role to log in with (kubernetes and jwt only).
+	Role string `yaml:"role,omitempty"`
+
+	// RoleID is the AppRole role ID. The secret ID is read from
+	// SecretIDFile or the VAULT_SECRET_ID env variable.
+	RoleID       string `yaml:"roleID,omitempty"`
+	SecretIDFile string `yaml:"secretIDFile,omitempty"`
+
+	// JWTFile is the path to the JWT for the kubernetes and jwt
+	// methods. For kubernetes it defaults to the pod's service
+	// account token, for jwt the VAULT_JWT env variable can be
+	// used instead.
+	JWTFile string `yaml:"jwtFile,omitempty"`
+}
+
+// AuthMethod returns the configured auth method, falling back
+// to token authentication.
+func (c *VaultConfig) AuthMethod() string {
+	if c == nil || c.Auth == nil || c.Auth.Method == "" {
+		return VaultTokenAuth
+	}
+
+	return c.Auth.Method
+}
+
+// PersonConfig maps a person to their Quay account. People can be used
+// by their email address in team members, group members and repository
+// users, instead of their Quay username.
+type PersonConfig struct {
+	Email    string `yaml:"email"`
+	Name     string `yaml:"name,omitempty"`
+	Username string `yaml:"username"`
+	GitHub   string `yaml:"github,omitempty"`
+
+	// source is the file the person was loaded from.
+	source string
+}
+
+// GroupConfig is a named list of users and robots that can be used
+// as "@name" in team members and repository users, instead of listing
+// the same people again and again. Members can be other groups.
+type GroupConfig struct {
+	Name    string   `yaml:"name"`
+	Members []string `yaml:"members,omitempty"`
+
+	// source is the file the group was loaded from.
+	source string
 }
 
 type TeamConfig struct {
@@ -27,6 +197,13 @@ type TeamConfig struct {
 	Role        quay.TeamRole `yaml:"role"`
 	Description string        `yaml:"description,omitempty"`
 	Members     []string      `yaml:"members,omitempty"`
+
+	// Organizations restricts the team to some of the configured
+	// organizations; if empty, it exists in all of them.
+	Organizations []string `yaml:"organizations,omitempty"`
+
+	// source is the file the team was loaded from.
+	source string
 }
 
 type RepositoryConfig struct {
@@ -35,6 +212,13 @@ type RepositoryConfig struct {
 	Description string                         `yaml:"description,omitempty"`
 	Teams       map[string]quay.RepositoryRole `yaml:"teams,omitempty"`
 	Users       map[string]quay.RepositoryRole `yaml:"users,omitempty"`
+
+	// Organizations restricts the repository (or rule) to some of the
+	// configured organizations; if empty, it applies to all of them.
+	Organizations []string `yaml:"organizations,omitempty"`
+
+	// source is the file the repository was loaded from.
+	source string
 }
 
 func (c *RepositoryConfig) IsWildcard() bool {
@@ -45,11 +229,14 @@ type RobotConfig struct {
 	Name        string `yaml:"name"`
 	Description string `yaml:"description,omitempty"`
 
-	// VaultSecret is the path inside the Vault API to the
-	// secret where the token should be stored, for example
-	// "mykvstore/data/customer-xyz" (note the "/data/" bit).
+	// VaultSecret is the path to the secret in Vault where the
+	// token should be stored, for example "mykvstore/customer-xyz".
+	// Both KV v1 and v2 mounts are supported; for v2 mounts, the
+	// "/data/" segment is optional ("mykvstore/data/customer-xyz").
 	// Aquayman will extend the secret with a
-	// "quay.io-<orgname>-token" and a "quay.io-<orgname>-secret" keys
+	// "<registry>-<orgname>-<robot>-token" and a
+	// "<registry>-<orgname>-<robot>-config" keys (the registry
+	// is "quay.io" unless configured otherwise)
 	// and store the token / a full docker config JSON there.
 	// If this is empty, no Vault interaction happens, even
 	// if -enable-vault is set.
@@ -57,8 +244,24 @@ type RobotConfig struct {
 	// the default. Use a "#" to separate path from key, e.g.
 	// "mykvstore/data/customer-xyz#keyname". Aquayman will still
 	// append "-token" and "-secret" to the custom keyname.
+	// The written fields can be customized using VaultFields.
 	VaultSecret string `yaml:"vaultSecret,omitempty"`
 
+	// VaultFields optionally replaces the globally configured
+	// fields (see VaultConfig) for this robot's Vault secret.
+	VaultFields map[string]string `yaml:"vaultFields,omitempty"`
+
+	// KubernetesSecrets is a list of Kubernetes Secrets that should
+	// contain the robot's credentials as a Docker config (i.e. an
+	// image pull secret). This is only used if -enable-kubernetes
+	// is set.
+	KubernetesSecrets []KubernetesSecretConfig `yaml:"kubernetesSecrets,omitempty"`
+
+	// Publishers optionally restricts to which of the enabled
+	// publishers (see AllPublishers) the robot's credentials
+	// are sent. If empty, all enabled publishers are used.
+	Publishers []string `yaml:"publishers,omitempty"`
+
 	// Deleted can be used as a workaround for deleting tokens
 	// from Vault. If a robot was just removed from the config.yaml
 	// alltogether, Aquayman would have no idea where to find the
@@ -72,36 +275,199 @@ type RobotConfig struct {
 	// If a robot is directly removed from the configuration,
 	// an orphaned (yet invalid) token will remain in Vault. Not
 	// nice, but not the end of the world.
+	// When using a state file, Aquayman remembers where it wrote
+	// secrets and this workaround is not needed anymore.
 	Deleted bool `yaml:"deleted,omitempty"`
+
+	// RotateAfter is an optional duration (e.g. "720h" or "30d")
+	// after which the robot's token is automatically regenerated
+	// and republished. Rotation requires a state file to remember
+	// when tokens were last rotated.
+	RotateAfter string `yaml:"rotateAfter,omitempty"`
+
+	// Organizations restricts the robot to some of the configured
+	// organizations; if empty, it exists in all of them.
+	Organizations []string `yaml:"organizations,omitempty"`
+
+	// source is the file the robot was loaded from.
+	source string
+}
+
+const (
+	VaultPublisher      = "vault"
+	KubernetesPublisher = "kubernetes"
+	FilePublisher       = "file"
+)
+
+var AllPublishers = []string{VaultPublisher, KubernetesPublisher, FilePublisher}
+
+type KubernetesSecretConfig struct {
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+}
+
+// RotationInterval returns the parsed RotateAfter value, or 0 if
+// no rotation is configured.
+func (r *RobotConfig) RotationInterval() (time.Duration, error) {
+	if r.RotateAfter == "" {
+		return 0, nil
+	}
+
+	return util.ParseDuration(r.RotateAfter)
+}
+
+// DefaultPermissionConfig grants a team, user or robot access to
+// every newly created repository in the organization. In the Quay API
+// these are called "prototypes".
+type DefaultPermissionConfig struct {
+	// Exactly one of Team or User must be set. Robots are
+	// configured as users, using their full name ("[org]+[name]").
+	Team string              `yaml:"team,omitempty"`
+	User string              `yaml:"user,omitempty"`
+	Role quay.RepositoryRole `yaml:"role"`
+
+	// ActivatingUser optionally restricts the permission to
+	// repositories created by this user.
+	ActivatingUser string `yaml:"activatingUser,omitempty"`
+
+	// Organizations restricts the permission to some of the configured
+	// organizations; if empty, it applies to all of them.
+	Organizations []string `yaml:"organizations,omitempty"`
+
+	// source is the file the permission was loaded from.
+	source string
+}
+
+// Key uniquely identifies a default permission within an organization.
+func (p *DefaultPermissionConfig) Key() string {
+	delegate := "user:" + p.User
+	if p.Team != "" {
+		delegate = "team:" + p.Team
+	}
+
+	if p.ActivatingUser != "" {
+		return fmt.Sprintf("%s (created by %s)", delegate, p.ActivatingUser)
+	}
+
+	return delegate
 }
 
+// LoadFromFile loads the configuration from a single YAML file or,
+// if filename is a directory, from a directory of files (see
+// LoadFromDirectory).
 func LoadFromFile(filename string) (*Config, error) {
-	f, err := os.Open(filename)
+	info, err := os.Stat(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+
+	if info.IsDir() {
+		return LoadFromDirectory(filename)
+	}
 
 	config := &Config{
 		Teams:        []TeamConfig{},
 		Repositories: []RepositoryConfig{},
 	}
 
-	if err := yaml.NewDecoder(f).Decode(config); err != nil {
+	if err := decodeFile(filename, config); err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", filename, err)
+	}
+
+	config.setSource(filename)
+
+	if err := config.loadIncludes(filename); err != nil {
 		return nil, err
 	}
 
 	return config, nil
 }
 
+func decodeFile(filename string, out interface{}) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return yaml.NewDecoder(f).Decode(out)
+}
+
+// setSource remembers the file the configuration and all entries
+// without a source of their own were loaded from.
+func (c *Config) setSource(filename string) {
+	c.source = filename
+
+	for i := range c.People {
+		if c.People[i].source == "" {
+			c.People[i].source = filename
+		}
+	}
+
+	for i := range c.Groups {
+		if c.Groups[i].source == "" {
+			c.Groups[i].source = filename
+		}
+	}
+
+	for i := range c.Teams {
+		if c.Teams[i].source == "" {
+			c.Teams[i].source = filename
+		}
+	}
+
+	for i := range c.Repositories {
+		if c.Repositories[i].source == "" {
+			c.Repositories[i].source = filename
+		}
+	}
+
+	for i := range c.Robots {
+		if c.Robots[i].source == "" {
+			c.Robots[i].source = filename
+		}
+	}
+
+	for i := range c.DefaultPermissions {
+		if c.DefaultPermissions[i].source == "" {
+			c.DefaultPermissions[i].source = filename
+		}
+	}
+}
+
+// duplicateError reports an entry that was defined twice, naming
+// both files if they differ.
+func duplicateError(kind string, name string, source string, firstSource string) error {
+	if firstSource != "" && firstSource != source {
+		return sourceErrorf(source, "duplicate %s %q defined, already defined in %s", kind, name, firstSource)
+	}
+
+	return sourceErrorf(source, "duplicate %s %q defined", kind, name)
+}
+
+// sourceErrorf formats an error and prefixes it with the file that
+// the invalid configuration came from, if known.
+func sourceErrorf(source string, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	if source == "" {
+		return err
+	}
+
+	return fmt.Errorf("%s: %w", source, err)
+}
+
 func SaveToFile(config *Config, filename string) error {
+	return saveFile(config, filename)
+}
+
+func saveFile(value interface{}, filename string) error {
 	f, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	if err := yaml.NewEncoder(f).Encode(config); err != nil {
+	if err := yaml.NewEncoder(f).Encode(value); err != nil {
 		return err
 	}
 
@@ -130,13 +496,16 @@ func validRepositoryRole(role quay.RepositoryRole) bool {
 
 var (
 	userRegexp  = regexp.MustCompile(`^[a-z0-9][.a-z0-9_-]*$`)
+	groupRegexp = regexp.MustCompile(`^[a-z0-9][.a-z0-9_-]*$`)
+	emailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+$`)
 	teamRegexp  = regexp.MustCompile(`^[a-z][a-z0-9]+$`)
 	repoRegexp  = regexp.MustCompile(`^[a-z0-9][.a-z0-9_-]*(/[a-z0-9][.a-z0-9_-]*)*$`)
+	ruleRegexp  = regexp.MustCompile(`^[a-z0-9*][.a-z0-9_*-]*(/[a-z0-9*][.a-z0-9_*-]*)*$`)
 	robotRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]{1,254}$`)
 	orgRegexp   = regexp.MustCompile(`^[a-z0-9][.a-z0-9_-]{1,254}$`)
 )
 
-func validateUsername(ctx context.Context, client *quay.Client, name string, cache map[string]struct{}) error {
+func validateUsername(ctx context.Context, client quay.API, name string, cache map[string]struct{}) error {
 	if !userRegexp.MatchString(name) {
 		return fmt.Errorf("username is invalid, must be %v", userRegexp)
 	}
@@ -155,115 +524,265 @@ func validateUsername(ctx context.Context, client *quay.Client, name string, cac
 	return nil
 }
 
-func (c *Config) Validate(ctx context.Context, client *quay.Client) error {
+func validateTemplates(fields map[string]string) error {
+	for name, value := range fields {
+		if _, err := template.New("name").Parse(name); err != nil {
+			return fmt.Errorf("invalid name %q: %v", name, err)
+		}
+
+		if _, err := template.New("value").Parse(value); err != nil {
+			return fmt.Errorf("invalid value for %q: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) Validate(ctx context.Context, client quay.API) error {
+	if c.IsMultiOrganization() {
+		return c.validateOrganizations(ctx, client)
+	}
+
 	if c.Organization == "" {
-		return errors.New("no organization configured")
+		return sourceErrorf(c.source, "no organization configured")
+	}
+
+	if err := c.validateEntryOrganizations(); err != nil {
+		return err
 	}
 
 	if !orgRegexp.MatchString(c.Organization) {
-		return fmt.Errorf("organization name %q is invalid, must be %v", c.Organization, orgRegexp)
+		return sourceErrorf(c.source, "organization name %q is invalid, must be %v", c.Organization, orgRegexp)
 	}
 
 	// runtime cache
 	existingUsers := map[string]struct{}{}
 
+	if err := c.validatePeople(ctx, client, existingUsers); err != nil {
+		return err
+	}
+
+	if err := c.validateGroups(); err != nil {
+		return err
+	}
+
+	// everything else is validated as it will be synchronized
+	c, err := c.ExpandGroups()
+	if err != nil {
+		return err
+	}
+
+	if c.Registry != nil {
+		if c.Registry.APIURL != "" {
+			u, err := url.Parse(c.Registry.APIURL)
+			if err != nil {
+				return sourceErrorf(c.source, "registry API URL %q is invalid: %v", c.Registry.APIURL, err)
+			}
+
+			if u.Scheme != "https" && u.Scheme != "http" {
+				return sourceErrorf(c.source, "registry API URL %q is invalid, must use http or https", c.Registry.APIURL)
+			}
+		}
+
+		if (c.Registry.ClientCertificate == "") != (c.Registry.ClientKey == "") {
+			return sourceErrorf(c.source, "registry clientCertificate and clientKey must be configured together")
+		}
+	}
+
+	if c.Vault != nil {
+		if err := validateTemplates(c.Vault.Fields); err != nil {
+			return sourceErrorf(c.source, "vault fields are invalid: %v", err)
+		}
+
+		if auth := c.Vault.Auth; auth != nil {
+			method := c.Vault.AuthMethod()
+
+			if !util.StringSliceContains(AllVaultAuthMethods, method) {
+				return sourceErrorf(c.source, "invalid Vault auth method %q, must be one of %v", method, AllVaultAuthMethods)
+			}
+
+			switch method {
+			case VaultAppRoleAuth:
+				if auth.RoleID == "" {
+					return sourceErrorf(c.source, "Vault AppRole auth requires a roleID")
+				}
+			case VaultKubernetesAuth, VaultJWTAuth:
+				if auth.Role == "" {
+					return sourceErrorf(c.source, "Vault %s auth requires a role", method)
+				}
+			}
+		}
+	}
+
 	robotNames := []string{}
+	robotSources := map[string]string{}
 	prefix := c.Organization + "+"
 
 	for _, robot := range c.Robots {
 		fullName := fmt.Sprintf("%s+%s", c.Organization, robot.Name)
 
-		if util.StringSliceContains(robotNames, fullName) {
-			return fmt.Errorf("duplicate robot %q defined", robot.Name)
+		if first, exists := robotSources[fullName]; exists {
+			return duplicateError("robot", robot.Name, robot.source, first)
 		}
 
 		if strings.HasPrefix(robot.Name, prefix) {
-			return fmt.Errorf("robot %q must be given as a short name, without the organization prefix (must be \"%s\")", robot.Name, strings.TrimPrefix(robot.Name, prefix))
+			return sourceErrorf(robot.source, "robot %q must be given as a short name, without the organization prefix (must be \"%s\")", robot.Name, strings.TrimPrefix(robot.Name, prefix))
 		}
 
 		if !robotRegexp.MatchString(robot.Name) {
-			return fmt.Errorf("robot name %q is invalid, must be %v", robot.Name, robotRegexp)
+			return sourceErrorf(robot.source, "robot name %q is invalid, must be %v", robot.Name, robotRegexp)
+		}
+
+		if interval, err := robot.RotationInterval(); err != nil {
+			return sourceErrorf(robot.source, "rotateAfter for robot %q is invalid: %v", robot.Name, err)
+		} else if interval < 0 {
+			return sourceErrorf(robot.source, "rotateAfter for robot %q must not be negative", robot.Name)
+		}
+
+		for _, pub := range robot.Publishers {
+			if !util.StringSliceContains(AllPublishers, pub) {
+				return sourceErrorf(robot.source, "invalid publisher %q for robot %q, must be one of %v", pub, robot.Name, AllPublishers)
+			}
+		}
+
+		if err := validateTemplates(robot.VaultFields); err != nil {
+			return sourceErrorf(robot.source, "vaultFields for robot %q are invalid: %v", robot.Name, err)
+		}
+
+		for _, secret := range robot.KubernetesSecrets {
+			if secret.Namespace == "" || secret.Name == "" {
+				return sourceErrorf(robot.source, "Kubernetes secret for robot %q must have both a namespace and a name", robot.Name)
+			}
 		}
 
 		robotNames = append(robotNames, fullName)
+		robotSources[fullName] = robot.source
 	}
 
 	teamNames := []string{}
+	teamSources := map[string]string{}
 
 	for _, team := range c.Teams {
-		if util.StringSliceContains(teamNames, team.Name) {
-			return fmt.Errorf("duplicate team %q defined", team.Name)
+		if first, exists := teamSources[team.Name]; exists {
+			return duplicateError("team", team.Name, team.source, first)
 		}
 
 		if !validTeamRole(team.Role) {
-			return fmt.Errorf("role for team %q is invalid (%q), must be one of %v", team.Name, team.Role, quay.AllTeamRoles)
+			return sourceErrorf(team.source, "role for team %q is invalid (%q), must be one of %v", team.Name, team.Role, quay.AllTeamRoles)
 		}
 
 		if !teamRegexp.MatchString(team.Name) {
-			return fmt.Errorf("team name %q is invalid, must be %v", team.Name, teamRegexp)
+			return sourceErrorf(team.source, "team name %q is invalid, must be %v", team.Name, teamRegexp)
 		}
 
 		teamNames = append(teamNames, team.Name)
+		teamSources[team.Name] = team.source
 
 		if client != nil {
 			for _, member := range team.Members {
 				if quay.IsRobotUsername(member) {
 					if !util.StringSliceContains(robotNames, member) {
-						return fmt.Errorf("robot %q in team %q does not exist", member, team.Name)
+						return sourceErrorf(team.source, "robot %q in team %q does not exist", member, team.Name)
 					}
 				} else if err := validateUsername(ctx, client, member, existingUsers); err != nil {
-					return fmt.Errorf("user %q in team %q is invalid: %v", member, team.Name, err)
+					return sourceErrorf(team.source, "user %q in team %q is invalid: %v", member, team.Name, err)
 				}
 			}
 		}
 	}
 
-	repoNames := []string{}
+	repoSources := map[string]string{}
 	visibilities := []string{
 		string(quay.Public),
 		string(quay.Private),
 	}
 
 	for _, repo := range c.Repositories {
-		if util.StringSliceContains(repoNames, repo.Name) {
-			return fmt.Errorf("duplicate repository %q defined", repo.Name)
+		if first, exists := repoSources[repo.Name]; exists {
+			return duplicateError("repository", repo.Name, repo.source, first)
 		}
 
 		if !util.StringSliceContains(visibilities, string(repo.Visibility)) {
-			return fmt.Errorf("invalid visibility %q for repository %q, must be one of %v", repo.Visibility, repo.Name, visibilities)
+			return sourceErrorf(repo.source, "invalid visibility %q for repository %q, must be one of %v", repo.Visibility, repo.Name, visibilities)
 		}
 
-		if !repoRegexp.MatchString(repo.Name) {
-			return fmt.Errorf("repository name %q is invalid, must be %v", repo.Name, repoRegexp)
+		if repo.IsWildcard() {
+			if !ruleRegexp.MatchString(repo.Name) {
+				return sourceErrorf(repo.source, "repository rule %q is invalid, must be %v", repo.Name, ruleRegexp)
+			}
+		} else if !repoRegexp.MatchString(repo.Name) {
+			return sourceErrorf(repo.source, "repository name %q is invalid, must be %v", repo.Name, repoRegexp)
 		}
 
 		for teamName, roleName := range repo.Teams {
 			if !util.StringSliceContains(teamNames, teamName) {
-				return fmt.Errorf("invalid team %q assigned to repo %q: team does not exist", teamName, repo.Name)
+				return sourceErrorf(repo.source, "invalid team %q assigned to repo %q: team does not exist", teamName, repo.Name)
 			}
 
 			if !validRepositoryRole(roleName) {
-				return fmt.Errorf("role for team %s in repo %q is invalid (%q), must be one of %v", teamName, repo.Name, roleName, quay.AllRepositoryRoles)
+				return sourceErrorf(repo.source, "role for team %s in repo %q is invalid (%q), must be one of %v", teamName, repo.Name, roleName, quay.AllRepositoryRoles)
 			}
 		}
 
 		for userName, roleName := range repo.Users {
 			if !validRepositoryRole(roleName) {
-				return fmt.Errorf("role for user %s in repo %q is invalid (%q), must be one of %v", userName, repo.Name, roleName, quay.AllRepositoryRoles)
+				return sourceErrorf(repo.source, "role for user %s in repo %q is invalid (%q), must be one of %v", userName, repo.Name, roleName, quay.AllRepositoryRoles)
 			}
 
 			if quay.IsRobotUsername(userName) {
 				if !util.StringSliceContains(robotNames, userName) {
-					return fmt.Errorf("invalid robot %q assigned to repo %q: robot does not exist", userName, repo.Name)
+					return sourceErrorf(repo.source, "invalid robot %q assigned to repo %q: robot does not exist", userName, repo.Name)
 				}
 			} else if client != nil {
 				if _, err := client.GetUser(ctx, userName); err != nil {
-					return fmt.Errorf("invalid user %q assigned to repo %q: user does not exist", userName, repo.Name)
+					return sourceErrorf(repo.source, "invalid user %q assigned to repo %q: user does not exist", userName, repo.Name)
 				}
 			}
 		}
 
-		repoNames = append(repoNames, repo.Name)
+		repoSources[repo.Name] = repo.source
+	}
+
+	defaultPermissionSources := map[string]string{}
+
+	for _, perm := range c.DefaultPermissions {
+		key := perm.Key()
+
+		if (perm.Team == "") == (perm.User == "") {
+			return sourceErrorf(perm.source, "default permission %q must have either a team or a user", key)
+		}
+
+		if first, exists := defaultPermissionSources[key]; exists {
+			return duplicateError("default permission", key, perm.source, first)
+		}
+
+		if !validRepositoryRole(perm.Role) {
+			return sourceErrorf(perm.source, "role for default permission %q is invalid (%q), must be one of %v", key, perm.Role, quay.AllRepositoryRoles)
+		}
+
+		if perm.Team != "" && !util.StringSliceContains(teamNames, perm.Team) {
+			return sourceErrorf(perm.source, "invalid team %q in default permission: team does not exist", perm.Team)
+		}
+
+		if perm.User != "" {
+			if quay.IsRobotUsername(perm.User) {
+				if !util.StringSliceContains(robotNames, perm.User) {
+					return sourceErrorf(perm.source, "invalid robot %q in default permission: robot does not exist", perm.User)
+				}
+			} else if client != nil {
+				if err := validateUsername(ctx, client, perm.User, existingUsers); err != nil {
+					return sourceErrorf(perm.source, "user %q in default permission is invalid: %v", perm.User, err)
+				}
+			}
+		}
+
+		if perm.ActivatingUser != "" && client != nil {
+			if err := validateUsername(ctx, client, perm.ActivatingUser, existingUsers); err != nil {
+				return sourceErrorf(perm.source, "activating user %q in default permission %q is invalid: %v", perm.ActivatingUser, key, err)
+			}
+		}
+
+		defaultPermissionSources[key] = perm.source
 	}
 
 	return nil
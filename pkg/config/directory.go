@@ -0,0 +1,290 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// The files and directories of a configuration directory. The main
+// file contains everything except teams, robots and repositories,
+// which are stored in one file per entry in their directories.
+const (
+	mainFilename          = "config.yaml"
+	teamsDirectory        = "teams"
+	robotsDirectory       = "robots"
+	repositoriesDirectory = "repositories"
+)
+
+// LoadFromDirectory loads a configuration that is split into multiple
+// files:
+//
+//	config.yaml            organization, registry, vault, people, groups, default permissions
+//	teams/*.yaml           one team per file
+//	robots/*.yaml          one robot per file
+//	repositories/*.yaml    one repository (or wildcard rule) per file
+//
+// The main file may contain teams, robots and repositories as well;
+// entries from the directories are appended to them. Files in each
+// directory are loaded in alphabetical order. A missing main file is
+// treated like an empty one, so that -export can fill a new directory.
+func LoadFromDirectory(dir string) (*Config, error) {
+	mainFile := filepath.Join(dir, mainFilename)
+
+	config := &Config{}
+	if err := decodeFile(mainFile, config); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load %s: %w", mainFile, err)
+	}
+
+	config.setSource(mainFile)
+
+	if err := config.loadIncludes(mainFile); err != nil {
+		return nil, err
+	}
+
+	err := loadEntries(filepath.Join(dir, teamsDirectory), func(filename string) error {
+		team := TeamConfig{source: filename}
+		if err := decodeFile(filename, &team); err != nil {
+			return err
+		}
+
+		config.Teams = append(config.Teams, team)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadEntries(filepath.Join(dir, robotsDirectory), func(filename string) error {
+		robot := RobotConfig{source: filename}
+		if err := decodeFile(filename, &robot); err != nil {
+			return err
+		}
+
+		config.Robots = append(config.Robots, robot)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadEntries(filepath.Join(dir, repositoriesDirectory), func(filename string) error {
+		repo := RepositoryConfig{source: filename}
+		if err := decodeFile(filename, &repo); err != nil {
+			return err
+		}
+
+		config.Repositories = append(config.Repositories, repo)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// loadEntries calls load for every YAML file in dir. A missing
+// directory is not an error.
+func loadEntries(dir string, load func(filename string) error) error {
+	files, err := yamlFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, filename := range files {
+		if err := load(filename); err != nil {
+			return fmt.Errorf("failed to load %s: %w", filename, err)
+		}
+	}
+
+	return nil
+}
+
+// yamlFiles returns the paths of all YAML files in dir, sorted by name.
+func yamlFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+
+		if !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	return files, nil
+}
+
+// UpdateDirectory writes the configuration into a directory, using the
+// layout described in LoadFromDirectory. Like UpdateFile, it keeps
+// comments and formatting and only writes files that changed. Entries
+// are written back into the files they were loaded from; new entries
+// get a file named after them. Files of entries that no longer exist
+// are deleted, entries from the main file or included files are written
+// back into them. The return value reports whether anything changed.
+func UpdateDirectory(config *Config, dir string) (bool, error) {
+	config, changed, err := updateIncludes(config)
+	if err != nil {
+		return false, err
+	}
+
+	mainFile := filepath.Join(dir, mainFilename)
+
+	mainConfig := *config
+	mainConfig.Teams = nil
+	mainConfig.Robots = nil
+	mainConfig.Repositories = nil
+
+	teams := map[string]interface{}{}
+	for i, team := range config.Teams {
+		if team.source == mainFile {
+			mainConfig.Teams = append(mainConfig.Teams, team)
+			continue
+		}
+
+		filename := entryFilename(dir, teamsDirectory, team.source, team.Name)
+		if err := addEntry(teams, filename, &config.Teams[i]); err != nil {
+			return false, err
+		}
+	}
+
+	robots := map[string]interface{}{}
+	for i, robot := range config.Robots {
+		if robot.source == mainFile {
+			mainConfig.Robots = append(mainConfig.Robots, robot)
+			continue
+		}
+
+		filename := entryFilename(dir, robotsDirectory, robot.source, robot.Name)
+		if err := addEntry(robots, filename, &config.Robots[i]); err != nil {
+			return false, err
+		}
+	}
+
+	repositories := map[string]interface{}{}
+	for i, repo := range config.Repositories {
+		if repo.source == mainFile {
+			mainConfig.Repositories = append(mainConfig.Repositories, repo)
+			continue
+		}
+
+		filename := entryFilename(dir, repositoriesDirectory, repo.source, repo.Name)
+		if err := addEntry(repositories, filename, &config.Repositories[i]); err != nil {
+			return false, err
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, err
+	}
+
+	mainChanged, err := updateFile(&mainConfig, mainFile)
+	if err != nil {
+		return false, err
+	}
+
+	changed = changed || mainChanged
+
+	for subdir, entries := range map[string]map[string]interface{}{
+		teamsDirectory:        teams,
+		robotsDirectory:       robots,
+		repositoriesDirectory: repositories,
+	} {
+		entriesChanged, err := updateEntries(filepath.Join(dir, subdir), entries)
+		if err != nil {
+			return false, err
+		}
+
+		changed = changed || entriesChanged
+	}
+
+	return changed, nil
+}
+
+// entryFilename returns the file an entry is written to: the file it
+// was loaded from if that is in the right directory, otherwise a file
+// named after the entry. The name is escaped, so that repositories like
+// "team/app" or wildcard rules get a single, valid filename.
+func entryFilename(dir string, subdir string, source string, name string) string {
+	subdir = filepath.Join(dir, subdir)
+
+	if source != "" && filepath.Dir(source) == subdir {
+		return source
+	}
+
+	return filepath.Join(subdir, url.PathEscape(name)+".yaml")
+}
+
+func addEntry(entries map[string]interface{}, filename string, entry interface{}) error {
+	if _, exists := entries[filename]; exists {
+		return fmt.Errorf("multiple entries would be written to %s", filename)
+	}
+
+	entries[filename] = entry
+
+	return nil
+}
+
+// updateEntries writes every entry into its file and deletes all other
+// YAML files in the directory.
+func updateEntries(dir string, entries map[string]interface{}) (bool, error) {
+	existing, err := yamlFiles(dir)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+
+	for _, filename := range existing {
+		if _, exists := entries[filename]; !exists {
+			if err := os.Remove(filename); err != nil {
+				return false, err
+			}
+
+			changed = true
+		}
+	}
+
+	if len(entries) == 0 {
+		return changed, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, err
+	}
+
+	for filename, entry := range entries {
+		entryChanged, err := updateEntry(entry, filename)
+		if err != nil {
+			return false, fmt.Errorf("failed to update %s: %w", filename, err)
+		}
+
+		changed = changed || entryChanged
+	}
+
+	return changed, nil
+}
+
+func updateEntry(entry interface{}, filename string) (bool, error) {
+	switch e := entry.(type) {
+	case *TeamConfig:
+		return updateFile(e, filename)
+	case *RobotConfig:
+		return updateFile(e, filename)
+	case *RepositoryConfig:
+		return updateFile(e, filename)
+	default:
+		return false, fmt.Errorf("unexpected entry %T", entry)
+	}
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+)
+
+func writeTestFile(t *testing.T, filename string, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", filename, err)
+	}
+}
+
+func readTestFile(t *testing.T, filename string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", filename, err)
+	}
+
+	return string(content)
+}
+
+func TestUpdateDirectoryKeepsMainFileEntries(t *testing.T) {
+	dir := t.TempDir()
+
+	writeTestFile(t, filepath.Join(dir, mainFilename), `organization: exampleorg
+teams:
+  - name: owners
+    role: admin
+`)
+
+	writeTestFile(t, filepath.Join(dir, teamsDirectory, "developers.yaml"), `name: developers
+role: member
+`)
+
+	cfg, err := LoadFromDirectory(dir)
+	if err != nil {
+		t.Fatalf("Failed to load directory: %v", err)
+	}
+
+	if len(cfg.Teams) != 2 {
+		t.Fatalf("Expected 2 teams, but got %+v.", cfg.Teams)
+	}
+
+	cfg.Robots = append(cfg.Robots, RobotConfig{Name: "ci"})
+
+	changed, err := UpdateDirectory(cfg, dir)
+	if err != nil {
+		t.Fatalf("Failed to update directory: %v", err)
+	}
+
+	if !changed {
+		t.Error("Expected the new robot to change the directory.")
+	}
+
+	if main := readTestFile(t, filepath.Join(dir, mainFilename)); !strings.Contains(main, "name: owners") {
+		t.Errorf("Expected owners to stay in %s, but got:\n%s", mainFilename, main)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, teamsDirectory, "owners.yaml")); err == nil {
+		t.Error("Expected owners to not be moved into its own file.")
+	}
+
+	readTestFile(t, filepath.Join(dir, robotsDirectory, "ci.yaml"))
+
+	// writing the loaded configuration again changes nothing
+	cfg, err = LoadFromDirectory(dir)
+	if err != nil {
+		t.Fatalf("Failed to load directory: %v", err)
+	}
+
+	changed, err = UpdateDirectory(cfg, dir)
+	if err != nil {
+		t.Fatalf("Failed to update directory: %v", err)
+	}
+
+	if changed {
+		t.Error("Expected the directory to be up-to-date.")
+	}
+}
+
+func TestUpdateNewDirectory(t *testing.T) {
+	for _, name := range []string{"empty", "missing"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if name == "missing" {
+				dir = filepath.Join(dir, "exampleorg")
+			} else {
+				cfg, err := LoadFromDirectory(dir)
+				if err != nil {
+					t.Fatalf("Failed to load empty directory: %v", err)
+				}
+
+				if cfg.Organization != "" || len(cfg.Teams) > 0 {
+					t.Fatalf("Expected an empty configuration, but got %+v.", cfg)
+				}
+			}
+
+			cfg := &Config{
+				Organization: "exampleorg",
+				Teams:        []TeamConfig{{Name: "owners", Role: quay.AdminTeamRole}},
+				Repositories: []RepositoryConfig{{Name: "team/app", Visibility: quay.Private}},
+			}
+
+			if _, err := UpdateDirectory(cfg, dir); err != nil {
+				t.Fatalf("Failed to update directory: %v", err)
+			}
+
+			loaded, err := LoadFromDirectory(dir)
+			if err != nil {
+				t.Fatalf("Failed to load directory: %v", err)
+			}
+
+			if loaded.Organization != "exampleorg" || len(loaded.Teams) != 1 || len(loaded.Repositories) != 1 {
+				t.Fatalf("Expected the written configuration, but got %+v.", loaded)
+			}
+
+			readTestFile(t, filepath.Join(dir, repositoriesDirectory, "team%2Fapp.yaml"))
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+	"github.com/kubermatic-labs/aquayman/pkg/util"
+)
+
+// GroupPrefix marks a reference to a group in team members and
+// repository users, e.g. "@developers".
+const GroupPrefix = "@"
+
+// IsGroupReference returns true if the member or user name refers to
+// a group instead of a single user.
+func IsGroupReference(name string) bool {
+	return strings.HasPrefix(name, GroupPrefix)
+}
+
+// GroupReference returns the reference to the given group.
+func GroupReference(group string) string {
+	return GroupPrefix + group
+}
+
+// validateGroups checks the group definitions themselves; references
+// are checked when the groups are expanded.
+func (c *Config) validateGroups() error {
+	groupSources := map[string]string{}
+
+	for _, group := range c.Groups {
+		if first, exists := groupSources[group.Name]; exists {
+			return duplicateError("group", group.Name, group.source, first)
+		}
+
+		if !groupRegexp.MatchString(group.Name) {
+			return sourceErrorf(group.source, "group name %q is invalid, must be %v", group.Name, groupRegexp)
+		}
+
+		if len(group.Members) == 0 {
+			return sourceErrorf(group.source, "group %q has no members", group.Name)
+		}
+
+		groupSources[group.Name] = group.source
+	}
+
+	for _, group := range c.Groups {
+		if _, err := c.GroupMembers(group.Name); err != nil {
+			return sourceErrorf(group.source, "%v", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) group(name string) *GroupConfig {
+	for i, group := range c.Groups {
+		if group.Name == name {
+			return &c.Groups[i]
+		}
+	}
+
+	return nil
+}
+
+// GroupMembers returns all users and robots of a group, including the
+// members of nested groups, in the order they are listed and without
+// duplicates.
+func (c *Config) GroupMembers(name string) ([]string, error) {
+	return c.groupMembers(name, nil)
+}
+
+func (c *Config) groupMembers(name string, path []string) ([]string, error) {
+	for i, parent := range path {
+		if parent == name {
+			cycle := append(path[i:], name)
+			return nil, fmt.Errorf("group %q contains itself (%s)", name, strings.Join(cycle, " -> "))
+		}
+	}
+
+	path = append(path, name)
+
+	group := c.group(name)
+	if group == nil {
+		if len(path) > 1 {
+			return nil, fmt.Errorf("group %q refers to unknown group %q", path[len(path)-2], name)
+		}
+
+		return nil, fmt.Errorf("group %q does not exist", name)
+	}
+
+	members := []string{}
+	for _, member := range group.Members {
+		users, err := c.expandMember(member, path)
+		if err != nil {
+			// errors of nested groups already name the group
+			if IsGroupReference(member) {
+				return nil, err
+			}
+
+			return nil, fmt.Errorf("invalid member %q in group %q: %w", member, name, err)
+		}
+
+		for _, user := range users {
+			if !util.StringSliceContains(members, user) {
+				members = append(members, user)
+			}
+		}
+	}
+
+	return members, nil
+}
+
+// expandMember returns the users a team, group or repository member
+// stands for: the members of a group, the Quay username of a person or
+// just the member itself.
+func (c *Config) expandMember(member string, path []string) ([]string, error) {
+	if IsGroupReference(member) {
+		return c.groupMembers(strings.TrimPrefix(member, GroupPrefix), path)
+	}
+
+	username, err := c.ResolvePerson(member)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{username}, nil
+}
+
+// ExpandGroups returns a copy of the configuration in which all group
+// references in team members and repository users are replaced by the
+// members of the groups, and people are replaced by their Quay
+// usernames (see ResolvePerson). If a repository grants a user a role both
+// directly and via a group, the direct role wins; if a user is in
+// multiple groups of a repository, the highest role is used.
+func (c *Config) ExpandGroups() (*Config, error) {
+	result := *c
+	result.Teams = []TeamConfig{}
+	result.Repositories = []RepositoryConfig{}
+
+	for _, team := range c.Teams {
+		members := []string{}
+
+		for _, member := range team.Members {
+			users, err := c.expandMember(member, nil)
+			if err != nil {
+				return nil, sourceErrorf(team.source, "invalid member %q in team %q: %v", member, team.Name, err)
+			}
+
+			for _, user := range users {
+				if !util.StringSliceContains(members, user) {
+					members = append(members, user)
+				}
+			}
+		}
+
+		team.Members = members
+		result.Teams = append(result.Teams, team)
+	}
+
+	for _, repo := range c.Repositories {
+		if repo.Users != nil {
+			users := map[string]quay.RepositoryRole{}
+
+			// a person can be listed by username and by email address,
+			// which would leave it to chance which role is used
+			names := map[string]string{}
+
+			sortedUsers := []string{}
+			for name := range repo.Users {
+				sortedUsers = append(sortedUsers, name)
+			}
+
+			sort.Strings(sortedUsers)
+
+			for _, name := range sortedUsers {
+				if IsGroupReference(name) {
+					continue
+				}
+
+				username, err := c.ResolvePerson(name)
+				if err != nil {
+					return nil, sourceErrorf(repo.source, "invalid user %q assigned to repo %q: %v", name, repo.Name, err)
+				}
+
+				if first, exists := names[username]; exists {
+					return nil, sourceErrorf(repo.source, "duplicate user %q assigned to repo %q, already assigned as %q", name, repo.Name, first)
+				}
+
+				names[username] = name
+				users[username] = repo.Users[name]
+			}
+
+			// roles granted via groups
+			groupRoles := map[string]quay.RepositoryRole{}
+
+			for name, role := range repo.Users {
+				if !IsGroupReference(name) {
+					continue
+				}
+
+				members, err := c.expandMember(name, nil)
+				if err != nil {
+					return nil, sourceErrorf(repo.source, "invalid user %q assigned to repo %q: %v", name, repo.Name, err)
+				}
+
+				for _, member := range members {
+					if current, exists := groupRoles[member]; !exists || repositoryRoleRank(role) > repositoryRoleRank(current) {
+						groupRoles[member] = role
+					}
+				}
+			}
+
+			for member, role := range groupRoles {
+				if _, exists := users[member]; !exists {
+					users[member] = role
+				}
+			}
+
+			repo.Users = users
+		}
+
+		result.Repositories = append(result.Repositories, repo)
+	}
+
+	return &result, nil
+}
+
+func repositoryRoleRank(role quay.RepositoryRole) int {
+	for i, r := range quay.AllRepositoryRoles {
+		if r == role {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+)
+
+func TestGroupMembers(t *testing.T) {
+	testcases := []struct {
+		name     string
+		groups   []GroupConfig
+		group    string
+		expected []string
+		err      string
+	}{
+		{
+			name: "plain members",
+			groups: []GroupConfig{
+				{Name: "developers", Members: []string{"alice", "bob"}},
+			},
+			group:    "developers",
+			expected: []string{"alice", "bob"},
+		},
+		{
+			name: "nested groups",
+			groups: []GroupConfig{
+				{Name: "everyone", Members: []string{"@developers", "carol", "@admins"}},
+				{Name: "developers", Members: []string{"alice", "@admins"}},
+				{Name: "admins", Members: []string{"bob", "carol"}},
+			},
+			group:    "everyone",
+			expected: []string{"alice", "bob", "carol"},
+		},
+		{
+			name: "cycle",
+			groups: []GroupConfig{
+				{Name: "a", Members: []string{"@b"}},
+				{Name: "b", Members: []string{"alice", "@c"}},
+				{Name: "c", Members: []string{"@a"}},
+			},
+			group: "a",
+			err:   `group "a" contains itself (a -> b -> c -> a)`,
+		},
+		{
+			name: "group containing itself",
+			groups: []GroupConfig{
+				{Name: "a", Members: []string{"@a"}},
+			},
+			group: "a",
+			err:   `group "a" contains itself (a -> a)`,
+		},
+		{
+			name: "unknown nested group",
+			groups: []GroupConfig{
+				{Name: "developers", Members: []string{"alice", "@missing"}},
+			},
+			group: "developers",
+			err:   `group "developers" refers to unknown group "missing"`,
+		},
+		{
+			name:  "unknown group",
+			group: "missing",
+			err:   `group "missing" does not exist`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{Groups: tc.groups}
+
+			members, err := cfg.GroupMembers(tc.group)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Fatalf("Expected error %q, but got %v.", tc.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to get group members: %v", err)
+			}
+
+			if !reflect.DeepEqual(members, tc.expected) {
+				t.Fatalf("Expected members %v, but got %v.", tc.expected, members)
+			}
+		})
+	}
+}
+
+func TestExpandGroups(t *testing.T) {
+	groups := []GroupConfig{
+		{Name: "developers", Members: []string{"alice", "bob"}},
+		{Name: "admins", Members: []string{"bob", "carol"}},
+		{Name: "everyone", Members: []string{"@developers", "@admins"}},
+	}
+
+	testcases := []struct {
+		name     string
+		members  []string
+		users    map[string]quay.RepositoryRole
+		expected map[string]quay.RepositoryRole
+		err      string
+	}{
+		{
+			name:    "nested groups",
+			members: []string{"@everyone", "dave"},
+			users: map[string]quay.RepositoryRole{
+				"@everyone": quay.ReadRepositoryRole,
+			},
+			expected: map[string]quay.RepositoryRole{
+				"alice": quay.ReadRepositoryRole,
+				"bob":   quay.ReadRepositoryRole,
+				"carol": quay.ReadRepositoryRole,
+			},
+		},
+		{
+			name: "highest group role wins",
+			users: map[string]quay.RepositoryRole{
+				"@developers": quay.AdminRepositoryRole,
+				"@admins":     quay.ReadRepositoryRole,
+			},
+			expected: map[string]quay.RepositoryRole{
+				"alice": quay.AdminRepositoryRole,
+				"bob":   quay.AdminRepositoryRole,
+				"carol": quay.ReadRepositoryRole,
+			},
+		},
+		{
+			name: "direct role wins",
+			users: map[string]quay.RepositoryRole{
+				"@admins": quay.AdminRepositoryRole,
+				"bob":     quay.ReadRepositoryRole,
+			},
+			expected: map[string]quay.RepositoryRole{
+				"bob":   quay.ReadRepositoryRole,
+				"carol": quay.AdminRepositoryRole,
+			},
+		},
+		{
+			name:    "unknown group in team",
+			members: []string{"@missing"},
+			err:     `invalid member "@missing" in team "team": group "missing" does not exist`,
+		},
+		{
+			name: "unknown group in repository",
+			users: map[string]quay.RepositoryRole{
+				"@missing": quay.ReadRepositoryRole,
+			},
+			err: `invalid user "@missing" assigned to repo "app": group "missing" does not exist`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{
+				Groups: groups,
+				Teams: []TeamConfig{
+					{Name: "team", Role: quay.MemberTeamRole, Members: tc.members},
+				},
+				Repositories: []RepositoryConfig{
+					{Name: "app", Visibility: quay.Private, Users: tc.users},
+				},
+			}
+
+			expanded, err := cfg.ExpandGroups()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Expected error %q, but got %v.", tc.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to expand groups: %v", err)
+			}
+
+			if tc.members != nil {
+				expected := []string{"alice", "bob", "carol", "dave"}
+				if members := expanded.Teams[0].Members; !reflect.DeepEqual(members, expected) {
+					t.Errorf("Expected team members %v, but got %v.", expected, members)
+				}
+			}
+
+			if users := expanded.Repositories[0].Users; !reflect.DeepEqual(users, tc.expected) {
+				t.Errorf("Expected repository users %v, but got %v.", tc.expected, users)
+			}
+
+			// the original configuration must not be modified
+			if cfg.Teams[0].Members != nil && !reflect.DeepEqual(cfg.Teams[0].Members, tc.members) {
+				t.Errorf("Expected the configuration to be unchanged, but got %v.", cfg.Teams[0].Members)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LoadFromFiles loads the first file (or directory) like LoadFromFile
+// and then includes all other files, as if they were listed in the
+// first file's include list.
+func LoadFromFiles(filenames ...string) (*Config, error) {
+	if len(filenames) == 0 {
+		return nil, fmt.Errorf("no configuration files given")
+	}
+
+	config, err := LoadFromFile(filenames[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range filenames[1:] {
+		if err := config.includeFile(filename); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+// loadIncludes loads all files included by the given file, which
+// must already have been loaded into the configuration.
+func (c *Config) loadIncludes(filename string) error {
+	patterns := c.Include
+	if filename != c.source {
+		patterns = c.includes[filename]
+	}
+
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(filename), pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return sourceErrorf(filename, "invalid include %q: %v", pattern, err)
+		}
+
+		// a pattern without wildcards must match an existing file
+		if len(matches) == 0 && !hasMeta(pattern) {
+			return sourceErrorf(filename, "included file %s does not exist", pattern)
+		}
+
+		for _, match := range matches {
+			if err := c.includeFile(match); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// includeFile appends all people, groups and entries of the file to the configuration.
+// Every file is only included once, so that multiple files can include
+// the same shared file.
+func (c *Config) includeFile(filename string) error {
+	if c.isLoaded(filename) {
+		return nil
+	}
+
+	included := &Config{}
+	if err := decodeFile(filename, included); err != nil {
+		return fmt.Errorf("failed to load %s: %w", filename, err)
+	}
+
+	// only the main file describes the organization itself
+	if included.Organization != "" || len(included.Organizations) > 0 || included.Registry != nil || included.Vault != nil {
+		return sourceErrorf(filename, "organization, registry and vault can only be configured in the main file")
+	}
+
+	included.setSource(filename)
+
+	if c.includes == nil {
+		c.includes = map[string][]string{}
+	}
+
+	c.includes[filename] = included.Include
+	c.People = append(c.People, included.People...)
+	c.Groups = append(c.Groups, included.Groups...)
+	c.Teams = append(c.Teams, included.Teams...)
+	c.Robots = append(c.Robots, included.Robots...)
+	c.Repositories = append(c.Repositories, included.Repositories...)
+	c.DefaultPermissions = append(c.DefaultPermissions, included.DefaultPermissions...)
+
+	return c.loadIncludes(filename)
+}
+
+func (c *Config) isLoaded(filename string) bool {
+	for _, loaded := range append(c.IncludedFiles(), c.source) {
+		if sameFile(loaded, filename) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sameFile(a string, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+
+	return errA == nil && errB == nil && absA == absB
+}
+
+// IncludedFiles returns the paths of all files that were included,
+// sorted by name.
+func (c *Config) IncludedFiles() []string {
+	files := []string{}
+	for filename := range c.includes {
+		files = append(files, filename)
+	}
+
+	sort.Strings(files)
+
+	return files
+}
+
+// splitIncludes returns a copy of the configuration without the entries
+// from included files, and one configuration for each included file
+// with its entries and include list.
+func (c *Config) splitIncludes() (*Config, map[string]*Config) {
+	mainConfig := *c
+	mainConfig.People = nil
+	mainConfig.Groups = nil
+	mainConfig.Teams = nil
+	mainConfig.Robots = nil
+	mainConfig.Repositories = nil
+	mainConfig.DefaultPermissions = nil
+
+	files := map[string]*Config{}
+	for filename, include := range c.includes {
+		files[filename] = &Config{Include: include}
+	}
+
+	for _, person := range c.People {
+		if file, ok := files[person.source]; ok {
+			file.People = append(file.People, person)
+		} else {
+			mainConfig.People = append(mainConfig.People, person)
+		}
+	}
+
+	for _, group := range c.Groups {
+		if file, ok := files[group.source]; ok {
+			file.Groups = append(file.Groups, group)
+		} else {
+			mainConfig.Groups = append(mainConfig.Groups, group)
+		}
+	}
+
+	for _, team := range c.Teams {
+		if file, ok := files[team.source]; ok {
+			file.Teams = append(file.Teams, team)
+		} else {
+			mainConfig.Teams = append(mainConfig.Teams, team)
+		}
+	}
+
+	for _, robot := range c.Robots {
+		if file, ok := files[robot.source]; ok {
+			file.Robots = append(file.Robots, robot)
+		} else {
+			mainConfig.Robots = append(mainConfig.Robots, robot)
+		}
+	}
+
+	for _, repo := range c.Repositories {
+		if file, ok := files[repo.source]; ok {
+			file.Repositories = append(file.Repositories, repo)
+		} else {
+			mainConfig.Repositories = append(mainConfig.Repositories, repo)
+		}
+	}
+
+	for _, perm := range c.DefaultPermissions {
+		if file, ok := files[perm.source]; ok {
+			file.DefaultPermissions = append(file.DefaultPermissions, perm)
+		} else {
+			mainConfig.DefaultPermissions = append(mainConfig.DefaultPermissions, perm)
+		}
+	}
+
+	return &mainConfig, files
+}
+
+// updateIncludes writes the entries of all included files back into
+// them and returns the remaining configuration.
+func updateIncludes(config *Config) (*Config, bool, error) {
+	mainConfig, files := config.splitIncludes()
+	changed := false
+
+	for _, filename := range config.IncludedFiles() {
+		fileChanged, err := updateFile(files[filename], filename)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to update %s: %w", filename, err)
+		}
+
+		changed = changed || fileChanged
+	}
+
+	return mainConfig, changed, nil
+}
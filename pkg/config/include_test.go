@@ -0,0 +1,195 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+)
+
+// writeIncludeTree creates a main file that includes all team files,
+// which in turn all include the same shared file.
+func writeIncludeTree(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	writeTestFile(t, filepath.Join(dir, "config.yaml"), `organization: exampleorg
+include:
+  - teams/*.yaml
+  - shared/people.yaml
+robots:
+  - name: ci
+`)
+
+	writeTestFile(t, filepath.Join(dir, "teams", "backend.yaml"), `include:
+  - ../shared/people.yaml
+teams:
+  # the backend team
+  - name: backend
+    role: member
+    members:
+      - alice@example.com
+`)
+
+	writeTestFile(t, filepath.Join(dir, "teams", "frontend.yaml"), `include:
+  - ../shared/people.yaml
+teams:
+  - name: frontend
+    role: member
+    members:
+      - alice@example.com
+`)
+
+	writeTestFile(t, filepath.Join(dir, "shared", "people.yaml"), `people:
+  - email: alice@example.com
+    username: alice
+`)
+
+	return filepath.Join(dir, "config.yaml")
+}
+
+func TestLoadIncludes(t *testing.T) {
+	filename := writeIncludeTree(t)
+
+	cfg, err := LoadFromFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if len(cfg.Teams) != 2 || cfg.Teams[0].Name != "backend" || cfg.Teams[1].Name != "frontend" {
+		t.Errorf("Expected the teams of all included files, but got %+v.", cfg.Teams)
+	}
+
+	// the shared file is included three times, but loaded once
+	if len(cfg.People) != 1 {
+		t.Errorf("Expected the shared file to be loaded once, but got %+v.", cfg.People)
+	}
+
+	dir := filepath.Dir(filename)
+	expected := []string{
+		filepath.Join(dir, "shared", "people.yaml"),
+		filepath.Join(dir, "teams", "backend.yaml"),
+		filepath.Join(dir, "teams", "frontend.yaml"),
+	}
+
+	if files := cfg.IncludedFiles(); strings.Join(files, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected included files %v, but got %v.", expected, files)
+	}
+}
+
+func TestLoadIncludeErrors(t *testing.T) {
+	testcases := []struct {
+		name     string
+		include  string
+		included string
+		err      string
+	}{
+		{
+			name:    "glob without matches",
+			include: "missing/*.yaml",
+		},
+		{
+			name:    "missing file",
+			include: "missing.yaml",
+			err:     "does not exist",
+		},
+		{
+			name:     "organization",
+			include:  "other.yaml",
+			included: "organization: otherorg\n",
+			err:      "can only be configured in the main file",
+		},
+		{
+			name:     "organizations",
+			include:  "other.yaml",
+			included: "organizations: [otherorg]\n",
+			err:      "can only be configured in the main file",
+		},
+		{
+			name:     "vault",
+			include:  "other.yaml",
+			included: "vault:\n  checkAndSet: true\n",
+			err:      "can only be configured in the main file",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "config.yaml")
+
+			writeTestFile(t, filename, "organization: exampleorg\ninclude:\n  - "+tc.include+"\n")
+
+			if tc.included != "" {
+				writeTestFile(t, filepath.Join(dir, tc.include), tc.included)
+			}
+
+			_, err := LoadFromFile(filename)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("Failed to load configuration: %v", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("Expected error containing %q, but got %v.", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestUpdateIncludes(t *testing.T) {
+	filename := writeIncludeTree(t)
+	dir := filepath.Dir(filename)
+
+	cfg, err := LoadFromFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	frontend := readTestFile(t, filepath.Join(dir, "teams", "frontend.yaml"))
+	people := readTestFile(t, filepath.Join(dir, "shared", "people.yaml"))
+
+	cfg.Teams[0].Role = quay.AdminTeamRole
+	cfg.Robots = append(cfg.Robots, RobotConfig{Name: "deploy"})
+
+	changed, err := UpdateFile(cfg, filename)
+	if err != nil {
+		t.Fatalf("Failed to update configuration: %v", err)
+	}
+
+	if !changed {
+		t.Fatal("Expected the configuration to be updated.")
+	}
+
+	backend := readTestFile(t, filepath.Join(dir, "teams", "backend.yaml"))
+	if !strings.Contains(backend, "role: admin") || !strings.Contains(backend, "# the backend team") {
+		t.Errorf("Expected the team to be updated in its own file, but got:\n%s", backend)
+	}
+
+	if main := readTestFile(t, filename); !strings.Contains(main, "name: deploy") || strings.Contains(main, "backend") {
+		t.Errorf("Expected only the new robot to be added to the main file, but got:\n%s", main)
+	}
+
+	if content := readTestFile(t, filepath.Join(dir, "teams", "frontend.yaml")); content != frontend {
+		t.Errorf("Expected the unchanged file to be left alone, but got:\n%s", content)
+	}
+
+	if content := readTestFile(t, filepath.Join(dir, "shared", "people.yaml")); content != people {
+		t.Errorf("Expected the shared file to be left alone, but got:\n%s", content)
+	}
+
+	// the written files load into the same configuration
+	loaded, err := LoadFromFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if len(loaded.Teams) != 2 || loaded.Teams[0].Role != quay.AdminTeamRole || len(loaded.Robots) != 2 || len(loaded.People) != 1 {
+		t.Errorf("Expected the updated configuration, but got %+v.", loaded)
+	}
+}
@@ -0,0 +1,268 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+	"github.com/kubermatic-labs/aquayman/pkg/util"
+)
+
+// IsMultiOrganization returns true if the configuration manages
+// multiple organizations (see Organizations).
+func (c *Config) IsMultiOrganization() bool {
+	return len(c.Organizations) > 0
+}
+
+// OrganizationNames returns all organizations managed by the
+// configuration.
+func (c *Config) OrganizationNames() []string {
+	if c.IsMultiOrganization() {
+		return c.Organizations
+	}
+
+	return []string{c.Organization}
+}
+
+// ForOrganization returns the configuration of a single organization,
+// containing only the entries that apply to it. The result can be
+// used like a configuration that only manages this organization.
+func (c *Config) ForOrganization(org string) *Config {
+	result := *c
+	result.Organization = org
+	result.Organizations = nil
+	result.Teams = []TeamConfig{}
+	result.Repositories = []RepositoryConfig{}
+	result.Robots = nil
+	result.DefaultPermissions = nil
+
+	for _, team := range c.Teams {
+		if appliesTo(team.Organizations, org) {
+			team.Organizations = nil
+			result.Teams = append(result.Teams, team)
+		}
+	}
+
+	for _, repo := range c.Repositories {
+		if appliesTo(repo.Organizations, org) {
+			repo.Organizations = nil
+			result.Repositories = append(result.Repositories, repo)
+		}
+	}
+
+	for _, robot := range c.Robots {
+		if appliesTo(robot.Organizations, org) {
+			robot.Organizations = nil
+			result.Robots = append(result.Robots, robot)
+		}
+	}
+
+	for _, perm := range c.DefaultPermissions {
+		if appliesTo(perm.Organizations, org) {
+			perm.Organizations = nil
+			result.DefaultPermissions = append(result.DefaultPermissions, perm)
+		}
+	}
+
+	return &result
+}
+
+func appliesTo(organizations []string, org string) bool {
+	return len(organizations) == 0 || util.StringSliceContains(organizations, org)
+}
+
+// validateOrganizations validates a multi-organization configuration,
+// by validating the configuration of each organization on its own.
+func (c *Config) validateOrganizations(ctx context.Context, client quay.API) error {
+	if c.Organization != "" {
+		return sourceErrorf(c.source, "organization and organizations cannot be configured at the same time")
+	}
+
+	if err := c.validateEntryOrganizations(); err != nil {
+		return err
+	}
+
+	seen := []string{}
+	for _, org := range c.Organizations {
+		if util.StringSliceContains(seen, org) {
+			return sourceErrorf(c.source, "duplicate organization %q defined", org)
+		}
+
+		seen = append(seen, org)
+	}
+
+	for _, robot := range c.Robots {
+		orgs := robot.Organizations
+		if len(orgs) == 0 {
+			orgs = c.Organizations
+		}
+
+		if len(orgs) < 2 {
+			continue
+		}
+
+		// the same secret would be overwritten by every organization
+		if len(robot.KubernetesSecrets) > 0 {
+			return sourceErrorf(robot.source, "robot %q is used in multiple organizations and cannot have kubernetesSecrets", robot.Name)
+		}
+
+		if strings.Contains(robot.VaultSecret, "#") {
+			return sourceErrorf(robot.source, "robot %q is used in multiple organizations and cannot have a custom Vault key", robot.Name)
+		}
+	}
+
+	for _, org := range c.Organizations {
+		if err := c.ForOrganization(org).Validate(ctx, client); err != nil {
+			return fmt.Errorf("organization %s: %w", org, err)
+		}
+	}
+
+	return nil
+}
+
+// validateEntryOrganizations ensures that all entries are only
+// restricted to organizations that are actually configured.
+func (c *Config) validateEntryOrganizations() error {
+	check := func(kind string, name string, source string, organizations []string) error {
+		for _, org := range organizations {
+			if !util.StringSliceContains(c.OrganizationNames(), org) {
+				return sourceErrorf(source, "%s %q refers to unknown organization %q", kind, name, org)
+			}
+		}
+
+		return nil
+	}
+
+	for _, team := range c.Teams {
+		if err := check("team", team.Name, team.source, team.Organizations); err != nil {
+			return err
+		}
+	}
+
+	for _, repo := range c.Repositories {
+		if err := check("repository", repo.Name, repo.source, repo.Organizations); err != nil {
+			return err
+		}
+	}
+
+	for _, robot := range c.Robots {
+		if err := check("robot", robot.Name, robot.source, robot.Organizations); err != nil {
+			return err
+		}
+	}
+
+	for _, perm := range c.DefaultPermissions {
+		if err := check("default permission", perm.Key(), perm.source, perm.Organizations); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CombineOrganizations is the inverse of ForOrganization: it replaces
+// all entries with those of the given per-organization configurations.
+// Entries that are identical in multiple organizations are combined into
+// a single entry, which is restricted to these organizations unless it
+// applies to all of them. Organizations without a configuration keep
+// their current entries.
+func (c *Config) CombineOrganizations(configs map[string]*Config) error {
+	orgs := c.OrganizationNames()
+
+	views := map[string]*Config{}
+	for _, org := range orgs {
+		if cfg, exists := configs[org]; exists {
+			views[org] = cfg
+		} else {
+			views[org] = c.ForOrganization(org)
+		}
+	}
+
+	teams, err := combineEntries(orgs, views, func(cfg *Config) []TeamConfig { return cfg.Teams },
+		func(team TeamConfig) string { return team.Name },
+		func(team *TeamConfig) *[]string { return &team.Organizations })
+	if err != nil {
+		return err
+	}
+
+	repositories, err := combineEntries(orgs, views, func(cfg *Config) []RepositoryConfig { return cfg.Repositories },
+		func(repo RepositoryConfig) string { return repo.Name },
+		func(repo *RepositoryConfig) *[]string { return &repo.Organizations })
+	if err != nil {
+		return err
+	}
+
+	robots, err := combineEntries(orgs, views, func(cfg *Config) []RobotConfig { return cfg.Robots },
+		func(robot RobotConfig) string { return robot.Name },
+		func(robot *RobotConfig) *[]string { return &robot.Organizations })
+	if err != nil {
+		return err
+	}
+
+	permissions, err := combineEntries(orgs, views, func(cfg *Config) []DefaultPermissionConfig { return cfg.DefaultPermissions },
+		func(perm DefaultPermissionConfig) string { return perm.Key() },
+		func(perm *DefaultPermissionConfig) *[]string { return &perm.Organizations })
+	if err != nil {
+		return err
+	}
+
+	c.Teams = teams
+	c.Repositories = repositories
+	c.Robots = robots
+	c.DefaultPermissions = permissions
+
+	return nil
+}
+
+// combineEntries groups identical entries of all organizations, keeping
+// the order in which they first appear.
+func combineEntries[T any](orgs []string, views map[string]*Config, entries func(*Config) []T, name func(T) string, organizations func(*T) *[]string) ([]T, error) {
+	type group struct {
+		entry T
+		orgs  []string
+	}
+
+	groups := []*group{}
+	groupsByKey := map[string]*group{}
+
+	for _, org := range orgs {
+		for _, entry := range entries(views[org]) {
+			*organizations(&entry) = nil
+
+			// the unexported source is ignored, so entries from
+			// different files can be combined
+			encoded, err := yaml.Marshal(entry)
+			if err != nil {
+				return nil, err
+			}
+
+			key := name(entry) + "\x00" + string(encoded)
+
+			g, exists := groupsByKey[key]
+			if !exists {
+				g = &group{entry: entry}
+				groups = append(groups, g)
+				groupsByKey[key] = g
+			}
+
+			// duplicates within an organization are reported by Validate
+			if !util.StringSliceContains(g.orgs, org) {
+				g.orgs = append(g.orgs, org)
+			}
+		}
+	}
+
+	result := []T{}
+	for _, g := range groups {
+		if len(g.orgs) < len(orgs) {
+			*organizations(&g.entry) = g.orgs
+		}
+
+		result = append(result, g.entry)
+	}
+
+	return result, nil
+}
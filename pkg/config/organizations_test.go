@@ -0,0 +1,139 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+)
+
+func newMultiOrganizationConfig() *Config {
+	return &Config{
+		Organizations: []string{"org-a", "org-b", "org-c"},
+		Teams: []TeamConfig{
+			{Name: "owners", Role: quay.AdminTeamRole},
+			{Name: "developers", Role: quay.MemberTeamRole, Organizations: []string{"org-a", "org-b"}},
+		},
+		Repositories: []RepositoryConfig{
+			{Name: "app", Visibility: quay.Public, Organizations: []string{"org-b"}},
+		},
+		Robots: []RobotConfig{
+			{Name: "ci", Organizations: []string{"org-c"}},
+		},
+		DefaultPermissions: []DefaultPermissionConfig{
+			{Team: "owners", Role: quay.AdminRepositoryRole},
+		},
+	}
+}
+
+func entryNames(cfg *Config) []string {
+	names := []string{}
+
+	for _, team := range cfg.Teams {
+		names = append(names, "team "+team.Name)
+	}
+
+	for _, repo := range cfg.Repositories {
+		names = append(names, "repository "+repo.Name)
+	}
+
+	for _, robot := range cfg.Robots {
+		names = append(names, "robot "+robot.Name)
+	}
+
+	for _, perm := range cfg.DefaultPermissions {
+		names = append(names, "default permission "+perm.Key())
+	}
+
+	return names
+}
+
+func TestForOrganization(t *testing.T) {
+	testcases := []struct {
+		org      string
+		expected []string
+	}{
+		{
+			org:      "org-a",
+			expected: []string{"team owners", "team developers", "default permission team:owners"},
+		},
+		{
+			org:      "org-b",
+			expected: []string{"team owners", "team developers", "repository app", "default permission team:owners"},
+		},
+		{
+			org:      "org-c",
+			expected: []string{"team owners", "robot ci", "default permission team:owners"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.org, func(t *testing.T) {
+			cfg := newMultiOrganizationConfig()
+			orgConfig := cfg.ForOrganization(tc.org)
+
+			if orgConfig.Organization != tc.org || orgConfig.IsMultiOrganization() {
+				t.Fatalf("Expected a configuration for %s alone, but got %q and %v.", tc.org, orgConfig.Organization, orgConfig.Organizations)
+			}
+
+			if names := entryNames(orgConfig); !reflect.DeepEqual(names, tc.expected) {
+				t.Fatalf("Expected entries %v, but got %v.", tc.expected, names)
+			}
+
+			for _, team := range orgConfig.Teams {
+				if team.Organizations != nil {
+					t.Errorf("Expected team %s to not be restricted anymore, but got %v.", team.Name, team.Organizations)
+				}
+			}
+
+			// the original configuration must not be modified
+			if !reflect.DeepEqual(cfg, newMultiOrganizationConfig()) {
+				t.Errorf("Expected the configuration to be unchanged, but got %+v.", cfg)
+			}
+		})
+	}
+}
+
+func TestCombineOrganizations(t *testing.T) {
+	cfg := newMultiOrganizationConfig()
+
+	configs := map[string]*Config{}
+	for _, org := range cfg.OrganizationNames() {
+		configs[org] = cfg.ForOrganization(org)
+	}
+
+	// splitting and combining the configuration again is lossless
+	if err := cfg.CombineOrganizations(configs); err != nil {
+		t.Fatalf("Failed to combine organizations: %v", err)
+	}
+
+	if expected := newMultiOrganizationConfig(); !reflect.DeepEqual(cfg, expected) {
+		t.Fatalf("Expected %+v, but got %+v.", expected, cfg)
+	}
+
+	// entries that differ between organizations are kept apart,
+	// identical ones are combined
+	configs["org-a"].Teams[1].Role = quay.CreatorTeamRole
+	configs["org-c"].Teams = append(configs["org-c"].Teams, TeamConfig{Name: "developers", Role: quay.MemberTeamRole})
+
+	// organizations that are not given keep their entries
+	delete(configs, "org-b")
+
+	if err := cfg.CombineOrganizations(configs); err != nil {
+		t.Fatalf("Failed to combine organizations: %v", err)
+	}
+
+	expected := []TeamConfig{
+		{Name: "owners", Role: quay.AdminTeamRole},
+		{Name: "developers", Role: quay.CreatorTeamRole, Organizations: []string{"org-a"}},
+		{Name: "developers", Role: quay.MemberTeamRole, Organizations: []string{"org-b", "org-c"}},
+	}
+
+	if !reflect.DeepEqual(cfg.Teams, expected) {
+		t.Errorf("Expected teams %+v, but got %+v.", expected, cfg.Teams)
+	}
+
+	if len(cfg.Repositories) != 1 || !reflect.DeepEqual(cfg.Repositories[0].Organizations, []string{"org-b"}) {
+		t.Errorf("Expected the repository of org-b to be kept, but got %+v.", cfg.Repositories)
+	}
+}
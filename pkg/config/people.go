@@ -0,0 +1,99 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+)
+
+// IsPersonReference returns true if the member or user name refers to
+// a person by their email address instead of a Quay username.
+func IsPersonReference(name string) bool {
+	return strings.Contains(name, "@") && !IsGroupReference(name)
+}
+
+// Person returns the person with the given email address, or nil.
+func (c *Config) Person(email string) *PersonConfig {
+	for i, person := range c.People {
+		if strings.EqualFold(person.Email, email) {
+			return &c.People[i]
+		}
+	}
+
+	return nil
+}
+
+// PersonByUsername returns the person with the given Quay username,
+// or nil.
+func (c *Config) PersonByUsername(username string) *PersonConfig {
+	for i, person := range c.People {
+		if person.Username == username {
+			return &c.People[i]
+		}
+	}
+
+	return nil
+}
+
+// ResolvePerson returns the Quay username of the person with the given
+// email address. All other names are returned unchanged.
+func (c *Config) ResolvePerson(name string) (string, error) {
+	if !IsPersonReference(name) {
+		return name, nil
+	}
+
+	person := c.Person(name)
+	if person == nil {
+		return "", fmt.Errorf("person %q does not exist", name)
+	}
+
+	return person.Username, nil
+}
+
+// validatePeople checks the people directory. If a client is given,
+// the Quay account of every person must exist.
+func (c *Config) validatePeople(ctx context.Context, client quay.API, existingUsers map[string]struct{}) error {
+	emailSources := map[string]string{}
+	usernames := map[string]string{}
+
+	for _, person := range c.People {
+		key := strings.ToLower(person.Email)
+
+		if first, exists := emailSources[key]; exists {
+			return duplicateError("person", person.Email, person.source, first)
+		}
+
+		if !emailRegexp.MatchString(person.Email) {
+			return sourceErrorf(person.source, "email %q of person is invalid, must be %v", person.Email, emailRegexp)
+		}
+
+		if person.Username == "" {
+			return sourceErrorf(person.source, "person %q has no Quay username", person.Email)
+		}
+
+		if quay.IsRobotUsername(person.Username) {
+			return sourceErrorf(person.source, "Quay username %q of person %q must not be a robot", person.Username, person.Email)
+		}
+
+		if !userRegexp.MatchString(person.Username) {
+			return sourceErrorf(person.source, "Quay username %q of person %q is invalid, must be %v", person.Username, person.Email, userRegexp)
+		}
+
+		if other, exists := usernames[person.Username]; exists {
+			return sourceErrorf(person.source, "person %q has the same Quay username %q as %q", person.Email, person.Username, other)
+		}
+
+		if client != nil {
+			if err := validateUsername(ctx, client, person.Username, existingUsers); err != nil {
+				return sourceErrorf(person.source, "Quay account %q of person %q is invalid: %v", person.Username, person.Email, err)
+			}
+		}
+
+		emailSources[key] = person.source
+		usernames[person.Username] = person.Email
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+)
+
+func TestValidateRejectsPersonAssignedTwice(t *testing.T) {
+	testcases := []struct {
+		name  string
+		users map[string]quay.RepositoryRole
+		valid bool
+	}{
+		{
+			name: "username and email",
+			users: map[string]quay.RepositoryRole{
+				"alice":             quay.ReadRepositoryRole,
+				"alice@example.com": quay.AdminRepositoryRole,
+			},
+		},
+		{
+			name: "email in different case",
+			users: map[string]quay.RepositoryRole{
+				"alice@example.com": quay.ReadRepositoryRole,
+				"Alice@example.com": quay.ReadRepositoryRole,
+			},
+		},
+		{
+			name: "different people",
+			users: map[string]quay.RepositoryRole{
+				"alice@example.com": quay.ReadRepositoryRole,
+				"bob":               quay.WriteRepositoryRole,
+			},
+			valid: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &Config{
+				Organization: "exampleorg",
+				People: []PersonConfig{
+					{Email: "alice@example.com", Username: "alice"},
+				},
+				Repositories: []RepositoryConfig{
+					{Name: "app", Visibility: quay.Private, Users: tc.users},
+				},
+			}
+
+			err := cfg.Validate(context.Background(), nil)
+			if tc.valid {
+				if err != nil {
+					t.Fatalf("Expected configuration to be valid, but got: %v", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), "duplicate user") {
+				t.Fatalf("Expected a duplicate user error, but got: %v", err)
+			}
+		})
+	}
+}
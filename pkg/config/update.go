@@ -0,0 +1,245 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultIndent is the indentation used by yaml.v3 by default.
+const defaultIndent = 4
+
+// UpdateFile writes the configuration into an existing file, keeping
+// comments, the order of keys and list items, and the formatting of
+// unchanged values intact. If the file does not exist yet, it is
+// created like SaveToFile does. The file is only written if the
+// configuration actually changed; the return value reports whether
+// this was the case. Entries from included files are written back
+// into them. If filename is a directory, UpdateDirectory is used
+// instead.
+func UpdateFile(config *Config, filename string) (bool, error) {
+	if info, err := os.Stat(filename); err == nil && info.IsDir() {
+		return UpdateDirectory(config, filename)
+	}
+
+	config, includesChanged, err := updateIncludes(config)
+	if err != nil {
+		return false, err
+	}
+
+	changed, err := updateFile(config, filename)
+
+	return changed || includesChanged, err
+}
+
+// updateFile updates a file containing a single YAML document that
+// represents value, see UpdateFile.
+func updateFile[T any](value *T, filename string) (bool, error) {
+	existing, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return true, saveFile(value, filename)
+		}
+
+		return false, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(existing, &doc); err != nil {
+		return false, err
+	}
+
+	// an empty file
+	if doc.Kind == 0 || len(doc.Content) == 0 {
+		return true, saveFile(value, filename)
+	}
+
+	// compare the parsed values, so that a file that only
+	// differs in formatting is left alone
+	current := new(T)
+	if err := doc.Decode(current); err != nil {
+		return false, err
+	}
+
+	changed, err := differs(current, value)
+	if err != nil || !changed {
+		return false, err
+	}
+
+	var updated yaml.Node
+	if err := updated.Encode(value); err != nil {
+		return false, err
+	}
+
+	doc.Content[0] = mergeNodes(doc.Content[0], &updated)
+
+	var buf bytes.Buffer
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(detectIndent(existing))
+
+	if err := encoder.Encode(&doc); err != nil {
+		return false, err
+	}
+
+	if err := encoder.Close(); err != nil {
+		return false, err
+	}
+
+	return true, os.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+func differs(a, b interface{}) (bool, error) {
+	aYAML, err := yaml.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+
+	bYAML, err := yaml.Marshal(b)
+	if err != nil {
+		return false, err
+	}
+
+	return !bytes.Equal(aYAML, bYAML), nil
+}
+
+// detectIndent returns the smallest indentation of any line in the
+// file, so that rewritten files keep their style.
+func detectIndent(content []byte) int {
+	indent := 0
+
+	for _, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// list items are often not indented at all
+		if n := len(line) - len(trimmed); n > 0 && (indent == 0 || n < indent) {
+			indent = n
+		}
+	}
+
+	if indent < 2 {
+		return defaultIndent
+	}
+
+	return indent
+}
+
+// mergeNodes returns updated, but reuses the nodes of existing wherever
+// possible, so that comments and formatting are kept.
+func mergeNodes(existing *yaml.Node, updated *yaml.Node) *yaml.Node {
+	if existing == nil {
+		return updated
+	}
+
+	if existing.Kind != updated.Kind {
+		keepComments(updated, existing)
+		return updated
+	}
+
+	switch updated.Kind {
+	case yaml.ScalarNode:
+		if existing.Value == updated.Value && existing.ShortTag() == updated.ShortTag() {
+			return existing
+		}
+
+		keepComments(updated, existing)
+		return updated
+
+	case yaml.MappingNode:
+		updatedValues := map[string]*yaml.Node{}
+		for i := 0; i+1 < len(updated.Content); i += 2 {
+			updatedValues[updated.Content[i].Value] = updated.Content[i+1]
+		}
+
+		content := []*yaml.Node{}
+		seen := map[string]bool{}
+
+		// keep the existing order of keys
+		for i := 0; i+1 < len(existing.Content); i += 2 {
+			key := existing.Content[i]
+
+			value, exists := updatedValues[key.Value]
+			if !exists {
+				continue
+			}
+
+			content = append(content, key, mergeNodes(existing.Content[i+1], value))
+			seen[key.Value] = true
+		}
+
+		for i := 0; i+1 < len(updated.Content); i += 2 {
+			if !seen[updated.Content[i].Value] {
+				content = append(content, updated.Content[i], updated.Content[i+1])
+			}
+		}
+
+		existing.Content = content
+		return existing
+
+	case yaml.SequenceNode:
+		used := make([]bool, len(existing.Content))
+		content := []*yaml.Node{}
+
+		for _, item := range updated.Content {
+			id := nodeIdentity(item)
+
+			var match *yaml.Node
+			for i, candidate := range existing.Content {
+				if !used[i] && id != "" && nodeIdentity(candidate) == id {
+					used[i] = true
+					match = candidate
+					break
+				}
+			}
+
+			content = append(content, mergeNodes(match, item))
+		}
+
+		existing.Content = content
+		return existing
+
+	default:
+		return updated
+	}
+}
+
+// identityKeys are the fields that identify list items in the
+// configuration, e.g. a team's name.
+var identityKeys = []string{"name", "namespace", "team", "user", "activatingUser"}
+
+// nodeIdentity returns a string that identifies a list item, so that
+// it can be found again after the list was changed.
+func nodeIdentity(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Value
+
+	case yaml.MappingNode:
+		parts := []string{}
+
+		for _, key := range identityKeys {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key && node.Content[i+1].Kind == yaml.ScalarNode {
+					parts = append(parts, key+"="+node.Content[i+1].Value)
+				}
+			}
+		}
+
+		return strings.Join(parts, ",")
+
+	default:
+		return ""
+	}
+}
+
+func keepComments(dst *yaml.Node, src *yaml.Node) {
+	dst.HeadComment = src.HeadComment
+	dst.LineComment = src.LineComment
+	dst.FootComment = src.FootComment
+}
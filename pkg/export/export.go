@@ -2,15 +2,37 @@ package export
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sort"
 
 	"github.com/kubermatic-labs/aquayman/pkg/config"
 	"github.com/kubermatic-labs/aquayman/pkg/quay"
+	"github.com/kubermatic-labs/aquayman/pkg/util"
 )
 
-func ExportConfiguration(ctx context.Context, organization string, client *quay.Client) (*config.Config, error) {
+type Options struct {
+	// Parallelism is the number of repositories that are
+	// exported concurrently.
+	Parallelism int
+
+	// InferWildcards groups repositories with identical settings
+	// into wildcard rules, instead of listing every repository.
+	InferWildcards bool
+
+	// InferGroups combines users that are used together in multiple
+	// teams or repositories into groups.
+	InferGroups bool
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Parallelism: 1,
+	}
+}
+
+func ExportConfiguration(ctx context.Context, organization string, client quay.API, options Options) (*config.Config, error) {
 	cfg := &config.Config{
 		Organization: organization,
 	}
@@ -19,7 +41,7 @@ func ExportConfiguration(ctx context.Context, organization string, client *quay.
 		return cfg, fmt.Errorf("failed to export robots: %v", err)
 	}
 
-	if err := exportRepositories(ctx, client, cfg); err != nil {
+	if err := exportRepositories(ctx, client, cfg, options); err != nil {
 		return cfg, fmt.Errorf("failed to export repositories: %v", err)
 	}
 
@@ -27,10 +49,20 @@ func ExportConfiguration(ctx context.Context, organization string, client *quay.
 		return cfg, fmt.Errorf("failed to export teams: %v", err)
 	}
 
+	if err := exportDefaultPermissions(ctx, client, cfg); err != nil {
+		return cfg, fmt.Errorf("failed to export default permissions: %v", err)
+	}
+
+	if options.InferGroups {
+		if err := inferGroups(cfg); err != nil {
+			return cfg, fmt.Errorf("failed to infer groups: %v", err)
+		}
+	}
+
 	return cfg, nil
 }
 
-func exportRobots(ctx context.Context, client *quay.Client, cfg *config.Config) error {
+func exportRobots(ctx context.Context, client quay.API, cfg *config.Config) error {
 	log.Println("⇄ Exporting robots…")
 
 	robots, err := client.GetOrganizationRobots(ctx, cfg.Organization, quay.GetOrganizationRobotsOptions{})
@@ -50,7 +82,7 @@ func exportRobots(ctx context.Context, client *quay.Client, cfg *config.Config)
 	return nil
 }
 
-func exportRepositories(ctx context.Context, client *quay.Client, cfg *config.Config) error {
+func exportRepositories(ctx context.Context, client quay.API, cfg *config.Config, options Options) error {
 	log.Println("⇄ Exporting repositories…")
 
 	repos, err := client.GetRepositories(ctx, quay.GetRepositoriesOptions{Namespace: cfg.Organization})
@@ -58,17 +90,15 @@ func exportRepositories(ctx context.Context, client *quay.Client, cfg *config.Co
 		return err
 	}
 
-	for _, repo := range repos {
-		visibilitySuffix := ""
-		if !repo.IsPublic {
-			visibilitySuffix = " (private)"
-		}
+	repoConfigs := make([]config.RepositoryConfig, len(repos))
+	errs := []error{}
 
-		log.Printf("  ⚒ %s%s", repo.Name, visibilitySuffix)
+	export := func(i int) error {
+		repo := repos[i]
 
 		teamPermissions, err := client.GetRepositoryTeamPermissions(ctx, repo.FullName())
 		if err != nil {
-			return fmt.Errorf("failed to fetch team permissions: %v", err)
+			return fmt.Errorf("failed to fetch team permissions for %s: %v", repo.Name, err)
 		}
 
 		teams := map[string]quay.RepositoryRole{}
@@ -79,7 +109,7 @@ func exportRepositories(ctx context.Context, client *quay.Client, cfg *config.Co
 
 		userPermissions, err := client.GetRepositoryUserPermissions(ctx, repo.FullName())
 		if err != nil {
-			return fmt.Errorf("failed to fetch user permissions: %v", err)
+			return fmt.Errorf("failed to fetch user permissions for %s: %v", repo.Name, err)
 		}
 
 		users := map[string]quay.RepositoryRole{}
@@ -88,24 +118,44 @@ func exportRepositories(ctx context.Context, client *quay.Client, cfg *config.Co
 			users[user.Name] = user.Role
 		}
 
-		visibility := quay.Private
-		if repo.IsPublic {
-			visibility = quay.Public
-		}
-
-		cfg.Repositories = append(cfg.Repositories, config.RepositoryConfig{
+		repoConfigs[i] = config.RepositoryConfig{
 			Name:        repo.Name,
 			Description: repo.Description,
-			Visibility:  visibility,
+			Visibility:  repo.Visibility(),
 			Teams:       teams,
 			Users:       users,
-		})
+		}
+
+		return nil
+	}
+
+	util.Parallel(options.Parallelism, len(repos), export, func(i int, err error) {
+		visibilitySuffix := ""
+		if !repos[i].IsPublic {
+			visibilitySuffix = " (private)"
+		}
+
+		log.Printf("  ⚒ %s%s", repos[i].Name, visibilitySuffix)
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	if options.InferWildcards {
+		repoConfigs = inferWildcardRules(repoConfigs)
 	}
 
+	cfg.Repositories = append(cfg.Repositories, repoConfigs...)
+
 	return nil
 }
 
-func exportTeams(ctx context.Context, client *quay.Client, cfg *config.Config) error {
+func exportTeams(ctx context.Context, client quay.API, cfg *config.Config) error {
 	log.Println("⇄ Exporting teams…")
 
 	org, err := client.GetOrganization(ctx, cfg.Organization)
@@ -136,11 +186,47 @@ func exportTeams(ctx context.Context, client *quay.Client, cfg *config.Config) e
 		sort.Strings(memberNames)
 
 		cfg.Teams = append(cfg.Teams, config.TeamConfig{
-			Name:    team.Name,
-			Role:    team.Role,
-			Members: memberNames,
+			Name:        team.Name,
+			Role:        team.Role,
+			Description: team.Description,
+			Members:     memberNames,
 		})
 	}
 
 	return nil
 }
+
+func exportDefaultPermissions(ctx context.Context, client quay.API, cfg *config.Config) error {
+	log.Println("⇄ Exporting default permissions…")
+
+	prototypes, err := client.GetOrganizationPrototypes(ctx, cfg.Organization)
+	if err != nil {
+		return err
+	}
+
+	for _, prototype := range prototypes {
+		perm := config.DefaultPermissionConfig{
+			Role: prototype.Role,
+		}
+
+		if prototype.Delegate.Kind == quay.PrototypeDelegateTeam {
+			perm.Team = prototype.Delegate.Name
+		} else {
+			perm.User = prototype.Delegate.Name
+		}
+
+		if prototype.ActivatingUser != nil {
+			perm.ActivatingUser = prototype.ActivatingUser.Name
+		}
+
+		log.Printf("  ⚙ %s", perm.Key())
+
+		cfg.DefaultPermissions = append(cfg.DefaultPermissions, perm)
+	}
+
+	sort.Slice(cfg.DefaultPermissions, func(i, j int) bool {
+		return cfg.DefaultPermissions[i].Key() < cfg.DefaultPermissions[j].Key()
+	})
+
+	return nil
+}
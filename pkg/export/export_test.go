@@ -0,0 +1,189 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+	"github.com/kubermatic-labs/aquayman/pkg/quay/quaytest"
+	"github.com/kubermatic-labs/aquayman/pkg/sync"
+)
+
+const testOrganization = "testorg"
+
+func newTestServer(t *testing.T) (*quaytest.Server, *quay.Client) {
+	t.Helper()
+
+	server, client := quaytest.NewClient(t)
+	server.AddUser("alice", "bob", "carol")
+
+	org := server.AddOrganization(testOrganization)
+	org.Robots["ci"] = &quaytest.Robot{Description: "CI robot", Token: "ci-token"}
+	org.Teams["developers"] = &quaytest.Team{
+		Role:        quay.MemberTeamRole,
+		Description: "all developers",
+		Members:     []string{"alice", "bob", testOrganization + "+ci"},
+	}
+	org.Teams["admins"] = &quaytest.Team{
+		Role:    quay.AdminTeamRole,
+		Members: []string{"carol"},
+	}
+	org.Prototypes["proto-1"] = &quay.Prototype{
+		ID:       "proto-1",
+		Role:     quay.ReadRepositoryRole,
+		Delegate: quay.PrototypeIdentity{Name: "developers", Kind: quay.PrototypeDelegateTeam},
+	}
+
+	for i := 0; i < 5; i++ {
+		org.Repositories[fmt.Sprintf("repo-%d", i)] = &quaytest.Repository{
+			Visibility:  quay.Public,
+			Description: fmt.Sprintf("repository %d", i),
+			Teams:       map[string]quay.RepositoryRole{"developers": quay.WriteRepositoryRole},
+			Users:       map[string]quay.RepositoryRole{"carol": quay.AdminRepositoryRole},
+		}
+	}
+
+	org.Repositories["secret"] = &quaytest.Repository{
+		Visibility: quay.Private,
+		Teams:      map[string]quay.RepositoryRole{"admins": quay.AdminRepositoryRole},
+		Users:      map[string]quay.RepositoryRole{testOrganization + "+ci": quay.ReadRepositoryRole},
+	}
+
+	return server, client
+}
+
+// assertRoundTrip syncs the exported configuration back into the
+// organization, which must not result in any changes.
+func assertRoundTrip(t *testing.T, cfg *config.Config, client quay.API) {
+	t.Helper()
+
+	options := sync.DefaultOptions()
+	options.CreateMissingRepositories = true
+	options.DeleteDanglingRepositories = true
+
+	plan, err := sync.BuildPlan(context.Background(), cfg, client, options)
+	if err != nil {
+		t.Fatalf("Failed to build plan: %v", err)
+	}
+
+	if !plan.Empty() {
+		t.Fatalf("Expected exported configuration to match the organization, but got %d changes: %+v", len(plan.Actions), plan.Actions)
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	testcases := []struct {
+		name    string
+		options Options
+	}{
+		{
+			name:    "plain",
+			options: DefaultOptions(),
+		},
+		{
+			name:    "wildcards",
+			options: Options{Parallelism: 3, InferWildcards: true},
+		},
+		{
+			name:    "groups",
+			options: Options{Parallelism: 3, InferGroups: true},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := newTestServer(t)
+			server.PageSize = 2
+
+			cfg, err := ExportConfiguration(context.Background(), testOrganization, client, tc.options)
+			if err != nil {
+				t.Fatalf("Export failed: %v", err)
+			}
+
+			if err := cfg.Validate(context.Background(), client); err != nil {
+				t.Fatalf("Exported configuration is invalid: %v", err)
+			}
+
+			assertRoundTrip(t, cfg, client)
+		})
+	}
+}
+
+func TestExportInferredWildcards(t *testing.T) {
+	server, client := newTestServer(t)
+
+	// all but one of the repo-* repositories share their settings
+	org := server.Organizations[testOrganization]
+	for i := 0; i < 4; i++ {
+		org.Repositories[fmt.Sprintf("repo-%d", i)].Description = "shared"
+	}
+
+	cfg, err := ExportConfiguration(context.Background(), testOrganization, client, Options{Parallelism: 3, InferWildcards: true})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	names := []string{}
+	for _, repo := range cfg.Repositories {
+		names = append(names, repo.Name)
+	}
+
+	// repositories that differ from their rule keep explicit entries
+	expected := []string{"*", "repo-4", "secret"}
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Fatalf("Expected repositories %v, but got %v.", expected, names)
+	}
+
+	rule := cfg.Repositories[0]
+	if rule.Visibility != quay.Public || rule.Description != "shared" || rule.Teams["developers"] != quay.WriteRepositoryRole || rule.Users["carol"] != quay.AdminRepositoryRole {
+		t.Errorf("Expected the rule to have the shared settings, but got %+v.", rule)
+	}
+
+	if repo := cfg.GetRepositoryConfig("repo-4"); repo.Description != "repository 4" {
+		t.Errorf("Expected repo-4 to keep its own settings, but got %+v.", repo)
+	}
+
+	assertRoundTrip(t, cfg, client)
+}
+
+func TestExportPagination(t *testing.T) {
+	server, client := newTestServer(t)
+	server.PageSize = 1
+
+	cfg, err := ExportConfiguration(context.Background(), testOrganization, client, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	if len(cfg.Repositories) != 6 {
+		t.Fatalf("Expected 6 repositories across all pages, but got %d.", len(cfg.Repositories))
+	}
+}
+
+func TestExportRetriesFailedRequests(t *testing.T) {
+	server, client := newTestServer(t)
+	server.FailRequests(2, http.StatusGatewayTimeout, 0)
+
+	cfg, err := ExportConfiguration(context.Background(), testOrganization, client, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	if stats := client.Stats(); stats.Retries != 2 {
+		t.Errorf("Expected 2 retries, but got %d.", stats.Retries)
+	}
+
+	assertRoundTrip(t, cfg, client)
+}
+
+func TestExportUnknownOrganization(t *testing.T) {
+	_, client := newTestServer(t)
+
+	_, err := ExportConfiguration(context.Background(), "nope", client, DefaultOptions())
+	if err == nil {
+		t.Fatal("Expected export of an unknown organization to fail.")
+	}
+}
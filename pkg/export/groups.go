@@ -0,0 +1,290 @@
+package export
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+	"github.com/kubermatic-labs/aquayman/pkg/util"
+)
+
+// minGroupSize is the minimum number of users of an inferred group.
+const minGroupSize = 2
+
+// inferGroups adds a group for every set of users that is used as a
+// whole in multiple places, i.e. as the members of a team or as the
+// users that have the same role in a repository, and replaces these
+// sets with references to the new groups. Robots are never part of
+// inferred groups, as they belong to a single organization.
+func inferGroups(cfg *config.Config) error {
+	sets := [][]string{}
+	counts := map[string]int{}
+
+	add := func(users []string) {
+		users = humanUsers(users)
+		if len(users) < minGroupSize {
+			return
+		}
+
+		key := memberSetKey(users)
+		if counts[key] == 0 {
+			sets = append(sets, users)
+		}
+
+		counts[key]++
+	}
+
+	for _, team := range cfg.Teams {
+		add(team.Members)
+	}
+
+	for _, repo := range cfg.Repositories {
+		for _, users := range usersByRole(repo.Users) {
+			add(users)
+		}
+	}
+
+	for _, users := range sets {
+		if counts[memberSetKey(users)] < 2 {
+			continue
+		}
+
+		cfg.Groups = append(cfg.Groups, config.GroupConfig{
+			Name:    groupName(cfg, users),
+			Members: users,
+		})
+	}
+
+	return foldGroups(cfg)
+}
+
+// groupName names a group after the team that has exactly its members,
+// or numbers it otherwise.
+func groupName(cfg *config.Config, users []string) string {
+	key := memberSetKey(users)
+
+	for _, team := range cfg.Teams {
+		if memberSetKey(team.Members) == key && !groupExists(cfg.Groups, team.Name) {
+			return team.Name
+		}
+	}
+
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("group-%d", i)
+		if !groupExists(cfg.Groups, name) {
+			return name
+		}
+	}
+}
+
+func groupExists(groups []config.GroupConfig, name string) bool {
+	for _, group := range groups {
+		if group.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mergeGroups returns the existing groups plus all groups of the export
+// (see Options.InferGroups) whose members are not already a group. Both
+// configurations must have valid groups. Existing groups are kept as
+// they are, even if they are unused, as Quay does not know about them.
+func mergeGroups(cfg *config.Config, exported *config.Config) ([]config.GroupConfig, error) {
+	merged := append([]config.GroupConfig{}, cfg.Groups...)
+	known := map[string]bool{}
+
+	for _, group := range cfg.Groups {
+		members, err := cfg.GroupMembers(group.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		known[memberSetKey(members)] = true
+	}
+
+	for _, group := range exported.Groups {
+		members, err := exported.GroupMembers(group.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		key := memberSetKey(members)
+		if known[key] {
+			continue
+		}
+
+		name := group.Name
+		for i := 2; groupExists(merged, name); i++ {
+			name = fmt.Sprintf("%s-%d", group.Name, i)
+		}
+
+		merged = append(merged, config.GroupConfig{
+			Name:    name,
+			Members: peopleEmails(cfg, members),
+		})
+		known[key] = true
+	}
+
+	return merged, nil
+}
+
+type foldableGroup struct {
+	name    string
+	members []string
+}
+
+// foldGroups replaces users in team members and repository users with
+// references to the configured groups, wherever all members of a group
+// are present (with the same role). The configuration must not contain
+// group references yet. Larger groups are preferred, so that nested
+// groups are used where possible. Expanding the result again yields
+// exactly the same users and roles.
+func foldGroups(cfg *config.Config) error {
+	groups := []foldableGroup{}
+	for _, group := range cfg.Groups {
+		members, err := cfg.GroupMembers(group.Name)
+		if err != nil {
+			return err
+		}
+
+		groups = append(groups, foldableGroup{name: group.Name, members: members})
+	}
+
+	if len(groups) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if len(groups[i].members) != len(groups[j].members) {
+			return len(groups[i].members) > len(groups[j].members)
+		}
+
+		return groups[i].name < groups[j].name
+	})
+
+	for i, team := range cfg.Teams {
+		cfg.Teams[i].Members = foldMembers(team.Members, groups)
+	}
+
+	for i, repo := range cfg.Repositories {
+		cfg.Repositories[i].Users = foldUsers(repo.Users, groups)
+	}
+
+	return nil
+}
+
+// foldMembers returns the group references, sorted by name, followed
+// by all members that are not part of any of these groups.
+func foldMembers(members []string, groups []foldableGroup) []string {
+	refs := []string{}
+	covered := map[string]bool{}
+
+	for _, group := range groups {
+		if !containsAll(members, group.members) || containsAllKeys(covered, group.members) {
+			continue
+		}
+
+		refs = append(refs, config.GroupReference(group.name))
+		for _, member := range group.members {
+			covered[member] = true
+		}
+	}
+
+	if len(refs) == 0 {
+		return members
+	}
+
+	sort.Strings(refs)
+
+	for _, member := range members {
+		if !covered[member] {
+			refs = append(refs, member)
+		}
+	}
+
+	return refs
+}
+
+func foldUsers(users map[string]quay.RepositoryRole, groups []foldableGroup) map[string]quay.RepositoryRole {
+	if len(users) == 0 {
+		return users
+	}
+
+	result := map[string]quay.RepositoryRole{}
+	covered := map[string]bool{}
+	roleUsers := usersByRole(users)
+
+	for _, role := range quay.AllRepositoryRoles {
+		for _, group := range groups {
+			if !containsAll(roleUsers[role], group.members) || containsAllKeys(covered, group.members) {
+				continue
+			}
+
+			result[config.GroupReference(group.name)] = role
+			for _, member := range group.members {
+				covered[member] = true
+			}
+		}
+	}
+
+	for user, role := range users {
+		if !covered[user] {
+			result[user] = role
+		}
+	}
+
+	return result
+}
+
+func usersByRole(users map[string]quay.RepositoryRole) map[quay.RepositoryRole][]string {
+	result := map[quay.RepositoryRole][]string{}
+	for _, user := range sortedKeys(users) {
+		role := users[user]
+		result[role] = append(result[role], user)
+	}
+
+	return result
+}
+
+func humanUsers(users []string) []string {
+	result := []string{}
+	for _, user := range users {
+		if !quay.IsRobotUsername(user) {
+			result = append(result, user)
+		}
+	}
+
+	return result
+}
+
+func containsAll(list []string, values []string) bool {
+	for _, value := range values {
+		if !util.StringSliceContains(list, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsAllKeys(set map[string]bool, values []string) bool {
+	for _, value := range values {
+		if !set[value] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// memberSetKey identifies a set of users, regardless of their order.
+func memberSetKey(users []string) string {
+	sorted := append([]string{}, users...)
+	sort.Strings(sorted)
+
+	return strings.Join(sorted, "\n")
+}
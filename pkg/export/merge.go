@@ -0,0 +1,266 @@
+package export
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+)
+
+// MergeConfiguration updates an existing configuration in place, so
+// that it matches the exported organization state. Unlike replacing
+// the configuration with the export, this keeps everything that Quay
+// does not know about: settings like the registry or Vault, groups,
+// robot fields like vaultSecret, and wildcard repository rules.
+// Repositories that are already fully described by a wildcard rule are
+// not listed individually. If the export contains inferred wildcard
+// rules itself (see Options.InferWildcards), it replaces the existing
+// repositories. Users are folded back into the configured groups and
+// known people are listed by their email address.
+func MergeConfiguration(cfg *config.Config, exported *config.Config) error {
+	// merge the actual users, group references are restored afterwards
+	exported, err := exported.ExpandGroups()
+	if err != nil {
+		return err
+	}
+
+	cfg.Groups, err = mergeGroups(cfg, exported)
+	if err != nil {
+		return err
+	}
+
+	expanded, err := cfg.ExpandGroups()
+	if err != nil {
+		return err
+	}
+
+	cfg.Robots = mergeRobots(cfg.Robots, exported.Robots)
+	cfg.Teams = mergeTeams(expanded.Teams, exported.Teams)
+	cfg.Repositories = mergeRepositories(expanded.Repositories, exported.Repositories)
+	cfg.DefaultPermissions = mergeDefaultPermissions(cfg.DefaultPermissions, exported.DefaultPermissions)
+
+	if err := foldGroups(cfg); err != nil {
+		return err
+	}
+
+	foldPeople(cfg)
+
+	return nil
+}
+
+// MergeOrganizations merges the exports of one or more organizations
+// into the configuration. For a multi-organization configuration, each
+// organization is merged on its own like MergeConfiguration does, and
+// entries that are identical in multiple organizations are combined
+// again afterwards. Organizations that were not exported are unchanged.
+func MergeOrganizations(cfg *config.Config, exported map[string]*config.Config) error {
+	if !cfg.IsMultiOrganization() {
+		if newConfig, exists := exported[cfg.Organization]; exists {
+			return MergeConfiguration(cfg, newConfig)
+		}
+
+		return nil
+	}
+
+	// groups are shared by all organizations, so they are merged
+	// before any organization uses them
+	for _, org := range sortedKeys(exported) {
+		groups, err := mergeGroups(cfg, exported[org])
+		if err != nil {
+			return fmt.Errorf("organization %s: %w", org, err)
+		}
+
+		cfg.Groups = groups
+	}
+
+	merged := map[string]*config.Config{}
+	for org, newConfig := range exported {
+		orgConfig := cfg.ForOrganization(org)
+		if err := MergeConfiguration(orgConfig, newConfig); err != nil {
+			return fmt.Errorf("organization %s: %w", org, err)
+		}
+
+		merged[org] = orgConfig
+	}
+
+	return cfg.CombineOrganizations(merged)
+}
+
+func mergeRobots(existing []config.RobotConfig, exported []config.RobotConfig) []config.RobotConfig {
+	exportedRobots := map[string]config.RobotConfig{}
+	for _, robot := range exported {
+		exportedRobots[robot.Name] = robot
+	}
+
+	merged := []config.RobotConfig{}
+	seen := map[string]bool{}
+
+	for _, robot := range existing {
+		exportedRobot, exists := exportedRobots[robot.Name]
+		if !exists {
+			// robots that are meant to be deleted are kept, so that
+			// their credentials can still be cleaned up
+			if robot.Deleted {
+				merged = append(merged, robot)
+			}
+
+			continue
+		}
+
+		robot.Description = exportedRobot.Description
+		merged = append(merged, robot)
+		seen[robot.Name] = true
+	}
+
+	for _, robot := range exported {
+		if !seen[robot.Name] {
+			merged = append(merged, robot)
+		}
+	}
+
+	return merged
+}
+
+func mergeTeams(existing []config.TeamConfig, exported []config.TeamConfig) []config.TeamConfig {
+	exportedTeams := map[string]config.TeamConfig{}
+	for _, team := range exported {
+		exportedTeams[team.Name] = team
+	}
+
+	merged := []config.TeamConfig{}
+	seen := map[string]bool{}
+
+	for _, team := range existing {
+		exportedTeam, exists := exportedTeams[team.Name]
+		if !exists {
+			continue
+		}
+
+		team.Role = exportedTeam.Role
+		team.Members = exportedTeam.Members
+		team.Description = exportedTeam.Description
+
+		merged = append(merged, team)
+		seen[team.Name] = true
+	}
+
+	for _, team := range exported {
+		if !seen[team.Name] {
+			merged = append(merged, team)
+		}
+	}
+
+	return merged
+}
+
+func mergeRepositories(existing []config.RepositoryConfig, exported []config.RepositoryConfig) []config.RepositoryConfig {
+	// mixing existing rules with inferred ones would change how
+	// repositories are resolved
+	for _, repo := range exported {
+		if repo.IsWildcard() {
+			return exported
+		}
+	}
+
+	exportedRepos := map[string]config.RepositoryConfig{}
+	for _, repo := range exported {
+		exportedRepos[repo.Name] = repo
+	}
+
+	merged := &config.Config{}
+	seen := map[string]bool{}
+
+	for _, repo := range existing {
+		if repo.IsWildcard() {
+			merged.Repositories = append(merged.Repositories, repo)
+			continue
+		}
+
+		exportedRepo, exists := exportedRepos[repo.Name]
+		if !exists {
+			continue
+		}
+
+		// update the existing entry instead of replacing it, so
+		// that it stays in the file it was loaded from
+		repo.Visibility = exportedRepo.Visibility
+		repo.Description = exportedRepo.Description
+		repo.Teams = exportedRepo.Teams
+		repo.Users = exportedRepo.Users
+
+		merged.Repositories = append(merged.Repositories, repo)
+		seen[repo.Name] = true
+	}
+
+	for _, repo := range exported {
+		if seen[repo.Name] {
+			continue
+		}
+
+		// no need to list repositories that are already covered
+		// by a wildcard rule
+		if rule := merged.GetRepositoryConfig(repo.Name); rule != nil && sameRepositorySettings(*rule, repo) {
+			continue
+		}
+
+		merged.Repositories = append(merged.Repositories, repo)
+	}
+
+	return merged.Repositories
+}
+
+// sameRepositorySettings compares everything but the name.
+func sameRepositorySettings(a config.RepositoryConfig, b config.RepositoryConfig) bool {
+	return a.Visibility == b.Visibility &&
+		a.Description == b.Description &&
+		sameRoles(a.Teams, b.Teams) &&
+		sameRoles(a.Users, b.Users)
+}
+
+func sameRoles[T comparable](a map[string]T, b map[string]T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, value := range a {
+		if other, exists := b[key]; !exists || other != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func mergeDefaultPermissions(existing []config.DefaultPermissionConfig, exported []config.DefaultPermissionConfig) []config.DefaultPermissionConfig {
+	exportedPerms := map[string]config.DefaultPermissionConfig{}
+	for _, perm := range exported {
+		exportedPerms[perm.Key()] = perm
+	}
+
+	merged := []config.DefaultPermissionConfig{}
+	seen := map[string]bool{}
+
+	for _, perm := range existing {
+		exportedPerm, exists := exportedPerms[perm.Key()]
+		if !exists {
+			continue
+		}
+
+		perm.Role = exportedPerm.Role
+		merged = append(merged, perm)
+		seen[perm.Key()] = true
+	}
+
+	added := []config.DefaultPermissionConfig{}
+	for _, perm := range exported {
+		if !seen[perm.Key()] {
+			added = append(added, perm)
+		}
+	}
+
+	sort.Slice(added, func(i, j int) bool {
+		return added[i].Key() < added[j].Key()
+	})
+
+	return append(merged, added...)
+}
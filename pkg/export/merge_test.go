@@ -0,0 +1,166 @@
+package export
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+)
+
+const testConfig = `# managed by Aquayman
+organization: testorg
+
+robots:
+  # the robot used by CI jobs
+  - name: ci
+    description: outdated
+    vaultSecret: secret/ci
+  - name: retired
+    deleted: true
+
+teams:
+  - name: admins
+    role: admin
+    description: stale description
+    members:
+      - carol
+  - name: developers
+    role: member
+    description: all developers
+    members:
+      - alice
+      - bob
+      - testorg+ci
+
+repositories:
+  # matches the secret repository exactly
+  - name: secr*
+    visibility: private
+    teams:
+      admins: admin
+    users:
+      testorg+ci: read
+  - name: repo-*
+    visibility: public
+    teams:
+      developers: write
+`
+
+// mergeExport exports the test organization, merges it into the
+// configuration file and updates the file.
+func mergeExport(t *testing.T, client quay.API, filename string) bool {
+	t.Helper()
+
+	cfg, err := config.LoadFromFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	exported, err := ExportConfiguration(context.Background(), testOrganization, client, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	if err := MergeConfiguration(cfg, exported); err != nil {
+		t.Fatalf("Failed to merge export: %v", err)
+	}
+
+	changed, err := config.UpdateFile(cfg, filename)
+	if err != nil {
+		t.Fatalf("Failed to update configuration: %v", err)
+	}
+
+	return changed
+}
+
+func TestMergeConfiguration(t *testing.T) {
+	_, client := newTestServer(t)
+
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte(testConfig), 0644); err != nil {
+		t.Fatalf("Failed to write configuration: %v", err)
+	}
+
+	if !mergeExport(t, client, filename) {
+		t.Fatal("Expected the outdated configuration to be updated.")
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read configuration: %v", err)
+	}
+
+	for _, comment := range []string{"# managed by Aquayman", "# the robot used by CI jobs", "# matches the secret repository exactly"} {
+		if !strings.Contains(string(content), comment) {
+			t.Errorf("Expected comment %q to be kept, but got:\n%s", comment, content)
+		}
+	}
+
+	cfg, err := config.LoadFromFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if len(cfg.Robots) != 2 || cfg.Robots[0].VaultSecret != "secret/ci" || cfg.Robots[0].Description != "CI robot" {
+		t.Errorf("Expected the robot's vaultSecret to be kept and its description updated, but got %+v.", cfg.Robots)
+	}
+
+	if len(cfg.Robots) != 2 || cfg.Robots[1].Name != "retired" || !cfg.Robots[1].Deleted {
+		t.Errorf("Expected the deleted robot to be kept, but got %+v.", cfg.Robots)
+	}
+
+	if len(cfg.Teams) != 2 || cfg.Teams[0].Name != "admins" || cfg.Teams[1].Name != "developers" {
+		t.Fatalf("Expected the existing team order to be kept, but got %+v.", cfg.Teams)
+	}
+
+	if cfg.Teams[0].Description != "" {
+		t.Errorf("Expected the description removed in Quay to be removed, but got %q.", cfg.Teams[0].Description)
+	}
+
+	names := []string{}
+	for _, repo := range cfg.Repositories {
+		names = append(names, repo.Name)
+	}
+
+	// repo-* does not cover the individual descriptions, but the
+	// secret repository is fully described by its rule
+	expected := "secr*,repo-*,repo-0,repo-1,repo-2,repo-3,repo-4"
+	if joined := strings.Join(names, ","); joined != expected {
+		t.Errorf("Expected repositories %s, but got %s.", expected, joined)
+	}
+
+	assertRoundTrip(t, cfg, client)
+}
+
+func TestMergeUnchangedConfiguration(t *testing.T) {
+	_, client := newTestServer(t)
+
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte(testConfig), 0644); err != nil {
+		t.Fatalf("Failed to write configuration: %v", err)
+	}
+
+	mergeExport(t, client, filename)
+
+	before, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read configuration: %v", err)
+	}
+
+	if mergeExport(t, client, filename) {
+		t.Error("Expected the up-to-date configuration to not change.")
+	}
+
+	after, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read configuration: %v", err)
+	}
+
+	if string(before) != string(after) {
+		t.Errorf("Expected the file to be left untouched, but got:\n%s", after)
+	}
+}
@@ -0,0 +1,49 @@
+package export
+
+import (
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+)
+
+// foldPeople replaces the Quay usernames of all people in the people
+// directory with their email address, in team members and repository
+// users.
+func foldPeople(cfg *config.Config) {
+	if len(cfg.People) == 0 {
+		return
+	}
+
+	for i, team := range cfg.Teams {
+		cfg.Teams[i].Members = peopleEmails(cfg, team.Members)
+	}
+
+	for i, repo := range cfg.Repositories {
+		if repo.Users == nil {
+			continue
+		}
+
+		users := map[string]quay.RepositoryRole{}
+		for user, role := range repo.Users {
+			users[personEmail(cfg, user)] = role
+		}
+
+		cfg.Repositories[i].Users = users
+	}
+}
+
+func peopleEmails(cfg *config.Config, users []string) []string {
+	result := []string{}
+	for _, user := range users {
+		result = append(result, personEmail(cfg, user))
+	}
+
+	return result
+}
+
+func personEmail(cfg *config.Config, username string) string {
+	if person := cfg.PersonByUsername(username); person != nil {
+		return person.Email
+	}
+
+	return username
+}
@@ -0,0 +1,195 @@
+package export
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+)
+
+// patternSeparators are the characters after which wildcard
+// patterns are considered, e.g. "kubermatic-*" or "team/*".
+const patternSeparators = "-_./"
+
+// inferWildcardRules groups repositories with identical settings into
+// wildcard rules. Rules are added greedily, as long as they reduce the
+// total number of entries. Repositories that do not match their rule
+// are listed individually, so that resolving any repository name via
+// config.Config.GetRepositoryConfig yields exactly its settings.
+func inferWildcardRules(repos []config.RepositoryConfig) []config.RepositoryConfig {
+	signatures := make([]string, len(repos))
+	for i, repo := range repos {
+		signatures[i] = repositorySignature(repo)
+	}
+
+	// determine all candidate patterns and which repositories they match
+	candidates := map[string][]int{}
+	for _, repo := range repos {
+		for _, pattern := range candidatePatterns(repo.Name) {
+			if _, exists := candidates[pattern]; exists {
+				continue
+			}
+
+			matches := []int{}
+			for i, other := range repos {
+				if ok, _ := filepath.Match(pattern, other.Name); ok {
+					matches = append(matches, i)
+				}
+			}
+
+			candidates[pattern] = matches
+		}
+	}
+
+	patterns := []string{}
+	for pattern := range candidates {
+		patterns = append(patterns, pattern)
+	}
+
+	// make the result deterministic
+	sort.Strings(patterns)
+
+	rules := []config.RepositoryConfig{}
+	ruleSignatures := []string{}
+
+	// current[i] is the index of the rule that repository i resolves
+	// to, or -1 if no rule matches
+	current := make([]int, len(repos))
+	for i := range current {
+		current[i] = -1
+	}
+
+	for {
+		bestPattern := ""
+		bestSignature := ""
+		bestGain := 0
+		bestRepo := -1
+
+		for _, pattern := range patterns {
+			// the rule would take over all matching repositories that
+			// currently resolve to a shorter (or equally long) pattern
+			affected := []int{}
+			for _, i := range candidates[pattern] {
+				if current[i] < 0 || len(rules[current[i]].Name) <= len(pattern) {
+					affected = append(affected, i)
+				}
+			}
+
+			// the rule gets the most common settings of those
+			counts := map[string]int{}
+			for _, i := range affected {
+				counts[signatures[i]]++
+			}
+
+			signature, repo := "", -1
+			for _, i := range affected {
+				if repo < 0 || counts[signatures[i]] > counts[signature] {
+					signature, repo = signatures[i], i
+				}
+			}
+
+			if repo < 0 {
+				continue
+			}
+
+			// every repository that matches its rule afterwards saves
+			// an entry, every one that did match before costs one
+			gain := -1
+			for _, i := range affected {
+				before := current[i] >= 0 && ruleSignatures[current[i]] == signatures[i]
+				after := signatures[i] == signature
+
+				if after && !before {
+					gain++
+				} else if before && !after {
+					gain--
+				}
+			}
+
+			if gain > bestGain {
+				bestPattern, bestSignature, bestGain, bestRepo = pattern, signature, gain, repo
+			}
+		}
+
+		if bestRepo < 0 {
+			break
+		}
+
+		rule := repos[bestRepo]
+		rule.Name = bestPattern
+
+		rules = append(rules, rule)
+		ruleSignatures = append(ruleSignatures, bestSignature)
+
+		for _, i := range candidates[bestPattern] {
+			if current[i] < 0 || len(rules[current[i]].Name) <= len(bestPattern) {
+				current[i] = len(rules) - 1
+			}
+		}
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Name < rules[j].Name
+	})
+
+	// list all repositories individually that are not described
+	// exactly by their rule; this resolves names the same way
+	// the synchronization does, to guarantee a correct result
+	resolver := &config.Config{Repositories: rules}
+	result := append([]config.RepositoryConfig{}, rules...)
+
+	for i, repo := range repos {
+		rule := resolver.GetRepositoryConfig(repo.Name)
+		if rule == nil || repositorySignature(*rule) != signatures[i] {
+			result = append(result, repo)
+		}
+	}
+
+	return result
+}
+
+// candidatePatterns returns all wildcard patterns that would match the
+// name, e.g. "*", "foo-*" and "foo-bar-*" for "foo-bar-baz".
+func candidatePatterns(name string) []string {
+	patterns := []string{"*"}
+
+	for i, r := range name {
+		if strings.ContainsRune(patternSeparators, r) && i < len(name)-1 {
+			patterns = append(patterns, name[:i+1]+"*")
+		}
+	}
+
+	return patterns
+}
+
+// repositorySignature returns a string that is equal for two
+// repositories if they have the same settings.
+func repositorySignature(repo config.RepositoryConfig) string {
+	parts := []string{
+		string(repo.Visibility),
+		repo.Description,
+	}
+
+	for _, team := range sortedKeys(repo.Teams) {
+		parts = append(parts, fmt.Sprintf("team:%s=%s", team, repo.Teams[team]))
+	}
+
+	for _, user := range sortedKeys(repo.Users) {
+		parts = append(parts, fmt.Sprintf("user:%s=%s", user, repo.Users[user]))
+	}
+
+	return strings.Join(parts, "\x00")
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
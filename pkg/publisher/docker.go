@@ -0,0 +1,43 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Auth  string `json:"auth"`
+	Email string `json:"email"`
+}
+
+// dockerConfigJSON returns a Docker config.json that contains the
+// credentials for a single registry.
+func dockerConfigJSON(registry string, username string, token string) (string, error) {
+	auth := fmt.Sprintf("%s:%s", username, token)
+	encoded := base64.StdEncoding.EncodeToString([]byte(auth))
+
+	cfg := dockerConfig{
+		Auths: map[string]dockerAuth{
+			registry: {
+				Auth: encoded,
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(cfg); err != nil {
+		return "", fmt.Errorf("failed to encode docker config as JSON: %w", err)
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,261 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/util"
+)
+
+// File writes each robot's token and a ready-to-use Docker config.json
+// into a directory, one subdirectory per robot:
+//
+//	<dir>/<org>+<robot>/token
+//	<dir>/<org>+<robot>/config.json
+//
+// If recipients are configured, the files are encrypted using age and
+// get an additional ".age" suffix. As encrypted files differ on every
+// run, a checksum of their content is kept in a hidden file next to
+// them, so that unchanged files are not rewritten. PGP is not supported.
+type File struct {
+	dir           string
+	mode          os.FileMode
+	recipients    []age.Recipient
+	recipientKeys []string
+	org           string
+	registry      string
+}
+
+// NewFilePublisher returns a publisher writing into dir. Recipients
+// are age public keys ("age1..."); if none are given, files are
+// written unencrypted.
+func NewFilePublisher(dir string, mode os.FileMode, recipients []string, organization string, registry string) (*File, error) {
+	if dir == "" {
+		return nil, errors.New("no directory given")
+	}
+
+	parsed := []age.Recipient{}
+	keys := []string{}
+	for _, recipient := range recipients {
+		r, err := age.ParseX25519Recipient(strings.TrimSpace(recipient))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %q: %w", recipient, err)
+		}
+
+		parsed = append(parsed, r)
+		keys = append(keys, r.String())
+	}
+
+	sort.Strings(keys)
+
+	return &File{
+		dir:           dir,
+		mode:          mode,
+		recipients:    parsed,
+		recipientKeys: keys,
+		org:           organization,
+		registry:      registry,
+	}, nil
+}
+
+func (f *File) robotDir(robot *config.RobotConfig) string {
+	return filepath.Join(f.dir, fmt.Sprintf("%s+%s", f.org, robot.Name))
+}
+
+func (f *File) filename(name string) string {
+	if len(f.recipients) > 0 {
+		return name + ".age"
+	}
+
+	return name
+}
+
+func (f *File) UpdateRobot(ctx context.Context, robot *config.RobotConfig, token string) ([]Result, error) {
+	configJson, err := dockerConfigJSON(f.registry, fmt.Sprintf("%s+%s", f.org, robot.Name), token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker config: %w", err)
+	}
+
+	dir := f.robotDir(robot)
+
+	outcome := Unchanged
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		outcome = Created
+	}
+
+	// directories need to be traversable by everyone who can read the files
+	dirMode := f.mode | ((f.mode & 0444) >> 2)
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	files := map[string]string{
+		"token":       token,
+		"config.json": configJson,
+	}
+
+	for name, content := range files {
+		changed, err := f.writeFile(filepath.Join(dir, f.filename(name)), []byte(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", name, err)
+		}
+
+		if changed && outcome == Unchanged {
+			outcome = Updated
+		}
+	}
+
+	return []Result{{Secret: dir, Outcome: outcome}}, nil
+}
+
+// writeFile writes the content into the file, unless it already
+// contains the same content. It returns whether the file was written.
+func (f *File) writeFile(filename string, content []byte) (bool, error) {
+	if len(f.recipients) == 0 {
+		if existing, err := os.ReadFile(filename); err == nil && bytes.Equal(existing, content) {
+			return false, os.Chmod(filename, f.mode)
+		}
+
+		return true, f.writeAtomic(filename, content)
+	}
+
+	// encrypted files differ on every run, so compare the checksum of
+	// the plain content (and the recipients) instead
+	checksum := f.checksum(content)
+	checksumFile := checksumFilename(filename)
+
+	if _, err := os.Stat(filename); err == nil {
+		if existing, err := os.ReadFile(checksumFile); err == nil && string(existing) == checksum {
+			return false, os.Chmod(filename, f.mode)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	w, err := age.Encrypt(&buf, f.recipients...)
+	if err != nil {
+		return false, fmt.Errorf("failed to encrypt: %w", err)
+	}
+
+	if _, err := io.Copy(w, bytes.NewReader(content)); err != nil {
+		return false, fmt.Errorf("failed to encrypt: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return false, fmt.Errorf("failed to encrypt: %w", err)
+	}
+
+	if err := f.writeAtomic(filename, buf.Bytes()); err != nil {
+		return false, err
+	}
+
+	return true, f.writeAtomic(checksumFile, []byte(checksum))
+}
+
+func (f *File) checksum(content []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(strings.Join(f.recipientKeys, ",")))
+	hash.Write([]byte{0})
+	hash.Write(content)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// checksumFilename returns the hidden file next to an encrypted file
+// that contains the checksum of its plain content.
+func checksumFilename(filename string) string {
+	return filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+".sha256")
+}
+
+// writeAtomic writes to a temporary file first, so that readers never
+// see partially written files.
+func (f *File) writeAtomic(filename string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".aquayman-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), f.mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+func (f *File) DeleteRobot(ctx context.Context, robot *config.RobotConfig) ([]Result, error) {
+	dir := f.robotDir(robot)
+
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to remove files: %w", err)
+	}
+
+	return []Result{{Secret: dir, Outcome: Deleted}}, nil
+}
+
+// StaleRobots returns the short names of all robots that have files in
+// the directory, but are not configured (anymore) to be published
+// there. Their files can be removed using DeleteRobot.
+func (f *File) StaleRobots(robots []config.RobotConfig) ([]string, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	desired := []string{}
+	for _, robot := range robots {
+		if robot.Deleted {
+			continue
+		}
+
+		if len(robot.Publishers) > 0 && !util.StringSliceContains(robot.Publishers, config.FilePublisher) {
+			continue
+		}
+
+		desired = append(desired, robot.Name)
+	}
+
+	stale := []string{}
+	for _, entry := range entries {
+		// the directory can be shared by multiple organizations
+		name, ok := strings.CutPrefix(entry.Name(), f.org+"+")
+		if !ok || !entry.IsDir() {
+			continue
+		}
+
+		if !util.StringSliceContains(desired, name) {
+			stale = append(stale, name)
+		}
+	}
+
+	return stale, nil
+}
@@ -0,0 +1,209 @@
+package publisher_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/publisher"
+)
+
+func assertOutcome(t *testing.T, results []publisher.Result, expected publisher.Outcome) {
+	t.Helper()
+
+	if len(results) != 1 || results[0].Outcome != expected {
+		t.Fatalf("Expected outcome %q, but got %+v.", expected, results)
+	}
+}
+
+func TestFileUpdateRobot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	f, err := publisher.NewFilePublisher(dir, 0600, nil, "myorg", "quay.io")
+	if err != nil {
+		t.Fatalf("Failed to create publisher: %v", err)
+	}
+
+	robot := &config.RobotConfig{Name: "ci"}
+
+	results, err := f.UpdateRobot(ctx, robot, "token-1")
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	assertOutcome(t, results, publisher.Created)
+
+	token, err := os.ReadFile(filepath.Join(dir, "myorg+ci", "token"))
+	if err != nil {
+		t.Fatalf("Failed to read token: %v", err)
+	}
+
+	if string(token) != "token-1" {
+		t.Errorf("Expected token-1, but got %q.", token)
+	}
+
+	results, err = f.UpdateRobot(ctx, robot, "token-1")
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	assertOutcome(t, results, publisher.Unchanged)
+
+	results, err = f.UpdateRobot(ctx, robot, "token-2")
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	assertOutcome(t, results, publisher.Updated)
+}
+
+func TestFileEncryption(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+
+	f, err := publisher.NewFilePublisher(dir, 0600, []string{identity.Recipient().String()}, "myorg", "quay.io")
+	if err != nil {
+		t.Fatalf("Failed to create publisher: %v", err)
+	}
+
+	robot := &config.RobotConfig{Name: "ci"}
+	filename := filepath.Join(dir, "myorg+ci", "token.age")
+
+	results, err := f.UpdateRobot(ctx, robot, "token-1")
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	assertOutcome(t, results, publisher.Created)
+
+	encrypted, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read token: %v", err)
+	}
+
+	r, err := age.Decrypt(bytes.NewReader(encrypted), identity)
+	if err != nil {
+		t.Fatalf("Failed to decrypt token: %v", err)
+	}
+
+	token, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to decrypt token: %v", err)
+	}
+
+	if string(token) != "token-1" {
+		t.Errorf("Expected token-1, but got %q.", token)
+	}
+
+	// encrypting again would produce a different file
+	results, err = f.UpdateRobot(ctx, robot, "token-1")
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	assertOutcome(t, results, publisher.Unchanged)
+
+	unchanged, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read token: %v", err)
+	}
+
+	if !bytes.Equal(encrypted, unchanged) {
+		t.Error("Expected the encrypted file to be left alone.")
+	}
+
+	results, err = f.UpdateRobot(ctx, robot, "token-2")
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	assertOutcome(t, results, publisher.Updated)
+
+	// adding a recipient requires re-encrypting the files
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+
+	f, err = publisher.NewFilePublisher(dir, 0600, []string{identity.Recipient().String(), other.Recipient().String()}, "myorg", "quay.io")
+	if err != nil {
+		t.Fatalf("Failed to create publisher: %v", err)
+	}
+
+	results, err = f.UpdateRobot(ctx, robot, "token-2")
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	assertOutcome(t, results, publisher.Updated)
+}
+
+func TestFileStaleRobots(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	f, err := publisher.NewFilePublisher(dir, 0600, nil, "myorg", "quay.io")
+	if err != nil {
+		t.Fatalf("Failed to create publisher: %v", err)
+	}
+
+	// files of another organization sharing the directory
+	otherOrg, err := publisher.NewFilePublisher(dir, 0600, nil, "otherorg", "quay.io")
+	if err != nil {
+		t.Fatalf("Failed to create publisher: %v", err)
+	}
+
+	for _, name := range []string{"ci", "removed", "vault-only"} {
+		if _, err := f.UpdateRobot(ctx, &config.RobotConfig{Name: name}, "token"); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+	}
+
+	if _, err := otherOrg.UpdateRobot(ctx, &config.RobotConfig{Name: "removed"}, "token"); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	robots := []config.RobotConfig{
+		{Name: "ci"},
+		{Name: "vault-only", Publishers: []string{config.VaultPublisher}},
+	}
+
+	stale, err := f.StaleRobots(robots)
+	if err != nil {
+		t.Fatalf("Failed to determine stale robots: %v", err)
+	}
+
+	if strings.Join(stale, ",") != "removed,vault-only" {
+		t.Fatalf("Expected removed and vault-only to be stale, but got %v.", stale)
+	}
+
+	for _, name := range stale {
+		results, err := f.DeleteRobot(ctx, &config.RobotConfig{Name: name})
+		if err != nil {
+			t.Fatalf("Failed to delete robot: %v", err)
+		}
+
+		assertOutcome(t, results, publisher.Deleted)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "otherorg+removed")); err != nil {
+		t.Errorf("Files of other organizations should not be deleted: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "myorg+ci")); err != nil {
+		t.Errorf("Files of configured robots should not be deleted: %v", err)
+	}
+}
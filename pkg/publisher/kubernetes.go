@@ -0,0 +1,295 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+)
+
+const (
+	// managedByLabel is put on all Secrets created by Aquayman, so
+	// that Secrets created by other means are never deleted.
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "aquayman"
+
+	// robotAnnotation contains the full name of the robot a Secret
+	// belongs to.
+	robotAnnotation = "aquayman.kubermatic.io/robot"
+)
+
+type Kubernetes struct {
+	client   kubernetes.Interface
+	org      string
+	registry string
+
+	// managed caches the Secrets managed by Aquayman, indexed by
+	// the full robot name, so that they only need to be listed once
+	managed       map[string][]config.KubernetesSecretConfig
+	managedListed bool
+	managedLock   sync.Mutex
+}
+
+// NewKubernetesPublisher returns a publisher that maintains image pull
+// Secrets. Any kubernetes.Interface can be used, including fake clientsets.
+func NewKubernetesPublisher(client kubernetes.Interface, organization string, registry string) *Kubernetes {
+	return &Kubernetes{
+		client:   client,
+		org:      organization,
+		registry: registry,
+	}
+}
+
+// NewKubernetesClient creates a client using the given kubeconfig file.
+// If no kubeconfig is given, the in-cluster configuration is used.
+func NewKubernetesClient(kubeconfig string) (kubernetes.Interface, error) {
+	var (
+		restConfig *rest.Config
+		err        error
+	)
+
+	if kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not load Kubernetes configuration: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not build Kubernetes client: %w", err)
+	}
+
+	return client, nil
+}
+
+func (k *Kubernetes) robotName(robot *config.RobotConfig) string {
+	return fmt.Sprintf("%s+%s", k.org, robot.Name)
+}
+
+func (k *Kubernetes) UpdateRobot(ctx context.Context, robot *config.RobotConfig, token string) ([]Result, error) {
+	results := []Result{}
+
+	if len(robot.KubernetesSecrets) > 0 {
+		configJson, err := dockerConfigJSON(k.registry, k.robotName(robot), token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Docker config: %w", err)
+		}
+
+		for _, ref := range robot.KubernetesSecrets {
+			outcome, err := k.ensureSecret(ctx, robot, ref, []byte(configJson))
+			if err != nil {
+				return results, fmt.Errorf("failed to update Secret %s/%s: %w", ref.Namespace, ref.Name, err)
+			}
+
+			results = append(results, Result{
+				Secret:  fmt.Sprintf("%s/%s", ref.Namespace, ref.Name),
+				Outcome: outcome,
+			})
+		}
+	}
+
+	// remove Secrets that are not configured for this robot anymore
+	pruned, err := k.deleteSecrets(ctx, robot, robot.KubernetesSecrets)
+	results = append(results, pruned...)
+
+	return results, err
+}
+
+func (k *Kubernetes) ensureSecret(ctx context.Context, robot *config.RobotConfig, ref config.KubernetesSecretConfig, configJson []byte) (Outcome, error) {
+	secrets := k.client.CoreV1().Secrets(ref.Namespace)
+
+	existing, err := secrets.Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+
+	if apierrors.IsNotFound(err) {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ref.Name,
+				Namespace: ref.Namespace,
+				Labels: map[string]string{
+					managedByLabel: managedByValue,
+				},
+				Annotations: map[string]string{
+					robotAnnotation: k.robotName(robot),
+				},
+			},
+			Type: corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: configJson,
+			},
+		}
+
+		if _, err := secrets.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			return "", err
+		}
+
+		k.rememberSecret(k.robotName(robot), ref)
+
+		return Created, nil
+	}
+
+	// never take over Secrets that were created by other means
+	if existing.Labels[managedByLabel] != managedByValue {
+		return "", fmt.Errorf("secret already exists, but is not managed by Aquayman (missing %s=%s label)", managedByLabel, managedByValue)
+	}
+
+	// nor Secrets of other robots, which would then fight over it
+	if owner := existing.Annotations[robotAnnotation]; owner != "" && owner != k.robotName(robot) {
+		return "", fmt.Errorf("secret already exists, but belongs to robot %s", owner)
+	}
+
+	if existing.Type != corev1.SecretTypeDockerConfigJson {
+		return "", fmt.Errorf("secret already exists with type %q", existing.Type)
+	}
+
+	if string(existing.Data[corev1.DockerConfigJsonKey]) == string(configJson) {
+		return Unchanged, nil
+	}
+
+	if existing.Annotations == nil {
+		existing.Annotations = map[string]string{}
+	}
+
+	if existing.Data == nil {
+		existing.Data = map[string][]byte{}
+	}
+
+	existing.Annotations[robotAnnotation] = k.robotName(robot)
+	existing.Data[corev1.DockerConfigJsonKey] = configJson
+
+	if _, err := secrets.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return "", err
+	}
+
+	k.rememberSecret(k.robotName(robot), ref)
+
+	return Updated, nil
+}
+
+// DeleteRobot removes all Secrets that were created for the robot. The
+// Secrets are found by their label and annotation, so this works even
+// if the robot is not part of the configuration anymore.
+func (k *Kubernetes) DeleteRobot(ctx context.Context, robot *config.RobotConfig) ([]Result, error) {
+	return k.deleteSecrets(ctx, robot, nil)
+}
+
+// deleteSecrets removes all Secrets that belong to the robot, except
+// for the given ones.
+func (k *Kubernetes) deleteSecrets(ctx context.Context, robot *config.RobotConfig, keep []config.KubernetesSecretConfig) ([]Result, error) {
+	results := []Result{}
+
+	name := k.robotName(robot)
+
+	owned, err := k.managedSecrets(ctx, name)
+	if err != nil {
+		return results, err
+	}
+
+	for _, ref := range owned {
+		if containsSecret(keep, ref) {
+			continue
+		}
+
+		err := k.client.CoreV1().Secrets(ref.Namespace).Delete(ctx, ref.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return results, fmt.Errorf("failed to delete Secret %s/%s: %w", ref.Namespace, ref.Name, err)
+		}
+
+		k.forgetSecret(name, ref)
+
+		results = append(results, Result{
+			Secret:  fmt.Sprintf("%s/%s", ref.Namespace, ref.Name),
+			Outcome: Deleted,
+		})
+	}
+
+	return results, nil
+}
+
+// managedSecrets returns the Secrets that belong to the given robot.
+// On first use, all managed Secrets are listed at once; all namespaces
+// are listed, so that Secrets are found even if their namespace is not
+// configured anymore.
+func (k *Kubernetes) managedSecrets(ctx context.Context, robot string) ([]config.KubernetesSecretConfig, error) {
+	k.managedLock.Lock()
+	defer k.managedLock.Unlock()
+
+	if !k.managedListed {
+		list, err := k.client.CoreV1().Secrets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("%s=%s", managedByLabel, managedByValue),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Secrets: %w", err)
+		}
+
+		k.managed = map[string][]config.KubernetesSecretConfig{}
+
+		for _, secret := range list.Items {
+			owner := secret.Annotations[robotAnnotation]
+			if owner == "" {
+				continue
+			}
+
+			k.managed[owner] = append(k.managed[owner], config.KubernetesSecretConfig{
+				Namespace: secret.Namespace,
+				Name:      secret.Name,
+			})
+		}
+
+		k.managedListed = true
+	}
+
+	return append([]config.KubernetesSecretConfig{}, k.managed[robot]...), nil
+}
+
+// rememberSecret adds a Secret to the cached managed Secrets.
+func (k *Kubernetes) rememberSecret(robot string, ref config.KubernetesSecretConfig) {
+	k.managedLock.Lock()
+	defer k.managedLock.Unlock()
+
+	// the Secret will be found when listing
+	if !k.managedListed || containsSecret(k.managed[robot], ref) {
+		return
+	}
+
+	k.managed[robot] = append(k.managed[robot], ref)
+}
+
+// forgetSecret removes a Secret from the cached managed Secrets.
+func (k *Kubernetes) forgetSecret(robot string, ref config.KubernetesSecretConfig) {
+	k.managedLock.Lock()
+	defer k.managedLock.Unlock()
+
+	remaining := []config.KubernetesSecretConfig{}
+	for _, other := range k.managed[robot] {
+		if other != ref {
+			remaining = append(remaining, other)
+		}
+	}
+
+	k.managed[robot] = remaining
+}
+
+func containsSecret(secrets []config.KubernetesSecretConfig, secret config.KubernetesSecretConfig) bool {
+	for _, other := range secrets {
+		if other == secret {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,322 @@
+package publisher_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/publisher"
+)
+
+// robotAnnotation contains the full name of the robot a Secret
+// belongs to.
+const robotAnnotation = "aquayman.kubermatic.io/robot"
+
+func newTestKubernetes(objects ...corev1.Secret) (*publisher.Kubernetes, *fake.Clientset) {
+	clientset := fake.NewSimpleClientset()
+	for i := range objects {
+		_ = clientset.Tracker().Add(&objects[i])
+	}
+
+	return publisher.NewKubernetesPublisher(clientset, "myorg", "quay.io"), clientset
+}
+
+func getSecret(t *testing.T, clientset *fake.Clientset, namespace string, name string) *corev1.Secret {
+	t.Helper()
+
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		t.Fatalf("Failed to get Secret: %v", err)
+	}
+
+	return secret
+}
+
+func assertOutcomes(t *testing.T, results []publisher.Result, expected map[string]publisher.Outcome) {
+	t.Helper()
+
+	actual := map[string]publisher.Outcome{}
+	for _, result := range results {
+		actual[result.Secret] = result.Outcome
+	}
+
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected results %v, but got %v.", expected, actual)
+	}
+
+	for secret, outcome := range expected {
+		if actual[secret] != outcome {
+			t.Fatalf("Expected results %v, but got %v.", expected, actual)
+		}
+	}
+}
+
+func TestKubernetesUpdateRobot(t *testing.T) {
+	ctx := context.Background()
+	k, clientset := newTestKubernetes()
+
+	robot := &config.RobotConfig{
+		Name: "ci",
+		KubernetesSecrets: []config.KubernetesSecretConfig{
+			{Namespace: "default", Name: "pull-secret"},
+			{Namespace: "apps", Name: "pull-secret"},
+		},
+	}
+
+	results, err := k.UpdateRobot(ctx, robot, "token-1")
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	assertOutcomes(t, results, map[string]publisher.Outcome{
+		"default/pull-secret": publisher.Created,
+		"apps/pull-secret":    publisher.Created,
+	})
+
+	secret := getSecret(t, clientset, "default", "pull-secret")
+	if secret == nil {
+		t.Fatal("Secret was not created.")
+	}
+
+	if secret.Type != corev1.SecretTypeDockerConfigJson {
+		t.Errorf("Expected Secret type %q, but got %q.", corev1.SecretTypeDockerConfigJson, secret.Type)
+	}
+
+	if secret.Annotations[robotAnnotation] != "myorg+ci" {
+		t.Errorf("Expected robot annotation, but got %v.", secret.Annotations)
+	}
+
+	var dockerConfig struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+
+	if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &dockerConfig); err != nil {
+		t.Fatalf("Secret does not contain a valid Docker config: %v", err)
+	}
+
+	if _, exists := dockerConfig.Auths["quay.io"]; !exists {
+		t.Errorf("Docker config does not contain credentials for quay.io: %v", dockerConfig.Auths)
+	}
+
+	// publishing the same token again changes nothing
+	results, err = k.UpdateRobot(ctx, robot, "token-1")
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	assertOutcomes(t, results, map[string]publisher.Outcome{
+		"default/pull-secret": publisher.Unchanged,
+		"apps/pull-secret":    publisher.Unchanged,
+	})
+
+	results, err = k.UpdateRobot(ctx, robot, "token-2")
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	assertOutcomes(t, results, map[string]publisher.Outcome{
+		"default/pull-secret": publisher.Updated,
+		"apps/pull-secret":    publisher.Updated,
+	})
+}
+
+func TestKubernetesPrunesRemovedSecrets(t *testing.T) {
+	ctx := context.Background()
+	k, clientset := newTestKubernetes()
+
+	robot := &config.RobotConfig{
+		Name: "ci",
+		KubernetesSecrets: []config.KubernetesSecretConfig{
+			{Namespace: "default", Name: "pull-secret"},
+			{Namespace: "apps", Name: "pull-secret"},
+		},
+	}
+
+	other := &config.RobotConfig{
+		Name: "other",
+		KubernetesSecrets: []config.KubernetesSecretConfig{
+			{Namespace: "apps", Name: "other-secret"},
+		},
+	}
+
+	for _, r := range []*config.RobotConfig{robot, other} {
+		if _, err := k.UpdateRobot(ctx, r, "token"); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+	}
+
+	robot.KubernetesSecrets = robot.KubernetesSecrets[:1]
+
+	results, err := k.UpdateRobot(ctx, robot, "token")
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	assertOutcomes(t, results, map[string]publisher.Outcome{
+		"default/pull-secret": publisher.Unchanged,
+		"apps/pull-secret":    publisher.Deleted,
+	})
+
+	if getSecret(t, clientset, "apps", "pull-secret") != nil {
+		t.Error("Removed Secret should have been deleted.")
+	}
+
+	if getSecret(t, clientset, "apps", "other-secret") == nil {
+		t.Error("Secret of another robot should not have been deleted.")
+	}
+
+	// removing all Secrets from the robot deletes the remaining one
+	robot.KubernetesSecrets = nil
+
+	results, err = k.UpdateRobot(ctx, robot, "token")
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	assertOutcomes(t, results, map[string]publisher.Outcome{
+		"default/pull-secret": publisher.Deleted,
+	})
+}
+
+func TestKubernetesDeleteUnconfiguredRobot(t *testing.T) {
+	ctx := context.Background()
+
+	unmanaged := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "handmade",
+			Annotations: map[string]string{robotAnnotation: "myorg+ci"},
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+	}
+
+	k, clientset := newTestKubernetes(unmanaged)
+
+	robot := &config.RobotConfig{
+		Name: "ci",
+		KubernetesSecrets: []config.KubernetesSecretConfig{
+			{Namespace: "default", Name: "pull-secret"},
+		},
+	}
+
+	if _, err := k.UpdateRobot(ctx, robot, "token"); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	// robots removed from the configuration are deleted without
+	// knowing their Secrets
+	results, err := k.DeleteRobot(ctx, &config.RobotConfig{Name: "ci"})
+	if err != nil {
+		t.Fatalf("Failed to delete robot: %v", err)
+	}
+
+	assertOutcomes(t, results, map[string]publisher.Outcome{
+		"default/pull-secret": publisher.Deleted,
+	})
+
+	if getSecret(t, clientset, "default", "handmade") == nil {
+		t.Error("Secret without the managed-by label should not have been deleted.")
+	}
+}
+
+func TestKubernetesRefusesUnmanagedSecrets(t *testing.T) {
+	ctx := context.Background()
+
+	existing := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "pull-secret",
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`),
+		},
+	}
+
+	k, clientset := newTestKubernetes(existing)
+
+	robot := &config.RobotConfig{
+		Name: "ci",
+		KubernetesSecrets: []config.KubernetesSecretConfig{
+			{Namespace: "default", Name: "pull-secret"},
+		},
+	}
+
+	if _, err := k.UpdateRobot(ctx, robot, "token"); err == nil {
+		t.Fatal("Expected publishing into an unmanaged Secret to fail.")
+	}
+
+	secret := getSecret(t, clientset, "default", "pull-secret")
+	if string(secret.Data[corev1.DockerConfigJsonKey]) != `{"auths":{}}` {
+		t.Error("Unmanaged Secret should not have been modified.")
+	}
+}
+
+func TestKubernetesListsSecretsOnce(t *testing.T) {
+	ctx := context.Background()
+	k, clientset := newTestKubernetes()
+
+	robots := []*config.RobotConfig{
+		{Name: "no-secrets"},
+		{Name: "ci", KubernetesSecrets: []config.KubernetesSecretConfig{{Namespace: "default", Name: "pull-secret"}}},
+		{Name: "other"},
+	}
+
+	for _, robot := range robots {
+		if _, err := k.UpdateRobot(ctx, robot, "token"); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+	}
+
+	if _, err := k.DeleteRobot(ctx, robots[1]); err != nil {
+		t.Fatalf("Failed to delete robot: %v", err)
+	}
+
+	lists := 0
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "list" {
+			lists++
+		}
+	}
+
+	if lists != 1 {
+		t.Fatalf("Expected Secrets to be listed once, but got %d lists.", lists)
+	}
+
+	// the Secret created during this run is known without listing again
+	if getSecret(t, clientset, "default", "pull-secret") != nil {
+		t.Error("Secret of the deleted robot should have been deleted.")
+	}
+}
+
+func TestKubernetesRefusesSecretsOfOtherRobots(t *testing.T) {
+	ctx := context.Background()
+	k, clientset := newTestKubernetes()
+
+	ref := config.KubernetesSecretConfig{Namespace: "default", Name: "pull-secret"}
+
+	if _, err := k.UpdateRobot(ctx, &config.RobotConfig{Name: "ci", KubernetesSecrets: []config.KubernetesSecretConfig{ref}}, "ci-token"); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	if _, err := k.UpdateRobot(ctx, &config.RobotConfig{Name: "deploy", KubernetesSecrets: []config.KubernetesSecretConfig{ref}}, "deploy-token"); err == nil {
+		t.Fatal("Expected publishing into a Secret of another robot to fail.")
+	}
+
+	secret := getSecret(t, clientset, "default", "pull-secret")
+	if secret.Annotations[robotAnnotation] != "myorg+ci" {
+		t.Errorf("Expected the Secret to still belong to ci, but got %v.", secret.Annotations)
+	}
+}
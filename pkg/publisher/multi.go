@@ -0,0 +1,67 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/util"
+)
+
+// Target is a named publisher. The name is used to select publishers
+// per robot (see config.RobotConfig.Publishers) and in error messages.
+type Target struct {
+	Name      string
+	Publisher Publisher
+}
+
+// Multi forwards all calls to a number of publishers. A failing
+// publisher does not prevent the others from being called; instead
+// all errors are collected and returned together.
+type Multi struct {
+	targets []Target
+}
+
+func NewMultiPublisher(targets ...Target) *Multi {
+	return &Multi{
+		targets: targets,
+	}
+}
+
+func (m *Multi) UpdateRobot(ctx context.Context, robot *config.RobotConfig, token string) ([]Result, error) {
+	return m.forEach(robot, func(pub Publisher) ([]Result, error) {
+		return pub.UpdateRobot(ctx, robot, token)
+	})
+}
+
+func (m *Multi) DeleteRobot(ctx context.Context, robot *config.RobotConfig) ([]Result, error) {
+	return m.forEach(robot, func(pub Publisher) ([]Result, error) {
+		return pub.DeleteRobot(ctx, robot)
+	})
+}
+
+func (m *Multi) forEach(robot *config.RobotConfig, fn func(pub Publisher) ([]Result, error)) ([]Result, error) {
+	var (
+		results []Result
+		errs    []error
+	)
+
+	for _, target := range m.targets {
+		if len(robot.Publishers) > 0 && !util.StringSliceContains(robot.Publishers, target.Name) {
+			continue
+		}
+
+		targetResults, err := fn(target.Publisher)
+		for _, result := range targetResults {
+			result.Publisher = target.Name
+			results = append(results, result)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", target.Name, err))
+		}
+	}
+
+	return results, errors.Join(errs...)
+}
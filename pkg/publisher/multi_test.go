@@ -0,0 +1,144 @@
+package publisher_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/publisher"
+)
+
+// fakePublisher records the robots it was called for.
+type fakePublisher struct {
+	secret string
+	err    error
+	calls  []string
+}
+
+func (p *fakePublisher) UpdateRobot(ctx context.Context, robot *config.RobotConfig, token string) ([]publisher.Result, error) {
+	return p.call("update " + robot.Name)
+}
+
+func (p *fakePublisher) DeleteRobot(ctx context.Context, robot *config.RobotConfig) ([]publisher.Result, error) {
+	return p.call("delete " + robot.Name)
+}
+
+func (p *fakePublisher) call(name string) ([]publisher.Result, error) {
+	p.calls = append(p.calls, name)
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	return []publisher.Result{{Secret: p.secret, Outcome: publisher.Updated}}, nil
+}
+
+func TestMultiPublishers(t *testing.T) {
+	testcases := []struct {
+		name       string
+		publishers []string
+		calls      []string
+	}{
+		{
+			name:  "all targets by default",
+			calls: []string{"vault", "kubernetes", "file"},
+		},
+		{
+			name:       "selected targets",
+			publishers: []string{"file", "vault"},
+			calls:      []string{"vault", "file"},
+		},
+		{
+			name:       "unknown target",
+			publishers: []string{"nope"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakes := map[string]*fakePublisher{}
+			targets := []publisher.Target{}
+
+			for _, name := range []string{"vault", "kubernetes", "file"} {
+				fakes[name] = &fakePublisher{secret: name + "-secret"}
+				targets = append(targets, publisher.Target{Name: name, Publisher: fakes[name]})
+			}
+
+			multi := publisher.NewMultiPublisher(targets...)
+			robot := &config.RobotConfig{Name: "ci", Publishers: tc.publishers}
+
+			results, err := multi.UpdateRobot(context.Background(), robot, "token")
+			if err != nil {
+				t.Fatalf("Failed to publish: %v", err)
+			}
+
+			called := []string{}
+			for _, result := range results {
+				if result.Secret != result.Publisher+"-secret" {
+					t.Errorf("Expected result of %s to be attributed to it, but got %+v.", result.Secret, result)
+				}
+
+				called = append(called, result.Publisher)
+			}
+
+			if strings.Join(called, ",") != strings.Join(tc.calls, ",") {
+				t.Fatalf("Expected results from %v, but got %v.", tc.calls, called)
+			}
+
+			for name, fake := range fakes {
+				expected := 0
+				for _, call := range tc.calls {
+					if call == name {
+						expected = 1
+					}
+				}
+
+				if len(fake.calls) != expected {
+					t.Errorf("Expected %s to be called %d time(s), but got %v.", name, expected, fake.calls)
+				}
+			}
+		})
+	}
+}
+
+func TestMultiFailingTarget(t *testing.T) {
+	vault := &fakePublisher{secret: "vault-secret"}
+	kubernetes := &fakePublisher{err: errors.New("cluster unreachable")}
+	file := &fakePublisher{secret: "file-secret"}
+
+	multi := publisher.NewMultiPublisher(
+		publisher.Target{Name: "vault", Publisher: vault},
+		publisher.Target{Name: "kubernetes", Publisher: kubernetes},
+		publisher.Target{Name: "file", Publisher: file},
+	)
+
+	robot := &config.RobotConfig{Name: "ci"}
+
+	results, err := multi.DeleteRobot(context.Background(), robot)
+	if err == nil {
+		t.Fatal("Expected the failing target to return an error.")
+	}
+
+	if expected := "kubernetes: cluster unreachable"; err.Error() != expected {
+		t.Errorf("Expected error %q, but got %q.", expected, err.Error())
+	}
+
+	// the other targets must still be called
+	if len(results) != 2 || results[0].Publisher != "vault" || results[1].Publisher != "file" {
+		t.Errorf("Expected results from vault and file, but got %+v.", results)
+	}
+
+	if len(vault.calls) != 1 || len(file.calls) != 1 || vault.calls[0] != "delete ci" {
+		t.Errorf("Expected all targets to be called, but got %v and %v.", vault.calls, file.calls)
+	}
+
+	// errors of multiple targets are all reported
+	file.err = errors.New("disk full")
+
+	_, err = multi.DeleteRobot(context.Background(), robot)
+	if err == nil || !strings.Contains(err.Error(), "kubernetes: cluster unreachable") || !strings.Contains(err.Error(), "file: disk full") {
+		t.Errorf("Expected errors of both failing targets, but got %v.", err)
+	}
+}
@@ -6,7 +6,27 @@ import (
 	"github.com/kubermatic-labs/aquayman/pkg/config"
 )
 
+// Outcome describes what a publisher did with a single secret.
+type Outcome string
+
+const (
+	Created   Outcome = "created"
+	Updated   Outcome = "updated"
+	Unchanged Outcome = "unchanged"
+	Deleted   Outcome = "deleted"
+)
+
+// Result is the outcome for a single secret (e.g. a Vault secret or
+// a Kubernetes Secret) that a publisher manages for a robot.
+type Result struct {
+	// Publisher is the name of the publisher, see Target.
+	Publisher string
+	// Secret identifies the secret, e.g. "secret/customer-xyz".
+	Secret  string
+	Outcome Outcome
+}
+
 type Publisher interface {
-	UpdateRobot(ctx context.Context, robot *config.RobotConfig, token string) error
-	DeleteRobot(ctx context.Context, robot *config.RobotConfig) error
+	UpdateRobot(ctx context.Context, robot *config.RobotConfig, token string) ([]Result, error)
+	DeleteRobot(ctx context.Context, robot *config.RobotConfig) ([]Result, error)
 }
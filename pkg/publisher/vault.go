@@ -1,194 +1,277 @@
 package publisher
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
+	"text/template"
 
 	"github.com/hashicorp/vault/api"
 	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/statefile"
 )
 
 type Vault struct {
-	client *api.Client
-	org    string
+	client   *api.Client
+	org      string
+	registry string
+	config   *config.VaultConfig
+
+	// mounts caches the known KV mounts
+	mounts        []*kvMount
+	mountsListed  bool
+	listMountsErr error
+	mountsLock    sync.Mutex
+
+	// state, if set, is used to remember which fields were
+	// written for which robot
+	state     *statefile.StateFile
+	stateLock sync.Mutex
 }
 
-// NewVaultPublisher relies on VAULT_ADDR and VAULT_TOKEN env
-// variables be set.
-func NewVaultPublisher(organization string) (*Vault, error) {
-	client, err := api.NewClient(nil)
-	if err != nil {
-		return nil, fmt.Errorf("could not build Vault client: %w", err)
+// NewVaultPublisher returns a publisher that writes into Vault using
+// the given client, which must already be logged in (see
+// NewVaultClient). The client can be shared between the publishers of
+// multiple organizations. The registry is the hostname that is used in
+// the generated Docker configs and default key names. If a state file
+// is given, the written secrets are recorded in it, so they can be
+// cleaned up later.
+func NewVaultPublisher(client *api.Client, organization string, registry string, cfg *config.VaultConfig, state *statefile.StateFile) *Vault {
+	if cfg == nil {
+		cfg = &config.VaultConfig{}
 	}
 
 	return &Vault{
-		client: client,
-		org:    organization,
-	}, nil
+		client:   client,
+		org:      organization,
+		registry: registry,
+		config:   cfg,
+		state:    state,
+	}
 }
 
-func (v *Vault) UpdateRobot(ctx context.Context, robot *config.RobotConfig, token string) error {
+func (v *Vault) UpdateRobot(ctx context.Context, robot *config.RobotConfig, token string) ([]Result, error) {
 	if robot.VaultSecret == "" {
-		return nil
+		return nil, nil
 	}
 
-	addr, err := v.getAddress(robot)
+	addr, err := v.getAddress(ctx, robot)
 	if err != nil {
-		return fmt.Errorf("invalid configuration: %w", err)
-	}
-
-	// fetch current state, so we do not need to bump if there are no changes to the token
-	secret, err := v.client.Logical().Read(addr.path)
-	if err != nil {
-		return fmt.Errorf("failed to read from Vault: %w", err)
+		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
 	// precalculate a nice to use docker pull config JSON file
 	configJson, err := v.getDockerConfig(robot, token)
 	if err != nil {
-		return fmt.Errorf("failed to create Docker config: %w", err)
+		return nil, fmt.Errorf("failed to create Docker config: %w", err)
 	}
 
-	secretUpToDate := false
-	existingData := map[string]interface{}{}
-
-	if secret != nil {
-		// the secrets are wrapped in a "data" field,
-		// that's just how kv stores in Vault work
-
-		if data, exists := secret.Data["data"]; exists {
-			if m, ok := data.(map[string]interface{}); ok {
-				existingData = m
-
-				if value, exists := m[addr.key+"-token"]; exists {
-					if svalue, ok := value.(string); ok {
-						secretUpToDate = svalue == token
-					}
-				}
+	fields, err := v.renderFields(robot, addr, token, configJson)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
 
-				if secretUpToDate {
-					if value, exists := m[addr.key+"-config"]; exists {
-						if svalue, ok := value.(string); ok {
-							secretUpToDate = svalue == configJson
-						}
-					}
-				}
+	outcome, err := v.updateSecret(ctx, addr.path, func(data map[string]interface{}) Outcome {
+		// do not bump the secret if there are no changes to the token;
+		// as secrets can be shared between robots, a secret counts as
+		// created if none of the robot's fields existed before
+		existing := 0
+		changed := false
+
+		for name, value := range fields {
+			current, exists := data[name]
+			if exists {
+				existing++
 			}
-		}
-	}
 
-	if !secretUpToDate {
-		if secret == nil {
-			secret = &api.Secret{
-				Data: map[string]interface{}{},
+			if s, ok := current.(string); !ok || s != value {
+				data[name] = value
+				changed = true
 			}
 		}
 
-		existingData[addr.key+"-token"] = token
-		existingData[addr.key+"-config"] = configJson
-
-		secret.Data["data"] = existingData
-
-		if _, err := v.client.Logical().Write(addr.path, secret.Data); err != nil {
-			return fmt.Errorf("failed to update Vault: %w", err)
+		switch {
+		case !changed:
+			return Unchanged
+		case existing == 0:
+			return Created
+		default:
+			return Updated
 		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
-}
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
+	}
 
-type dockerConfig struct {
-	Auths map[string]dockerAuth `json:"auths"`
-}
+	v.recordFields(robot.Name, addr.path.String(), names)
 
-type dockerAuth struct {
-	Auth  string `json:"auth"`
-	Email string `json:"email"`
+	return []Result{{Secret: addr.path.String(), Outcome: outcome}}, nil
 }
 
 func (v *Vault) getDockerConfig(robot *config.RobotConfig, token string) (string, error) {
-	auth := fmt.Sprintf("%s+%s:%s", v.org, robot.Name, token)
-	encoded := base64.StdEncoding.EncodeToString([]byte(auth))
+	return dockerConfigJSON(v.registry, fmt.Sprintf("%s+%s", v.org, robot.Name), token)
+}
+
+func (v *Vault) DeleteRobot(ctx context.Context, robot *config.RobotConfig) ([]Result, error) {
+	// robots that are not configured anymore can still be cleaned
+	// up if their secrets were recorded in the state file
+	secrets := v.recordedSecrets(v.fullName(robot.Name))
 
-	cfg := dockerConfig{
-		Auths: map[string]dockerAuth{
-			"quay.io": {
-				Auth: encoded,
-			},
-		},
+	if robot.VaultSecret != "" {
+		addr, err := v.getAddress(ctx, robot)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+
+		// the names must not depend on the token, so they can be
+		// rendered without knowing it
+		fields, err := v.renderFields(robot, addr, "", "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+
+		names := []string{}
+		for name := range fields {
+			names = append(names, name)
+		}
+
+		secrets = append(secrets, statefile.VaultSecretState{
+			Path:   addr.path.String(),
+			Fields: names,
+		})
 	}
 
-	var buf bytes.Buffer
+	results := []Result{}
 
-	encoder := json.NewEncoder(&buf)
-	encoder.SetIndent("", "  ")
+	for _, secret := range secrets {
+		outcome, err := v.deleteFields(ctx, v.fullName(robot.Name), secret.Path, secret.Fields)
+		if err != nil {
+			return results, err
+		}
 
-	if err := encoder.Encode(cfg); err != nil {
-		return "", fmt.Errorf("failed to encode docker config as JSON: %w", err)
+		if outcome == Deleted {
+			results = append(results, Result{Secret: secret.Path, Outcome: outcome})
+		}
 	}
 
-	return buf.String(), nil
+	return results, nil
 }
 
-func (v *Vault) DeleteRobot(ctx context.Context, robot *config.RobotConfig) error {
-	if robot.VaultSecret == "" {
-		return nil
-	}
+// VaultTemplateData is available in the templates for Vault field
+// names and values.
+type VaultTemplateData struct {
+	// Key is the default key prefix ("<registry>-<org>-<robot>"), or
+	// the custom key given in the robot's vaultSecret.
+	Key string
+	// Organization is the Quay organization name.
+	Organization string
+	// Robot is the robot's short name.
+	Robot string
+	// Username is the robot's full name ("<org>+<robot>").
+	Username string
+	// Registry is the registry hostname.
+	Registry string
+	// Token is the robot's token. It is empty when field names
+	// are rendered.
+	Token string
+	// DockerConfig is a Docker config JSON. It is empty when field
+	// names are rendered.
+	DockerConfig string
+}
 
-	addr, err := v.getAddress(robot)
-	if err != nil {
-		return fmt.Errorf("invalid configuration: %w", err)
-	}
+var defaultVaultFields = map[string]string{
+	"{{ .Key }}-token":  "{{ .Token }}",
+	"{{ .Key }}-config": "{{ .DockerConfig }}",
+}
 
-	// fetch current state, so we do not need to bump if there are no changes to the token
-	secret, err := v.client.Logical().Read(addr.path)
-	if err != nil {
-		return fmt.Errorf("failed to read from Vault: %w", err)
+// renderFields returns the fields to write into the robot's secret.
+// The field names are always rendered without the token, so that
+// the same names can be found again when deleting a robot.
+func (v *Vault) renderFields(robot *config.RobotConfig, addr *address, token string, dockerConfig string) (map[string]string, error) {
+	templates := defaultVaultFields
+	if len(robot.VaultFields) > 0 {
+		templates = robot.VaultFields
+	} else if len(v.config.Fields) > 0 {
+		templates = v.config.Fields
 	}
 
-	if secret == nil {
-		return nil
+	data := VaultTemplateData{
+		Key:          addr.key,
+		Organization: v.org,
+		Robot:        robot.Name,
+		Username:     fmt.Sprintf("%s+%s", v.org, robot.Name),
+		Registry:     v.registry,
 	}
 
-	// the secrets are wrapped in a "data" field,
-	// that's just how kv stores in Vault work
+	fields := map[string]string{}
+	for nameTpl, valueTpl := range templates {
+		name, err := renderTemplate(nameTpl, data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field name %q: %w", nameTpl, err)
+		}
 
-	if data, exists := secret.Data["data"]; exists {
-		if m, ok := data.(map[string]interface{}); ok {
-			if _, exists := m[addr.key]; exists {
-				delete(m, addr.key+"-token")
-				delete(m, addr.key+"-config")
+		if name == "" {
+			return nil, fmt.Errorf("field name %q renders to an empty string", nameTpl)
+		}
 
-				secret.Data["data"] = m
+		if _, exists := fields[name]; exists {
+			return nil, fmt.Errorf("field name %q is rendered more than once", name)
+		}
 
-				if _, err := v.client.Logical().Write(addr.path, secret.Data); err != nil {
-					return fmt.Errorf("failed to update Vault: %w", err)
-				}
-			}
+		valueData := data
+		valueData.Token = token
+		valueData.DockerConfig = dockerConfig
+
+		value, err := renderTemplate(valueTpl, valueData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for field %q: %w", name, err)
 		}
+
+		fields[name] = value
 	}
 
-	return nil
+	return fields, nil
+}
+
+func renderTemplate(tpl string, data interface{}) (string, error) {
+	t, err := template.New("field").Option("missingkey=error").Parse(tpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf strings.Builder
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
 }
 
 type address struct {
-	path string
+	path *kvPath
 	key  string
 }
 
-func (v *Vault) getAddress(robot *config.RobotConfig) (*address, error) {
+func (v *Vault) getAddress(ctx context.Context, robot *config.RobotConfig) (*address, error) {
 	parts := strings.Split(robot.VaultSecret, "#")
 	if len(parts) > 2 {
 		return nil, fmt.Errorf("invalid path %q: must not contain more than one # symbol", robot.VaultSecret)
 	}
 
+	path, err := v.resolvePath(ctx, parts[0])
+	if err != nil {
+		return nil, err
+	}
+
 	a := address{
-		path: parts[0],
-		key:  fmt.Sprintf("quay.io-%s-%s", v.org, robot.Name),
+		path: path,
+		key:  fmt.Sprintf("%s-%s-%s", v.registry, v.org, robot.Name),
 	}
 
 	if len(parts) > 1 {
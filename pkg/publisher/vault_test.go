@@ -0,0 +1,384 @@
+package publisher_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/publisher"
+	"github.com/kubermatic-labs/aquayman/pkg/publisher/vaulttest"
+	"github.com/kubermatic-labs/aquayman/pkg/statefile"
+)
+
+func newTestVault(t *testing.T, version int, cfg *config.VaultConfig, state *statefile.StateFile) (*vaulttest.Server, *vaulttest.Mount, *publisher.Vault) {
+	t.Helper()
+
+	server := vaulttest.NewServer()
+	t.Cleanup(server.Close)
+
+	mount := server.AddKVMount("secret", version)
+
+	v, err := server.NewPublisher("myorg", "quay.io", cfg, state)
+	if err != nil {
+		t.Fatalf("Failed to create publisher: %v", err)
+	}
+
+	return server, mount, v
+}
+
+func assertVaultOutcome(t *testing.T, results []publisher.Result, expected publisher.Outcome) {
+	t.Helper()
+
+	if len(results) != 1 || results[0].Outcome != expected || results[0].Secret != "secret/customer" {
+		t.Fatalf("Expected outcome %q for secret/customer, but got %+v.", expected, results)
+	}
+}
+
+func assertFields(t *testing.T, server *vaulttest.Server, expected map[string]string) {
+	t.Helper()
+
+	data := server.SecretData("secret/customer")
+	if len(data) != len(expected) {
+		t.Fatalf("Expected fields %v, but got %v.", expected, data)
+	}
+
+	for name, value := range expected {
+		if data[name] != value {
+			t.Fatalf("Expected fields %v, but got %v.", expected, data)
+		}
+	}
+}
+
+func TestVaultUpdateRobot(t *testing.T) {
+	testcases := []struct {
+		name           string
+		version        int
+		vaultSecret    string
+		denyListMounts bool
+	}{
+		{
+			name:        "KV v1",
+			version:     1,
+			vaultSecret: "secret/customer",
+		},
+		{
+			name:        "KV v2",
+			version:     2,
+			vaultSecret: "secret/customer",
+		},
+		{
+			name:        "KV v2 with data segment",
+			version:     2,
+			vaultSecret: "secret/data/customer",
+		},
+		{
+			name:           "KV v2 without listing mounts",
+			version:        2,
+			vaultSecret:    "secret/customer",
+			denyListMounts: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			server, mount, v := newTestVault(t, tc.version, nil, nil)
+
+			server.DenyListMounts = tc.denyListMounts
+
+			// fields of other applications must be left alone
+			mount.Secrets["customer"] = &vaulttest.Secret{
+				Data:    map[string]interface{}{"unrelated": "keep"},
+				Version: 1,
+			}
+
+			robot := &config.RobotConfig{Name: "ci", VaultSecret: tc.vaultSecret}
+
+			results, err := v.UpdateRobot(ctx, robot, "token-1")
+			if err != nil {
+				t.Fatalf("Failed to publish: %v", err)
+			}
+
+			assertVaultOutcome(t, results, publisher.Created)
+
+			data := server.SecretData("secret/customer")
+			if data["quay.io-myorg-ci-token"] != "token-1" {
+				t.Fatalf("Expected token to be written, but got %v.", data)
+			}
+
+			var dockerConfig struct {
+				Auths map[string]struct {
+					Auth string `json:"auth"`
+				} `json:"auths"`
+			}
+
+			configJSON, _ := data["quay.io-myorg-ci-config"].(string)
+			if err := json.Unmarshal([]byte(configJSON), &dockerConfig); err != nil {
+				t.Fatalf("Secret does not contain a valid Docker config: %v", err)
+			}
+
+			expected := base64.StdEncoding.EncodeToString([]byte("myorg+ci:token-1"))
+			if auth := dockerConfig.Auths["quay.io"]; auth.Auth != expected {
+				t.Errorf("Docker config does not contain the robot's credentials for quay.io: %v", dockerConfig.Auths)
+			}
+
+			// publishing the same token again must not write anything
+			writes := server.Writes
+
+			results, err = v.UpdateRobot(ctx, robot, "token-1")
+			if err != nil {
+				t.Fatalf("Failed to publish: %v", err)
+			}
+
+			assertVaultOutcome(t, results, publisher.Unchanged)
+
+			if server.Writes != writes {
+				t.Errorf("Expected unchanged secret to not be written again, but got %d write(s).", server.Writes-writes)
+			}
+
+			results, err = v.UpdateRobot(ctx, robot, "token-2")
+			if err != nil {
+				t.Fatalf("Failed to publish: %v", err)
+			}
+
+			assertVaultOutcome(t, results, publisher.Updated)
+
+			if data := server.SecretData("secret/customer"); data["quay.io-myorg-ci-token"] != "token-2" {
+				t.Fatalf("Expected token to be updated, but got %v.", data)
+			}
+
+			results, err = v.DeleteRobot(ctx, robot)
+			if err != nil {
+				t.Fatalf("Failed to delete robot: %v", err)
+			}
+
+			assertVaultOutcome(t, results, publisher.Deleted)
+			assertFields(t, server, map[string]string{"unrelated": "keep"})
+
+			// deleting again finds nothing to delete
+			results, err = v.DeleteRobot(ctx, robot)
+			if err != nil {
+				t.Fatalf("Failed to delete robot: %v", err)
+			}
+
+			if len(results) != 0 {
+				t.Fatalf("Expected no results, but got %+v.", results)
+			}
+		})
+	}
+}
+
+func TestVaultCustomKey(t *testing.T) {
+	ctx := context.Background()
+	server, _, v := newTestVault(t, 2, nil, nil)
+
+	robot := &config.RobotConfig{Name: "ci", VaultSecret: "secret/customer#mykey"}
+
+	results, err := v.UpdateRobot(ctx, robot, "token")
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	assertVaultOutcome(t, results, publisher.Created)
+
+	data := server.SecretData("secret/customer")
+	if len(data) != 2 || data["mykey-token"] != "token" || data["mykey-config"] == nil {
+		t.Fatalf("Expected mykey-token and mykey-config fields, but got %v.", data)
+	}
+
+	results, err = v.DeleteRobot(ctx, robot)
+	if err != nil {
+		t.Fatalf("Failed to delete robot: %v", err)
+	}
+
+	assertVaultOutcome(t, results, publisher.Deleted)
+	assertFields(t, server, map[string]string{})
+}
+
+func TestVaultTemplatedFields(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := &config.VaultConfig{
+		Fields: map[string]string{
+			"{{ .Robot }}-username": "{{ .Username }}",
+			"{{ .Robot }}-password": "{{ .Token }}",
+		},
+	}
+
+	server, _, v := newTestVault(t, 1, cfg, nil)
+
+	robot := &config.RobotConfig{Name: "ci", VaultSecret: "secret/customer"}
+
+	// robot-specific fields replace the global ones
+	custom := &config.RobotConfig{
+		Name:        "deploy",
+		VaultSecret: "secret/customer#deploy",
+		VaultFields: map[string]string{
+			"{{ .Key }}": "{{ .Registry }}/{{ .Organization }}:{{ .Token }}",
+		},
+	}
+
+	for _, r := range []*config.RobotConfig{robot, custom} {
+		results, err := v.UpdateRobot(ctx, r, r.Name+"-token")
+		if err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+
+		assertVaultOutcome(t, results, publisher.Created)
+	}
+
+	assertFields(t, server, map[string]string{
+		"ci-username": "myorg+ci",
+		"ci-password": "ci-token",
+		"deploy":      "quay.io/myorg:deploy-token",
+	})
+
+	results, err := v.DeleteRobot(ctx, robot)
+	if err != nil {
+		t.Fatalf("Failed to delete robot: %v", err)
+	}
+
+	assertVaultOutcome(t, results, publisher.Deleted)
+	assertFields(t, server, map[string]string{
+		"deploy": "quay.io/myorg:deploy-token",
+	})
+
+	invalid := []map[string]string{
+		// the same name is rendered twice
+		{"{{ .Robot }}": "{{ .Token }}", "ci": "{{ .Token }}"},
+		{"{{ .Missing }}": "{{ .Token }}"},
+		{"": "{{ .Token }}"},
+	}
+
+	for _, fields := range invalid {
+		cfg.Fields = fields
+
+		if _, err := v.UpdateRobot(ctx, robot, "token"); err == nil {
+			t.Errorf("Expected fields %v to be rejected.", fields)
+		}
+	}
+}
+
+func TestVaultDeleteUnconfiguredRobot(t *testing.T) {
+	ctx := context.Background()
+	state := &statefile.StateFile{Robots: map[string]statefile.RobotState{}}
+	server, _, v := newTestVault(t, 2, nil, state)
+
+	robot := &config.RobotConfig{Name: "ci", VaultSecret: "secret/customer"}
+
+	if _, err := v.UpdateRobot(ctx, robot, "token"); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	if secrets := state.Robots["myorg+ci"].VaultSecrets; len(secrets) != 1 || secrets[0].Path != "secret/customer" {
+		t.Fatalf("Expected the written secret to be recorded, but got %+v.", secrets)
+	}
+
+	// robots removed from the configuration are deleted using
+	// the recorded secrets
+	results, err := v.DeleteRobot(ctx, &config.RobotConfig{Name: "ci"})
+	if err != nil {
+		t.Fatalf("Failed to delete robot: %v", err)
+	}
+
+	assertVaultOutcome(t, results, publisher.Deleted)
+	assertFields(t, server, map[string]string{})
+
+	if _, exists := state.Robots["myorg+ci"]; exists {
+		t.Errorf("Expected the robot to be removed from the state, but got %+v.", state.Robots)
+	}
+}
+
+func TestVaultCheckAndSet(t *testing.T) {
+	testcases := []struct {
+		name      string
+		conflicts int
+		success   bool
+	}{
+		{
+			name:    "no conflict",
+			success: true,
+		},
+		{
+			name:      "single conflict",
+			conflicts: 1,
+			success:   true,
+		},
+		{
+			name:      "persistent conflict",
+			conflicts: 3,
+			success:   false,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			server, mount, v := newTestVault(t, 2, &config.VaultConfig{CheckAndSet: true}, nil)
+
+			mount.CASRequired = true
+
+			// simulate another client updating the secret right
+			// before Aquayman writes it
+			conflicts := tc.conflicts
+			server.BeforeWrite = func(mount *vaulttest.Mount, path string) {
+				if conflicts == 0 {
+					return
+				}
+
+				conflicts--
+
+				secret := mount.Secrets[path]
+				if secret == nil {
+					secret = &vaulttest.Secret{Data: map[string]interface{}{}}
+					mount.Secrets[path] = secret
+				}
+
+				secret.Data["other"] = "concurrent"
+				secret.Version++
+			}
+
+			robot := &config.RobotConfig{Name: "ci", VaultSecret: "secret/customer#ci"}
+
+			results, err := v.UpdateRobot(ctx, robot, "token")
+			if !tc.success {
+				if err == nil {
+					t.Fatal("Expected publishing to fail.")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to publish: %v", err)
+			}
+
+			assertVaultOutcome(t, results, publisher.Created)
+
+			// the concurrent change must not be overwritten
+			data := server.SecretData("secret/customer")
+			if data["ci-token"] != "token" {
+				t.Errorf("Expected token to be written, but got %v.", data)
+			}
+
+			if tc.conflicts > 0 && data["other"] != "concurrent" {
+				t.Errorf("Expected concurrent change to be kept, but got %v.", data)
+			}
+		})
+	}
+}
+
+func TestVaultCheckAndSetRequired(t *testing.T) {
+	ctx := context.Background()
+	_, mount, v := newTestVault(t, 2, nil, nil)
+
+	mount.CASRequired = true
+
+	robot := &config.RobotConfig{Name: "ci", VaultSecret: "secret/customer"}
+
+	if _, err := v.UpdateRobot(ctx, robot, "token"); err == nil {
+		t.Fatal("Expected writing without check-and-set to fail on a mount that requires it.")
+	}
+}
@@ -0,0 +1,241 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+)
+
+const (
+	// kubernetesServiceAccountToken is where Kubernetes mounts
+	// the pod's service account token.
+	kubernetesServiceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// reloginDelay is how long to wait before trying to log in
+	// again after a failed attempt.
+	reloginDelay = 10 * time.Second
+)
+
+// NewVaultClient relies on the VAULT_ADDR env variable being set.
+// Unless an auth method is configured, VAULT_TOKEN must be set, too.
+// The client is logged in once and its token is renewed in the
+// background until the context is cancelled.
+func NewVaultClient(ctx context.Context, cfg *config.VaultConfig) (*api.Client, error) {
+	if cfg == nil {
+		cfg = &config.VaultConfig{}
+	}
+
+	client, err := api.NewClient(nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build Vault client: %w", err)
+	}
+
+	if cfg.Namespace != "" {
+		client.SetNamespace(cfg.Namespace)
+	}
+
+	secret, err := login(ctx, client, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	go keepTokenAlive(ctx, client, cfg, secret)
+
+	return client, nil
+}
+
+// login authenticates against Vault using the configured auth
+// method and sets the resulting token on the client. For token
+// auth, the token from VAULT_TOKEN is looked up instead, so that
+// it can be renewed if possible.
+func login(ctx context.Context, client *api.Client, cfg *config.VaultConfig) (*api.Secret, error) {
+	method := cfg.AuthMethod()
+
+	if method == config.VaultTokenAuth {
+		if client.Token() == "" {
+			return nil, errors.New("no token given, set VAULT_TOKEN or configure an auth method")
+		}
+
+		self, err := client.Auth().Token().LookupSelfWithContext(ctx)
+		if err != nil {
+			// the token might not be allowed to look itself up,
+			// that is fine, it just cannot be renewed then
+			return nil, nil
+		}
+
+		renewable, _ := self.TokenIsRenewable()
+		ttl, _ := self.TokenTTL()
+
+		return &api.Secret{
+			Auth: &api.SecretAuth{
+				ClientToken:   client.Token(),
+				Renewable:     renewable,
+				LeaseDuration: int(ttl.Seconds()),
+			},
+		}, nil
+	}
+
+	auth := cfg.Auth
+
+	mount := auth.Mount
+	if mount == "" {
+		mount = method
+	}
+
+	data := map[string]interface{}{}
+
+	switch method {
+	case config.VaultAppRoleAuth:
+		secretID, err := readCredential(auth.SecretIDFile, "VAULT_SECRET_ID")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read AppRole secret ID: %w", err)
+		}
+
+		data["role_id"] = auth.RoleID
+		data["secret_id"] = secretID
+
+	case config.VaultKubernetesAuth:
+		jwtFile := auth.JWTFile
+		if jwtFile == "" {
+			jwtFile = kubernetesServiceAccountToken
+		}
+
+		jwt, err := readCredential(jwtFile, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %w", err)
+		}
+
+		data["role"] = auth.Role
+		data["jwt"] = jwt
+
+	case config.VaultJWTAuth:
+		jwt, err := readCredential(auth.JWTFile, "VAULT_JWT")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT: %w", err)
+		}
+
+		data["role"] = auth.Role
+		data["jwt"] = jwt
+
+	default:
+		return nil, fmt.Errorf("unknown auth method %q", method)
+	}
+
+	secret, err := client.Logical().WriteWithContext(ctx, fmt.Sprintf("auth/%s/login", strings.Trim(mount, "/")), data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to log in using %s auth: %w", method, err)
+	}
+
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("%s login did not return a token", method)
+	}
+
+	client.SetToken(secret.Auth.ClientToken)
+
+	return secret, nil
+}
+
+// readCredential reads a secret value from a file or, if no
+// filename is given, from an env variable.
+func readCredential(filename string, envName string) (string, error) {
+	if filename != "" {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	if envName != "" {
+		if value := os.Getenv(envName); value != "" {
+			return value, nil
+		}
+
+		return "", fmt.Errorf("neither a file nor $%s given", envName)
+	}
+
+	return "", errors.New("no file given")
+}
+
+// keepTokenAlive renews the token until the context is cancelled.
+// If the token cannot be renewed anymore (for example because its
+// max TTL was reached), it logs in again.
+func keepTokenAlive(ctx context.Context, client *api.Client, cfg *config.VaultConfig, secret *api.Secret) {
+	if secret == nil {
+		return
+	}
+
+	for {
+		if renewable, _ := secret.TokenIsRenewable(); renewable {
+			watcher, err := client.NewLifetimeWatcher(&api.LifetimeWatcherInput{
+				Secret: secret,
+			})
+			if err != nil {
+				log.Printf("⚠ Failed to renew Vault token: %v.", err)
+				return
+			}
+
+			go watcher.Start()
+
+			select {
+			case <-ctx.Done():
+				watcher.Stop()
+				return
+
+			case err := <-watcher.DoneCh():
+				watcher.Stop()
+
+				if err != nil {
+					log.Printf("⚠ Failed to renew Vault token: %v.", err)
+				}
+			}
+		} else {
+			// nothing to do for static tokens that cannot be renewed
+			if cfg.AuthMethod() == config.VaultTokenAuth {
+				return
+			}
+
+			// wait until shortly before the token expires
+			ttl, _ := secret.TokenTTL()
+			if ttl <= 0 {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(ttl * 9 / 10):
+			}
+		}
+
+		// static tokens cannot be replaced by logging in again
+		if cfg.AuthMethod() == config.VaultTokenAuth {
+			return
+		}
+
+		for {
+			var err error
+
+			secret, err = login(ctx, client, cfg)
+			if err == nil {
+				break
+			}
+
+			log.Printf("⚠ Failed to log into Vault again: %v.", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(reloginDelay):
+			}
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// maxCASAttempts is how often a secret is re-read and written again
+// if a concurrent writer changed it in the meantime.
+const maxCASAttempts = 3
+
+// kvMount describes a KV secrets engine mount.
+type kvMount struct {
+	// path is the mount path, including a trailing slash.
+	path    string
+	version int
+}
+
+// kvPath is a secret inside a KV mount.
+type kvPath struct {
+	mount *kvMount
+	// path is the logical path inside the mount, without the
+	// "data/" segment used by KV v2 mounts.
+	path string
+}
+
+func (p *kvPath) String() string {
+	return p.mount.path + p.path
+}
+
+// dataPath is the path to read and write the secret via the API.
+func (p *kvPath) dataPath() string {
+	if p.mount.version == 2 {
+		return p.mount.path + "data/" + p.path
+	}
+
+	return p.mount.path + p.path
+}
+
+// resolvePath finds the KV mount for the given path. Paths are given
+// as logical paths, e.g. "secret/customer-xyz". For compatibility,
+// paths on KV v2 mounts may include the "data/" segment.
+func (v *Vault) resolvePath(ctx context.Context, path string) (*kvPath, error) {
+	path = strings.Trim(path, "/")
+
+	mount, err := v.findMount(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	logical := strings.TrimPrefix(path+"/", mount.path)
+	logical = strings.TrimSuffix(logical, "/")
+
+	if mount.version == 2 {
+		logical = strings.TrimPrefix(logical, "data/")
+	}
+
+	if logical == "" {
+		return nil, fmt.Errorf("path %q does not point to a secret inside the mount %q", path, mount.path)
+	}
+
+	return &kvPath{
+		mount: mount,
+		path:  logical,
+	}, nil
+}
+
+func (v *Vault) findMount(ctx context.Context, path string) (*kvMount, error) {
+	v.mountsLock.Lock()
+	defer v.mountsLock.Unlock()
+
+	if !v.mountsListed {
+		mounts, err := v.listMounts(ctx)
+		if err != nil {
+			v.listMountsErr = err
+		} else {
+			v.mounts = mounts
+		}
+
+		v.mountsListed = true
+	}
+
+	var found *kvMount
+	for _, mount := range v.mounts {
+		if strings.HasPrefix(path+"/", mount.path) && (found == nil || len(mount.path) > len(found.path)) {
+			found = mount
+		}
+	}
+
+	if found != nil {
+		return found, nil
+	}
+
+	if v.listMountsErr == nil {
+		return nil, fmt.Errorf("path %q is not inside a KV secrets engine", path)
+	}
+
+	// tokens are often not allowed to list all mounts, but
+	// can still look up the mount of a path they can access
+	mount, err := v.lookupMount(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find mount: %w", errors.Join(v.listMountsErr, err))
+	}
+
+	v.mounts = append(v.mounts, mount)
+
+	return mount, nil
+}
+
+func (v *Vault) listMounts(ctx context.Context) ([]*kvMount, error) {
+	mounts, err := v.client.Sys().ListMountsWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*kvMount{}
+	for path, mount := range mounts {
+		if kv := toKVMount(path, mount.Type, mount.Options); kv != nil {
+			result = append(result, kv)
+		}
+	}
+
+	return result, nil
+}
+
+// lookupMount uses the same endpoint as the Vault CLI to find out
+// the mount of a single path.
+func (v *Vault) lookupMount(ctx context.Context, path string) (*kvMount, error) {
+	secret, err := v.client.Logical().ReadWithContext(ctx, "sys/internal/ui/mounts/"+path)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("no mount found for %q", path)
+	}
+
+	mountPath, _ := secret.Data["path"].(string)
+	mountType, _ := secret.Data["type"].(string)
+
+	options := map[string]string{}
+	if opts, ok := secret.Data["options"].(map[string]interface{}); ok {
+		for k, v := range opts {
+			options[k] = fmt.Sprintf("%v", v)
+		}
+	}
+
+	kv := toKVMount(mountPath, mountType, options)
+	if kv == nil {
+		return nil, fmt.Errorf("path %q is not inside a KV secrets engine", path)
+	}
+
+	return kv, nil
+}
+
+func toKVMount(path string, mountType string, options map[string]string) *kvMount {
+	// "generic" is the old name of the KV v1 engine
+	if mountType != "kv" && mountType != "generic" {
+		return nil
+	}
+
+	version := 1
+	if options["version"] == "2" {
+		version = 2
+	}
+
+	return &kvMount{
+		path:    strings.TrimPrefix(strings.TrimSuffix(path, "/")+"/", "/"),
+		version: version,
+	}
+}
+
+// readSecret returns the data of a secret and, for KV v2, its
+// current version (0 if the secret does not exist).
+func (v *Vault) readSecret(ctx context.Context, path *kvPath) (map[string]interface{}, int, error) {
+	secret, err := v.client.Logical().ReadWithContext(ctx, path.dataPath())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if secret == nil || secret.Data == nil {
+		return nil, 0, nil
+	}
+
+	if path.mount.version == 1 {
+		return secret.Data, 0, nil
+	}
+
+	// the secrets are wrapped in a "data" field,
+	// that's just how kv v2 stores in Vault work
+	version := 0
+	if metadata, ok := secret.Data["metadata"].(map[string]interface{}); ok {
+		if n, ok := metadata["version"].(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				version = int(i)
+			}
+		}
+	}
+
+	// deleted secrets have no data, but still a version
+	data, _ := secret.Data["data"].(map[string]interface{})
+
+	return data, version, nil
+}
+
+func (v *Vault) writeSecret(ctx context.Context, path *kvPath, data map[string]interface{}, version int) error {
+	payload := data
+
+	if path.mount.version == 2 {
+		payload = map[string]interface{}{
+			"data": data,
+		}
+
+		if v.config.CheckAndSet {
+			payload["options"] = map[string]interface{}{
+				"cas": version,
+			}
+		}
+	}
+
+	_, err := v.client.Logical().WriteWithContext(ctx, path.dataPath(), payload)
+
+	return err
+}
+
+// updateSecret reads a secret, lets mutate change its data and
+// writes it back unless mutate reports that nothing changed. With
+// check-and-set enabled, concurrent changes are detected and the
+// update is retried on the fresh data.
+func (v *Vault) updateSecret(ctx context.Context, path *kvPath, mutate func(data map[string]interface{}) Outcome) (Outcome, error) {
+	for attempt := 1; ; attempt++ {
+		data, version, err := v.readSecret(ctx, path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read from Vault: %w", err)
+		}
+
+		if data == nil {
+			data = map[string]interface{}{}
+		}
+
+		outcome := mutate(data)
+		if outcome == Unchanged {
+			return outcome, nil
+		}
+
+		err = v.writeSecret(ctx, path, data, version)
+		if err == nil {
+			return outcome, nil
+		}
+
+		if !isCASMismatch(err) || attempt >= maxCASAttempts {
+			return "", fmt.Errorf("failed to update Vault: %w", err)
+		}
+	}
+}
+
+func isCASMismatch(err error) bool {
+	var respErr *api.ResponseError
+	if !errors.As(err, &respErr) {
+		return false
+	}
+
+	for _, e := range respErr.Errors {
+		if strings.Contains(e, "check-and-set") {
+			return true
+		}
+	}
+
+	return false
+}
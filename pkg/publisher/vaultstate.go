@@ -0,0 +1,254 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/statefile"
+	"github.com/kubermatic-labs/aquayman/pkg/util"
+)
+
+// StaleVaultSecret is a Vault secret that contains fields written
+// for a robot, which are not part of the configuration anymore,
+// for example because the robot was removed or its vaultSecret
+// was changed.
+type StaleVaultSecret struct {
+	// Robot is the full robot name ("[org]+[name]").
+	Robot  string
+	Path   string
+	Fields []string
+}
+
+func (v *Vault) fullName(robotName string) string {
+	return fmt.Sprintf("%s+%s", v.org, robotName)
+}
+
+// recordFields remembers that the fields have been written
+// into the secret for the given robot.
+func (v *Vault) recordFields(robotName string, path string, fields []string) {
+	if v.state == nil {
+		return
+	}
+
+	v.stateLock.Lock()
+	defer v.stateLock.Unlock()
+
+	name := v.fullName(robotName)
+	robotState := v.state.Robots[name]
+
+	found := false
+	for i, secret := range robotState.VaultSecrets {
+		if secret.Path == path {
+			for _, field := range fields {
+				if !util.StringSliceContains(secret.Fields, field) {
+					secret.Fields = append(secret.Fields, field)
+				}
+			}
+
+			sort.Strings(secret.Fields)
+			robotState.VaultSecrets[i] = secret
+			found = true
+		}
+	}
+
+	if !found {
+		sorted := append([]string{}, fields...)
+		sort.Strings(sorted)
+
+		robotState.VaultSecrets = append(robotState.VaultSecrets, statefile.VaultSecretState{
+			Path:   path,
+			Fields: sorted,
+		})
+	}
+
+	v.state.Robots[name] = robotState
+}
+
+// forgetFields removes the fields from the robot's recorded secrets.
+func (v *Vault) forgetFields(robot string, path string, fields []string) {
+	if v.state == nil {
+		return
+	}
+
+	v.stateLock.Lock()
+	defer v.stateLock.Unlock()
+
+	robotState, exists := v.state.Robots[robot]
+	if !exists {
+		return
+	}
+
+	secrets := []statefile.VaultSecretState{}
+	for _, secret := range robotState.VaultSecrets {
+		if secret.Path == path {
+			remaining := []string{}
+			for _, field := range secret.Fields {
+				if !util.StringSliceContains(fields, field) {
+					remaining = append(remaining, field)
+				}
+			}
+
+			if len(remaining) == 0 {
+				continue
+			}
+
+			secret.Fields = remaining
+		}
+
+		secrets = append(secrets, secret)
+	}
+
+	robotState.VaultSecrets = secrets
+
+	if robotState.IsZero() {
+		delete(v.state.Robots, robot)
+	} else {
+		v.state.Robots[robot] = robotState
+	}
+}
+
+// recordedSecrets returns a copy of the secrets recorded for a robot.
+func (v *Vault) recordedSecrets(robot string) []statefile.VaultSecretState {
+	if v.state == nil {
+		return nil
+	}
+
+	v.stateLock.Lock()
+	defer v.stateLock.Unlock()
+
+	result := []statefile.VaultSecretState{}
+	for _, secret := range v.state.Robots[robot].VaultSecrets {
+		result = append(result, statefile.VaultSecretState{
+			Path:   secret.Path,
+			Fields: append([]string{}, secret.Fields...),
+		})
+	}
+
+	return result
+}
+
+// deleteFields removes fields from a secret and forgets about them.
+func (v *Vault) deleteFields(ctx context.Context, robot string, path string, fields []string) (Outcome, error) {
+	kvPath, err := v.resolvePath(ctx, path)
+	if err != nil {
+		return "", err
+	}
+
+	outcome, err := v.updateSecret(ctx, kvPath, func(data map[string]interface{}) Outcome {
+		outcome := Unchanged
+
+		for _, name := range fields {
+			if _, exists := data[name]; exists {
+				delete(data, name)
+				outcome = Deleted
+			}
+		}
+
+		return outcome
+	})
+	if err != nil {
+		return "", err
+	}
+
+	v.forgetFields(robot, path, fields)
+
+	return outcome, nil
+}
+
+// StaleSecrets compares the recorded secrets with the given robot
+// configurations and returns all secret fields that are not
+// configured anymore. Only robots of the publisher's organization are
+// considered. This requires a state file.
+func (v *Vault) StaleSecrets(ctx context.Context, robots []config.RobotConfig) ([]StaleVaultSecret, error) {
+	if v.state == nil {
+		return nil, errors.New("no state file given")
+	}
+
+	// determine which fields are currently configured
+	desired := map[string]map[string][]string{}
+
+	for i, robot := range robots {
+		if robot.Deleted || robot.VaultSecret == "" {
+			continue
+		}
+
+		if len(robot.Publishers) > 0 && !util.StringSliceContains(robot.Publishers, config.VaultPublisher) {
+			continue
+		}
+
+		addr, err := v.getAddress(ctx, &robots[i])
+		if err != nil {
+			return nil, fmt.Errorf("robot %s: %w", robot.Name, err)
+		}
+
+		fields, err := v.renderFields(&robots[i], addr, "", "")
+		if err != nil {
+			return nil, fmt.Errorf("robot %s: %w", robot.Name, err)
+		}
+
+		name := v.fullName(robot.Name)
+		if desired[name] == nil {
+			desired[name] = map[string][]string{}
+		}
+
+		for field := range fields {
+			desired[name][addr.path.String()] = append(desired[name][addr.path.String()], field)
+		}
+	}
+
+	v.stateLock.Lock()
+	defer v.stateLock.Unlock()
+
+	stale := []StaleVaultSecret{}
+	for robot, robotState := range v.state.Robots {
+		// the state file can be shared by multiple organizations
+		if !strings.HasPrefix(robot, v.org+"+") {
+			continue
+		}
+
+		for _, secret := range robotState.VaultSecrets {
+			fields := []string{}
+
+			for _, field := range secret.Fields {
+				if !util.StringSliceContains(desired[robot][secret.Path], field) {
+					fields = append(fields, field)
+				}
+			}
+
+			if len(fields) > 0 {
+				stale = append(stale, StaleVaultSecret{
+					Robot:  robot,
+					Path:   secret.Path,
+					Fields: fields,
+				})
+			}
+		}
+	}
+
+	sort.Slice(stale, func(i, j int) bool {
+		if stale[i].Robot != stale[j].Robot {
+			return stale[i].Robot < stale[j].Robot
+		}
+
+		return stale[i].Path < stale[j].Path
+	})
+
+	return stale, nil
+}
+
+// PruneSecrets removes the given stale fields from Vault.
+func (v *Vault) PruneSecrets(ctx context.Context, stale []StaleVaultSecret) error {
+	var errs []error
+
+	for _, secret := range stale {
+		if _, err := v.deleteFields(ctx, secret.Robot, secret.Path, secret.Fields); err != nil {
+			errs = append(errs, fmt.Errorf("failed to prune %s for robot %s: %w", secret.Path, secret.Robot, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,140 @@
+package publisher_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/publisher"
+	"github.com/kubermatic-labs/aquayman/pkg/publisher/vaulttest"
+	"github.com/kubermatic-labs/aquayman/pkg/statefile"
+)
+
+func TestVaultStaleSecrets(t *testing.T) {
+	ctx := context.Background()
+
+	state := &statefile.StateFile{
+		Robots: map[string]statefile.RobotState{
+			// the state file can be shared with other organizations
+			"otherorg+ci": {
+				VaultSecrets: []statefile.VaultSecretState{{Path: "secret/customer", Fields: []string{"otherorg-token"}}},
+			},
+		},
+	}
+
+	server, mount, v := newTestVault(t, 2, nil, state)
+
+	// fields that Aquayman never wrote must be left alone, even
+	// if they look like they belong to a robot
+	mount.Secrets["customer"] = &vaulttest.Secret{
+		Data: map[string]interface{}{
+			"unrelated":               "keep",
+			"otherorg-token":          "keep",
+			"quay.io-myorg-old-token": "keep",
+		},
+		Version: 1,
+	}
+
+	robots := []config.RobotConfig{
+		{Name: "ci", VaultSecret: "secret/customer"},
+		{Name: "deploy", VaultSecret: "secret/customer#deploy"},
+	}
+
+	for i := range robots {
+		if _, err := v.UpdateRobot(ctx, &robots[i], "token"); err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+	}
+
+	expectedState := []statefile.VaultSecretState{{Path: "secret/customer", Fields: []string{"quay.io-myorg-ci-config", "quay.io-myorg-ci-token"}}}
+	if secrets := state.Robots["myorg+ci"].VaultSecrets; !reflect.DeepEqual(secrets, expectedState) {
+		t.Fatalf("Expected written fields %+v to be recorded, but got %+v.", expectedState, secrets)
+	}
+
+	stale, err := v.StaleSecrets(ctx, robots)
+	if err != nil {
+		t.Fatalf("Failed to determine stale secrets: %v", err)
+	}
+
+	if len(stale) != 0 {
+		t.Fatalf("Expected no stale secrets, but got %+v.", stale)
+	}
+
+	// move one robot's credentials and remove the other robot
+	robots = []config.RobotConfig{
+		{Name: "ci", VaultSecret: "secret/moved"},
+	}
+
+	if _, err := v.UpdateRobot(ctx, &robots[0], "token"); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	stale, err = v.StaleSecrets(ctx, robots)
+	if err != nil {
+		t.Fatalf("Failed to determine stale secrets: %v", err)
+	}
+
+	expected := []publisher.StaleVaultSecret{
+		{Robot: "myorg+ci", Path: "secret/customer", Fields: []string{"quay.io-myorg-ci-config", "quay.io-myorg-ci-token"}},
+		{Robot: "myorg+deploy", Path: "secret/customer", Fields: []string{"deploy-config", "deploy-token"}},
+	}
+
+	if !reflect.DeepEqual(stale, expected) {
+		t.Fatalf("Expected stale secrets %+v, but got %+v.", expected, stale)
+	}
+
+	// determining the stale secrets is the dry-run preview and
+	// must not change anything
+	if data := server.SecretData("secret/customer"); len(data) != 7 {
+		t.Fatalf("Expected the secret to be unchanged, but got %v.", data)
+	}
+
+	if len(state.Robots["myorg+deploy"].VaultSecrets) != 1 {
+		t.Fatalf("Expected the state to be unchanged, but got %+v.", state.Robots)
+	}
+
+	if err := v.PruneSecrets(ctx, stale); err != nil {
+		t.Fatalf("Failed to prune secrets: %v", err)
+	}
+
+	assertFields(t, server, map[string]string{
+		"unrelated":               "keep",
+		"otherorg-token":          "keep",
+		"quay.io-myorg-old-token": "keep",
+	})
+
+	if data := server.SecretData("secret/moved"); data["quay.io-myorg-ci-token"] != "token" {
+		t.Errorf("Expected the moved secret to be kept, but got %v.", data)
+	}
+
+	expectedState = []statefile.VaultSecretState{{Path: "secret/moved", Fields: []string{"quay.io-myorg-ci-config", "quay.io-myorg-ci-token"}}}
+	if secrets := state.Robots["myorg+ci"].VaultSecrets; !reflect.DeepEqual(secrets, expectedState) {
+		t.Errorf("Expected only the moved secret to remain recorded, but got %+v.", secrets)
+	}
+
+	if _, exists := state.Robots["myorg+deploy"]; exists {
+		t.Errorf("Expected the removed robot to be forgotten, but got %+v.", state.Robots)
+	}
+
+	if _, exists := state.Robots["otherorg+ci"]; !exists {
+		t.Errorf("Expected robots of other organizations to be kept, but got %+v.", state.Robots)
+	}
+
+	stale, err = v.StaleSecrets(ctx, robots)
+	if err != nil {
+		t.Fatalf("Failed to determine stale secrets: %v", err)
+	}
+
+	if len(stale) != 0 {
+		t.Errorf("Expected no stale secrets after pruning, but got %+v.", stale)
+	}
+}
+
+func TestVaultStaleSecretsRequiresState(t *testing.T) {
+	_, _, v := newTestVault(t, 2, nil, nil)
+
+	if _, err := v.StaleSecrets(context.Background(), nil); err == nil {
+		t.Fatal("Expected determining stale secrets without a state file to fail.")
+	}
+}
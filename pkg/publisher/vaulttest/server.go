@@ -0,0 +1,432 @@
+// Package vaulttest provides an in-memory implementation of the parts
+// of the Vault API that Aquayman uses, i.e. KV v1 and v2 mounts and a
+// few auth endpoints. It is meant for end-to-end tests that should
+// run without access to a real Vault installation.
+package vaulttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/publisher"
+	"github.com/kubermatic-labs/aquayman/pkg/statefile"
+)
+
+// DefaultToken is the root token accepted by a new server.
+const DefaultToken = "vaulttest"
+
+// tokenTTL is the lease duration (in seconds) of tokens issued by
+// logging in.
+const tokenTTL = 3600
+
+// Server is a fake Vault server. All exported fields can be modified
+// before or between requests, but callers must hold the lock (see Lock
+// and Unlock) when doing so while requests are being served.
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	tokenCounter int
+	tokens       map[string]struct{}
+
+	// Token is the static root token.
+	Token string
+
+	// DenyListMounts makes listing sys/mounts fail, like it does for
+	// tokens with restricted policies.
+	DenyListMounts bool
+
+	// Mounts maps mount paths (e.g. "secret/") to KV mounts.
+	Mounts map[string]*Mount
+
+	// AppRoles maps AppRole role IDs to their secret IDs.
+	AppRoles map[string]string
+
+	// Writes counts all successful writes to KV mounts.
+	Writes int
+
+	// BeforeWrite, if set, is called with the lock held before a
+	// secret is written. It can modify the secret to simulate a
+	// concurrent writer.
+	BeforeWrite func(mount *Mount, path string)
+}
+
+type Mount struct {
+	// Version is the KV version, 1 or 2.
+	Version int
+
+	// CASRequired rejects writes without check-and-set (KV v2 only).
+	CASRequired bool
+
+	// Secrets maps logical paths inside the mount to secrets.
+	Secrets map[string]*Secret
+}
+
+type Secret struct {
+	Data map[string]interface{}
+
+	// Version is incremented with every write (KV v2 only).
+	Version int
+}
+
+// NewServer starts a new fake server without any mounts. Callers
+// must call Close when they are done.
+func NewServer() *Server {
+	s := &Server{
+		Token:    DefaultToken,
+		tokens:   map[string]struct{}{},
+		Mounts:   map[string]*Mount{},
+		AppRoles: map[string]string{},
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+// NewClient returns a Vault client that is configured to talk to
+// this server, using the root token.
+func (s *Server) NewClient() (*api.Client, error) {
+	cfg := api.DefaultConfig()
+	cfg.Address = s.URL
+
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client.SetToken(s.Token)
+
+	return client, nil
+}
+
+// NewPublisher returns a Vault publisher that writes into this server.
+func (s *Server) NewPublisher(organization string, registry string, cfg *config.VaultConfig, state *statefile.StateFile) (*publisher.Vault, error) {
+	client, err := s.NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return publisher.NewVaultPublisher(client, organization, registry, cfg, state), nil
+}
+
+func (s *Server) Lock() {
+	s.mu.Lock()
+}
+
+func (s *Server) Unlock() {
+	s.mu.Unlock()
+}
+
+// AddKVMount creates a new, empty KV mount and returns it.
+func (s *Server) AddKVMount(path string, version int) *Mount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mount := &Mount{
+		Version: version,
+		Secrets: map[string]*Secret{},
+	}
+
+	s.Mounts[strings.Trim(path, "/")+"/"] = mount
+
+	return mount
+}
+
+// SecretData returns a copy of the data of the secret at the
+// logical path (e.g. "secret/customer-xyz"), or nil if it does
+// not exist.
+func (s *Server) SecretData(path string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mountPath, mount := s.findMount(path)
+	if mount == nil {
+		return nil
+	}
+
+	secret, exists := mount.Secrets[strings.TrimPrefix(path, mountPath)]
+	if !exists {
+		return nil
+	}
+
+	data := map[string]interface{}{}
+	for k, v := range secret.Data {
+		data[k] = v
+	}
+
+	return data
+}
+
+func writeErrors(w http.ResponseWriter, status int, messages ...string) {
+	writeJSON(w, status, map[string]interface{}{
+		"errors": append([]string{}, messages...),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func readBody(r *http.Request) (map[string]interface{}, error) {
+	body := map[string]interface{}{}
+	if r.ContentLength == 0 {
+		return body, nil
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func isWrite(r *http.Request) bool {
+	return r.Method == http.MethodPut || r.Method == http.MethodPost
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/v1/")
+	if !ok {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+
+	// logging in is the only thing that works without a token
+	if isWrite(r) && path == "auth/approle/login" {
+		s.loginAppRole(w, r)
+		return
+	}
+
+	token := r.Header.Get("X-Vault-Token")
+	if _, issued := s.tokens[token]; token != s.Token && !issued {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	switch {
+	case path == "sys/mounts" && r.Method == http.MethodGet:
+		s.listMounts(w)
+	case strings.HasPrefix(path, "sys/internal/ui/mounts/") && r.Method == http.MethodGet:
+		s.lookupMount(w, strings.TrimPrefix(path, "sys/internal/ui/mounts/"))
+	case path == "auth/token/lookup-self" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{
+				"ttl":       0,
+				"renewable": false,
+			},
+		})
+	case path == "auth/token/renew-self" && isWrite(r):
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{
+				"client_token":   token,
+				"renewable":      true,
+				"lease_duration": tokenTTL,
+			},
+		})
+	default:
+		s.handleKV(w, r, path)
+	}
+}
+
+func mountResponse(path string, mount *Mount) map[string]interface{} {
+	return map[string]interface{}{
+		"path": path,
+		"type": "kv",
+		"options": map[string]string{
+			"version": fmt.Sprintf("%d", mount.Version),
+		},
+	}
+}
+
+func (s *Server) listMounts(w http.ResponseWriter) {
+	if s.DenyListMounts {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	data := map[string]interface{}{
+		"sys/": map[string]interface{}{
+			"type": "system",
+		},
+	}
+
+	for path, mount := range s.Mounts {
+		data[path] = mountResponse(path, mount)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (s *Server) lookupMount(w http.ResponseWriter, path string) {
+	mountPath, mount := s.findMount(path)
+	if mount == nil {
+		writeErrors(w, http.StatusBadRequest, "path is not a mount")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": mountResponse(mountPath, mount)})
+}
+
+func (s *Server) findMount(path string) (string, *Mount) {
+	found := ""
+	for mountPath := range s.Mounts {
+		if strings.HasPrefix(path+"/", mountPath) && len(mountPath) > len(found) {
+			found = mountPath
+		}
+	}
+
+	if found == "" {
+		return "", nil
+	}
+
+	return found, s.Mounts[found]
+}
+
+func (s *Server) loginAppRole(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		writeErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	roleID, _ := body["role_id"].(string)
+	secretID, _ := body["secret_id"].(string)
+
+	expected, exists := s.AppRoles[roleID]
+	if !exists || expected != secretID {
+		writeErrors(w, http.StatusBadRequest, "invalid role or secret ID")
+		return
+	}
+
+	s.tokenCounter++
+	token := fmt.Sprintf("s.vaulttest%d", s.tokenCounter)
+	s.tokens[token] = struct{}{}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"auth": map[string]interface{}{
+			"client_token":   token,
+			"renewable":      true,
+			"lease_duration": tokenTTL,
+		},
+	})
+}
+
+func (s *Server) handleKV(w http.ResponseWriter, r *http.Request, path string) {
+	mountPath, mount := s.findMount(path)
+	if mount == nil {
+		writeErrors(w, http.StatusNotFound, fmt.Sprintf("no handler for route %q", path))
+		return
+	}
+
+	secretPath := strings.TrimPrefix(path, mountPath)
+
+	if mount.Version == 2 {
+		var ok bool
+
+		secretPath, ok = strings.CutPrefix(secretPath, "data/")
+		if !ok {
+			writeErrors(w, http.StatusNotFound, "only the data/ endpoints are supported")
+			return
+		}
+	}
+
+	if isWrite(r) && s.BeforeWrite != nil {
+		s.BeforeWrite(mount, secretPath)
+	}
+
+	secret := mount.Secrets[secretPath]
+
+	switch {
+	case r.Method == http.MethodGet:
+		if secret == nil {
+			writeErrors(w, http.StatusNotFound)
+			return
+		}
+
+		data := secret.Data
+		if mount.Version == 2 {
+			data = map[string]interface{}{
+				"data": secret.Data,
+				"metadata": map[string]interface{}{
+					"version": secret.Version,
+				},
+			}
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+
+	case isWrite(r):
+		body, err := readBody(r)
+		if err != nil {
+			writeErrors(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if mount.Version == 1 {
+			mount.Secrets[secretPath] = &Secret{Data: body}
+			s.Writes++
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		data, ok := body["data"].(map[string]interface{})
+		if !ok {
+			writeErrors(w, http.StatusBadRequest, "no data provided")
+			return
+		}
+
+		current := 0
+		if secret != nil {
+			current = secret.Version
+		}
+
+		options, _ := body["options"].(map[string]interface{})
+		cas, hasCAS := options["cas"].(json.Number)
+
+		if !hasCAS && mount.CASRequired {
+			writeErrors(w, http.StatusBadRequest, "check-and-set parameter required for this call")
+			return
+		}
+
+		if hasCAS && cas.String() != fmt.Sprintf("%d", current) {
+			writeErrors(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+			return
+		}
+
+		mount.Secrets[secretPath] = &Secret{
+			Data:    data,
+			Version: current + 1,
+		}
+		s.Writes++
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{
+				"version": current + 1,
+			},
+		})
+
+	case r.Method == http.MethodDelete:
+		delete(mount.Secrets, secretPath)
+		s.Writes++
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeErrors(w, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,48 @@
+package quay
+
+import (
+	"context"
+)
+
+// API describes all operations that Aquayman performs against a Quay
+// installation. Client implements this interface, but it can also be
+// implemented by wrappers (e.g. for caching or recording requests) or
+// entirely different backends.
+type API interface {
+	GetUser(ctx context.Context, username string) (*User, error)
+
+	GetOrganization(ctx context.Context, name string) (*Organization, error)
+	GetOrganizationMembers(ctx context.Context, organization string) ([]OrganizationMember, error)
+
+	GetOrganizationRobots(ctx context.Context, org string, options GetOrganizationRobotsOptions) ([]Robot, error)
+	GetOrganizationRobot(ctx context.Context, org string, shortName string) (*Robot, error)
+	CreateOrganizationRobot(ctx context.Context, org string, shortName string, opt CreateOrganizationRobotOptions) error
+	DeleteOrganizationRobot(ctx context.Context, org string, shortName string) error
+	RegenerateOrganizationRobotToken(ctx context.Context, org string, shortName string) (*Robot, error)
+
+	GetOrganizationPrototypes(ctx context.Context, org string) ([]Prototype, error)
+	CreateOrganizationPrototype(ctx context.Context, org string, opt CreateOrganizationPrototypeOptions) error
+	UpdateOrganizationPrototype(ctx context.Context, org string, id string, role RepositoryRole) error
+	DeleteOrganizationPrototype(ctx context.Context, org string, id string) error
+
+	GetTeamMembers(ctx context.Context, org string, team string, opt GetTeamMembersOptions) ([]TeamMember, error)
+	AddUserToTeam(ctx context.Context, org string, team string, member string) error
+	RemoveUserFromTeam(ctx context.Context, org string, team string, member string) error
+	UpsertTeam(ctx context.Context, org string, team string, opt UpsertTeamOptions) error
+	DeleteTeam(ctx context.Context, org string, team string) error
+
+	GetRepositories(ctx context.Context, options GetRepositoriesOptions) ([]Repository, error)
+	CreateRepository(ctx context.Context, opt CreateRepositoryOptions) error
+	UpdateRepository(ctx context.Context, repo string, opt UpdateRepositoryOptions) error
+	ChangeRepositoryVisibility(ctx context.Context, repo string, visibility RepositoryVisibility) error
+	DeleteRepository(ctx context.Context, repo string) error
+
+	GetRepositoryUserPermissions(ctx context.Context, repo string) (map[string]Permission, error)
+	GetRepositoryTeamPermissions(ctx context.Context, repo string) (map[string]Permission, error)
+	SetUserRepositoryPermissions(ctx context.Context, repo string, user string, role RepositoryRole) error
+	SetTeamRepositoryPermissions(ctx context.Context, repo string, team string, role RepositoryRole) error
+	RemoveUserFromRepository(ctx context.Context, repo string, user string) error
+	RemoveTeamFromRepository(ctx context.Context, repo string, team string) error
+}
+
+var _ API = &Client{}
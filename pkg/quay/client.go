@@ -3,13 +3,27 @@ package quay
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"strings"
 	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultRegistry is the hostname of the public Quay registry.
+	DefaultRegistry = "quay.io"
+	// DefaultAPIURL is the API endpoint of the public Quay registry.
+	DefaultAPIURL = "https://quay.io/api/v1"
 )
 
 type RepositoryRole string
@@ -39,16 +53,55 @@ const (
 var AllTeamRoles = []TeamRole{MemberTeamRole, CreatorTeamRole, AdminTeamRole}
 
 type Client struct {
-	Token  string
-	Client *http.Client
-	Dry    bool
+	Token   string
+	Client  *http.Client
+	Dry     bool
+	BaseURL string
+
+	// Retries controls how failed requests are retried.
+	Retries RetryPolicy
+
+	// Limiter, if set, limits the rate of requests sent to the API.
+	Limiter *rate.Limiter
+
+	stats clientStats
+}
+
+// Endpoint describes how to reach a Quay installation. The zero value
+// points to the public quay.io registry.
+type Endpoint struct {
+	// APIURL is the base URL of the API, e.g. "https://quay.example.com/api/v1".
+	APIURL string
+
+	// CABundle is the path to a PEM file with additional CA certificates
+	// that are trusted when connecting to the API.
+	CABundle string
+
+	// ClientCertificate and ClientKey are paths to PEM files, used to
+	// authenticate via TLS client certificates. Both must be set.
+	ClientCertificate string
+	ClientKey         string
 }
 
-func NewClient(token string, timeout time.Duration, dryMode bool) (*Client, error) {
+func NewClient(token string, timeout time.Duration, dryMode bool, endpoint Endpoint) (*Client, error) {
 	if token == "" {
 		return nil, fmt.Errorf("no OAuth2 token provided")
 	}
 
+	baseURL := strings.TrimSuffix(endpoint.APIURL, "/")
+	if baseURL == "" {
+		baseURL = DefaultAPIURL
+	}
+
+	if _, err := url.Parse(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid API URL %q: %w", baseURL, err)
+	}
+
+	tlsConfig, err := endpoint.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	httpClient := &http.Client{
 		Timeout: timeout,
 		Transport: &http.Transport{
@@ -56,16 +109,60 @@ func NewClient(token string, timeout time.Duration, dryMode bool) (*Client, erro
 				Timeout: timeout,
 			}).Dial,
 			TLSHandshakeTimeout: timeout,
+			TLSClientConfig:     tlsConfig,
 		},
 	}
 
 	return &Client{
-		Token:  token,
-		Client: httpClient,
-		Dry:    dryMode,
+		Token:   token,
+		Client:  httpClient,
+		Dry:     dryMode,
+		BaseURL: baseURL,
+		Retries: DefaultRetryPolicy(),
 	}, nil
 }
 
+func (e *Endpoint) tlsConfig() (*tls.Config, error) {
+	if e.CABundle == "" && e.ClientCertificate == "" && e.ClientKey == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{}
+
+	if e.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := os.ReadFile(e.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %q does not contain any valid certificates", e.CABundle)
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	if e.ClientCertificate != "" || e.ClientKey != "" {
+		if e.ClientCertificate == "" || e.ClientKey == "" {
+			return nil, fmt.Errorf("both client certificate and key must be configured")
+		}
+
+		cert, err := tls.LoadX509KeyPair(e.ClientCertificate, e.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
 type options interface {
 	Apply(url.Values) url.Values
 }
@@ -112,7 +209,70 @@ func (c *Client) call(ctx context.Context, method string, path string, opt optio
 		return nil
 	}
 
-	u := "https://quay.io/api/v1" + path
+	u := c.BaseURL + path
+
+	// keep the body around so it can be sent again when retrying
+	var payload []byte
+	if body != nil {
+		var err error
+
+		payload, err = io.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("failed to read request body: %v", err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if c.Limiter != nil {
+			if err := c.Limiter.Wait(ctx); err != nil {
+				return fmt.Errorf("rate limit: %v", err)
+			}
+		}
+
+		err := c.do(ctx, method, u, payload, model)
+		if err == nil {
+			return nil
+		}
+
+		// a failed attempt might still have been processed by the server,
+		// in which case the retried deletion finds nothing to delete
+		if attempt > 0 && method == http.MethodDelete && isNotFound(err) {
+			return nil
+		}
+
+		var rerr *retryableError
+		if !errors.As(err, &rerr) {
+			return err
+		}
+
+		if attempt >= c.Retries.MaxRetries || !rerr.retryable(method) {
+			return rerr.err
+		}
+
+		c.stats.retries.Add(1)
+		if rerr.status == http.StatusTooManyRequests {
+			c.stats.rateLimited.Add(1)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.Retries.delay(attempt, rerr.retryAfter)):
+		}
+	}
+}
+
+func isNotFound(err error) bool {
+	var apiErr *APIError
+
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+func (c *Client) do(ctx context.Context, method string, u string, payload []byte, model interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
 
 	request, err := http.NewRequestWithContext(ctx, method, u, body)
 	if err != nil {
@@ -124,19 +284,40 @@ func (c *Client) call(ctx context.Context, method string, path string, opt optio
 		request.Header.Set("Content-Type", "application/json; charset=utf-8")
 	}
 
+	c.stats.requests.Add(1)
+
 	response, err := c.Client.Do(request)
 	if err != nil {
-		return fmt.Errorf("request failed: %v", err)
+		return &retryableError{
+			err: fmt.Errorf("request failed: %v", err),
+		}
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode >= 400 {
+		var apiErr error
+
 		e := &APIError{}
 		if err := json.NewDecoder(response.Body).Decode(&e); err != nil {
-			return fmt.Errorf("request failed and decoding the response also failed, HTTP status was %s: %v", response.Status, err)
+			apiErr = fmt.Errorf("request failed and decoding the response also failed, HTTP status was %s: %v", response.Status, err)
+		} else {
+			// not all errors carry their status in the body
+			if e.Status == 0 {
+				e.Status = response.StatusCode
+			}
+
+			apiErr = e
+		}
+
+		if retryableStatus(response.StatusCode) {
+			return &retryableError{
+				err:        apiErr,
+				status:     response.StatusCode,
+				retryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+			}
 		}
 
-		return e
+		return apiErr
 	}
 
 	if response.StatusCode >= 300 {
@@ -0,0 +1,74 @@
+package quay
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+type PrototypeDelegateKind string
+
+const (
+	PrototypeDelegateUser PrototypeDelegateKind = "user"
+	PrototypeDelegateTeam PrototypeDelegateKind = "team"
+)
+
+// Prototype is a "default permission" in the Quay UI. It grants a user,
+// robot or team access to every newly created repository in an
+// organization, optionally only if the repository is created by the
+// activating user.
+type Prototype struct {
+	ID             string             `json:"id"`
+	Role           RepositoryRole     `json:"role"`
+	ActivatingUser *PrototypeIdentity `json:"activating_user,omitempty"`
+	Delegate       PrototypeIdentity  `json:"delegate"`
+}
+
+type PrototypeIdentity struct {
+	Name    string                `json:"name"`
+	Kind    PrototypeDelegateKind `json:"kind,omitempty"`
+	IsRobot bool                  `json:"is_robot,omitempty"`
+}
+
+type getOrganizationPrototypesResponse struct {
+	Prototypes []Prototype `json:"prototypes"`
+}
+
+func (c *Client) GetOrganizationPrototypes(ctx context.Context, org string) ([]Prototype, error) {
+	response := getOrganizationPrototypesResponse{}
+	path := fmt.Sprintf("/organization/%s/prototypes", url.PathEscape(org))
+	err := c.call(ctx, "GET", path, nil, nil, &response)
+
+	return response.Prototypes, err
+}
+
+type CreateOrganizationPrototypeOptions struct {
+	Role           RepositoryRole     `json:"role"`
+	Delegate       PrototypeIdentity  `json:"delegate"`
+	ActivatingUser *PrototypeIdentity `json:"activating_user,omitempty"`
+}
+
+func (c *Client) CreateOrganizationPrototype(ctx context.Context, org string, opt CreateOrganizationPrototypeOptions) error {
+	path := fmt.Sprintf("/organization/%s/prototypes", url.PathEscape(org))
+
+	return c.call(ctx, "POST", path, nil, toBody(opt), nil)
+}
+
+type updateOrganizationPrototypeBody struct {
+	Role RepositoryRole `json:"role"`
+}
+
+func (c *Client) UpdateOrganizationPrototype(ctx context.Context, org string, id string, role RepositoryRole) error {
+	path := fmt.Sprintf("/organization/%s/prototypes/%s", url.PathEscape(org), url.PathEscape(id))
+	body := updateOrganizationPrototypeBody{
+		Role: role,
+	}
+
+	return c.call(ctx, "PUT", path, nil, toBody(body), nil)
+}
+
+func (c *Client) DeleteOrganizationPrototype(ctx context.Context, org string, id string) error {
+	path := fmt.Sprintf("/organization/%s/prototypes/%s", url.PathEscape(org), url.PathEscape(id))
+
+	return c.call(ctx, "DELETE", path, nil, nil, nil)
+}
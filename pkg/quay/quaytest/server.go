@@ -0,0 +1,1054 @@
+// Package quaytest provides an in-memory implementation of the parts
+// of the Quay API that Aquayman uses. It is meant for end-to-end tests
+// that should run without access to a real Quay installation.
+package quaytest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+	"github.com/kubermatic-labs/aquayman/pkg/util"
+)
+
+// DefaultPageSize is the number of repositories returned per page,
+// matching the behaviour of quay.io.
+const DefaultPageSize = 100
+
+// Server is a fake Quay API server. All exported fields can be modified
+// before or between requests, but callers must hold the lock (see Lock
+// and Unlock) when doing so while requests are being served.
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	tokenCounter int
+	idCounter    int
+	faults       []fault
+
+	// Token is the expected OAuth2 token; if empty, any token is accepted.
+	Token string
+
+	// PageSize controls the pagination of repository listings.
+	PageSize int
+
+	// Users contains all existing user accounts.
+	Users map[string]struct{}
+
+	// Organizations contains all existing organizations.
+	Organizations map[string]*Organization
+}
+
+type Organization struct {
+	Name         string
+	Robots       map[string]*Robot
+	Teams        map[string]*Team
+	Repositories map[string]*Repository
+
+	// Prototypes are the default permissions, mapped by their ID.
+	Prototypes map[string]*quay.Prototype
+}
+
+type Robot struct {
+	Description string
+	Token       string
+	Created     time.Time
+}
+
+type Team struct {
+	Role        quay.TeamRole
+	Description string
+	Members     []string
+}
+
+type Repository struct {
+	Visibility  quay.RepositoryVisibility
+	Description string
+	Teams       map[string]quay.RepositoryRole
+	Users       map[string]quay.RepositoryRole
+}
+
+// NewServer starts a new fake server. Callers must call Close
+// when they are done.
+func NewServer() *Server {
+	s := &Server{
+		PageSize:      DefaultPageSize,
+		Users:         map[string]struct{}{},
+		Organizations: map[string]*Organization{},
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+// APIURL returns the base URL to use for quay.Client.
+func (s *Server) APIURL() string {
+	return s.URL + "/api/v1"
+}
+
+// NewClient returns a client that is configured to talk to this server.
+func (s *Server) NewClient(dryMode bool) (*quay.Client, error) {
+	token := s.Token
+	if token == "" {
+		token = "quaytest"
+	}
+
+	return quay.NewClient(token, 10*time.Second, dryMode, quay.Endpoint{APIURL: s.APIURL()})
+}
+
+// NewClient starts a server for the duration of the test and returns
+// it along with a client talking to it. The client retries failed
+// requests without any delays, so that tests are not slowed down.
+func NewClient(t testing.TB) (*Server, *quay.Client) {
+	t.Helper()
+
+	server := NewServer()
+	t.Cleanup(server.Close)
+
+	client, err := server.NewClient(false)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	client.Retries = quay.RetryPolicy{MaxRetries: 3}
+
+	return server, client
+}
+
+type fault struct {
+	status     int
+	retryAfter time.Duration
+}
+
+// FailRequests makes the next count requests fail with the given HTTP
+// status. If retryAfter is greater than zero, a Retry-After header is
+// sent along. This is useful to test retry behaviour.
+func (s *Server) FailRequests(count int, status int, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < count; i++ {
+		s.faults = append(s.faults, fault{status: status, retryAfter: retryAfter})
+	}
+}
+
+func (s *Server) Lock() {
+	s.mu.Lock()
+}
+
+func (s *Server) Unlock() {
+	s.mu.Unlock()
+}
+
+// AddUser registers user accounts.
+func (s *Server) AddUser(names ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, name := range names {
+		s.Users[name] = struct{}{}
+	}
+}
+
+// AddOrganization creates a new, empty organization and returns it.
+func (s *Server) AddOrganization(name string) *Organization {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org := &Organization{
+		Name:         name,
+		Robots:       map[string]*Robot{},
+		Teams:        map[string]*Team{},
+		Repositories: map[string]*Repository{},
+		Prototypes:   map[string]*quay.Prototype{},
+	}
+
+	s.Organizations[name] = org
+
+	return org
+}
+
+type apiError struct {
+	Status       int    `json:"status"`
+	ErrorMessage string `json:"error_message"`
+	Title        string `json:"title"`
+	ErrorType    string `json:"error_type"`
+	Detail       string `json:"detail"`
+	Type         string `json:"type"`
+	Message      string `json:"message,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, errorType string, message string) {
+	writeJSON(w, status, apiError{
+		Status:       status,
+		ErrorMessage: message,
+		Title:        errorType,
+		ErrorType:    errorType,
+		Detail:       message,
+		Type:         "https://quay.io/api/v1/error/" + errorType,
+	})
+}
+
+func notFound(w http.ResponseWriter) {
+	writeError(w, http.StatusNotFound, "not_found", "Not Found")
+}
+
+func badRequest(w http.ResponseWriter, message string) {
+	writeError(w, http.StatusBadRequest, "invalid_request", message)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.Token {
+		writeError(w, http.StatusUnauthorized, "invalid_token", "Invalid bearer token")
+		return
+	}
+
+	if len(s.faults) > 0 {
+		f := s.faults[0]
+		s.faults = s.faults[1:]
+
+		if f.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(f.retryAfter.Seconds())))
+		}
+
+		writeError(w, f.status, "fault", http.StatusText(f.status))
+		return
+	}
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/api/v1/")
+	if !ok {
+		notFound(w)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+
+	switch parts[0] {
+	case "organization":
+		s.handleOrganization(w, r, parts[1:])
+	case "repository":
+		s.handleRepository(w, r, strings.TrimPrefix(path, "repository"))
+	case "users":
+		if len(parts) != 2 || r.Method != http.MethodGet {
+			notFound(w)
+			return
+		}
+
+		if _, exists := s.Users[parts[1]]; !exists {
+			notFound(w)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{"username": parts[1]})
+	default:
+		notFound(w)
+	}
+}
+
+func (s *Server) handleOrganization(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) == 0 {
+		notFound(w)
+		return
+	}
+
+	org, exists := s.Organizations[parts[0]]
+	if !exists {
+		notFound(w)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.getOrganization(w, org)
+	case len(parts) == 2 && parts[1] == "members" && r.Method == http.MethodGet:
+		s.getOrganizationMembers(w, org)
+	case len(parts) == 2 && parts[1] == "robots" && r.Method == http.MethodGet:
+		s.getRobots(w, r, org)
+	case len(parts) == 3 && parts[1] == "robots":
+		s.handleRobot(w, r, org, parts[2])
+	case len(parts) == 4 && parts[1] == "robots" && parts[3] == "regenerate" && r.Method == http.MethodPost:
+		s.regenerateRobotToken(w, org, parts[2])
+	case len(parts) == 3 && parts[1] == "team":
+		s.handleTeam(w, r, org, parts[2])
+	case len(parts) == 4 && parts[1] == "team" && parts[3] == "members" && r.Method == http.MethodGet:
+		s.getTeamMembers(w, org, parts[2])
+	case len(parts) == 5 && parts[1] == "team" && parts[3] == "members":
+		s.handleTeamMember(w, r, org, parts[2], parts[4])
+	case len(parts) == 2 && parts[1] == "prototypes":
+		s.handlePrototypes(w, r, org)
+	case len(parts) == 3 && parts[1] == "prototypes":
+		s.handlePrototype(w, r, org, parts[2])
+	default:
+		notFound(w)
+	}
+}
+
+func (s *Server) getOrganization(w http.ResponseWriter, org *Organization) {
+	teams := map[string]interface{}{}
+	names := []string{}
+
+	for name, team := range org.Teams {
+		names = append(names, name)
+		teams[name] = map[string]interface{}{
+			"name":         name,
+			"role":         team.Role,
+			"description":  team.Description,
+			"member_count": len(team.Members),
+			"can_view":     true,
+		}
+	}
+
+	sort.Strings(names)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":          org.Name,
+		"is_member":     true,
+		"is_admin":      true,
+		"teams":         teams,
+		"ordered_teams": names,
+	})
+}
+
+func (s *Server) getOrganizationMembers(w http.ResponseWriter, org *Organization) {
+	members := map[string][]string{}
+
+	for teamName, team := range org.Teams {
+		for _, member := range team.Members {
+			members[member] = append(members[member], teamName)
+		}
+	}
+
+	result := []interface{}{}
+	for name, teams := range members {
+		teamList := []interface{}{}
+		for _, team := range teams {
+			teamList = append(teamList, map[string]string{"name": team})
+		}
+
+		kind := "user"
+		if quay.IsRobotUsername(name) {
+			kind = "robot"
+		}
+
+		result = append(result, map[string]interface{}{
+			"kind":  kind,
+			"name":  name,
+			"teams": teamList,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"members": result})
+}
+
+func (s *Server) getRobots(w http.ResponseWriter, r *http.Request, org *Organization) {
+	withToken := r.URL.Query().Get("token") == "true"
+	withPermissions := r.URL.Query().Get("permissions") == "true"
+
+	robots := []interface{}{}
+	for name, robot := range org.Robots {
+		robots = append(robots, s.robotResponse(org, name, robot, withToken, withPermissions))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"robots": robots})
+}
+
+func (s *Server) robotResponse(org *Organization, name string, robot *Robot, withToken bool, withPermissions bool) map[string]interface{} {
+	fullName := org.Name + "+" + name
+
+	response := map[string]interface{}{
+		"name":        fullName,
+		"description": robot.Description,
+	}
+
+	if withToken {
+		response["token"] = robot.Token
+	}
+
+	if !robot.Created.IsZero() {
+		response["created"] = robot.Created.UTC().Format(time.RFC1123Z)
+	}
+
+	if withPermissions {
+		repos := []string{}
+		for repoName, repo := range org.Repositories {
+			if _, exists := repo.Users[fullName]; exists {
+				repos = append(repos, repoName)
+			}
+		}
+
+		teams := []interface{}{}
+		for teamName, team := range org.Teams {
+			if util.StringSliceContains(team.Members, fullName) {
+				teams = append(teams, map[string]string{"name": teamName})
+			}
+		}
+
+		response["repositories"] = repos
+		response["teams"] = teams
+	}
+
+	return response
+}
+
+func (s *Server) handleRobot(w http.ResponseWriter, r *http.Request, org *Organization, name string) {
+	robot, exists := org.Robots[name]
+
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			notFound(w)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, s.robotResponse(org, name, robot, true, false))
+
+	case http.MethodPut:
+		if exists {
+			badRequest(w, fmt.Sprintf("Existing robot with name: %s+%s", org.Name, name))
+			return
+		}
+
+		body := quay.CreateOrganizationRobotOptions{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			badRequest(w, "Invalid request body")
+			return
+		}
+
+		robot = &Robot{
+			Description: body.Description,
+			Token:       s.newToken(org.Name, name),
+			Created:     time.Now(),
+		}
+
+		org.Robots[name] = robot
+
+		writeJSON(w, http.StatusCreated, s.robotResponse(org, name, robot, true, false))
+
+	case http.MethodDelete:
+		if !exists {
+			notFound(w)
+			return
+		}
+
+		fullName := org.Name + "+" + name
+
+		delete(org.Robots, name)
+		for _, team := range org.Teams {
+			team.Members = removeString(team.Members, fullName)
+		}
+		for _, repo := range org.Repositories {
+			delete(repo.Users, fullName)
+		}
+		for id, prototype := range org.Prototypes {
+			if prototype.Delegate.Name == fullName {
+				delete(org.Prototypes, id)
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		notFound(w)
+	}
+}
+
+func (s *Server) regenerateRobotToken(w http.ResponseWriter, org *Organization, name string) {
+	robot, exists := org.Robots[name]
+	if !exists {
+		notFound(w)
+		return
+	}
+
+	robot.Token = s.newToken(org.Name, name)
+
+	writeJSON(w, http.StatusOK, s.robotResponse(org, name, robot, true, false))
+}
+
+func (s *Server) newToken(org string, robot string) string {
+	s.tokenCounter++
+
+	return fmt.Sprintf("%s-%s-token-%d", org, robot, s.tokenCounter)
+}
+
+func (s *Server) handleTeam(w http.ResponseWriter, r *http.Request, org *Organization, name string) {
+	switch r.Method {
+	case http.MethodPut:
+		body := quay.UpsertTeamOptions{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			badRequest(w, "Invalid request body")
+			return
+		}
+
+		if !validTeamRole(body.Role) {
+			badRequest(w, fmt.Sprintf("Invalid role: %s", body.Role))
+			return
+		}
+
+		team, exists := org.Teams[name]
+		if !exists {
+			team = &Team{Members: []string{}}
+			org.Teams[name] = team
+		}
+
+		team.Role = body.Role
+		team.Description = body.Description
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":        name,
+			"role":        team.Role,
+			"description": team.Description,
+		})
+
+	case http.MethodDelete:
+		if _, exists := org.Teams[name]; !exists {
+			notFound(w)
+			return
+		}
+
+		delete(org.Teams, name)
+		for _, repo := range org.Repositories {
+			delete(repo.Teams, name)
+		}
+		for id, prototype := range org.Prototypes {
+			if prototype.Delegate.Kind == quay.PrototypeDelegateTeam && prototype.Delegate.Name == name {
+				delete(org.Prototypes, id)
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		notFound(w)
+	}
+}
+
+func (s *Server) getTeamMembers(w http.ResponseWriter, org *Organization, teamName string) {
+	team, exists := org.Teams[teamName]
+	if !exists {
+		notFound(w)
+		return
+	}
+
+	members := []interface{}{}
+	for _, member := range team.Members {
+		isRobot := quay.IsRobotUsername(member)
+		kind := quay.TeamMemberUser
+		if isRobot {
+			kind = quay.TeamMemberRobot
+		}
+
+		members = append(members, quay.TeamMember{
+			Kind:    kind,
+			Name:    member,
+			IsRobot: isRobot,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":     teamName,
+		"can_edit": true,
+		"members":  members,
+	})
+}
+
+func (s *Server) handleTeamMember(w http.ResponseWriter, r *http.Request, org *Organization, teamName string, member string) {
+	team, exists := org.Teams[teamName]
+	if !exists {
+		notFound(w)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if !s.accountExists(org, member) {
+			badRequest(w, fmt.Sprintf("Unknown user: %s", member))
+			return
+		}
+
+		if util.StringSliceContains(team.Members, member) {
+			// quay.io uses the undocumented "message" field in this case
+			writeJSON(w, http.StatusBadRequest, apiError{
+				Status:  http.StatusBadRequest,
+				Message: fmt.Sprintf("User %s is already a member of team %s", member, teamName),
+			})
+			return
+		}
+
+		team.Members = append(team.Members, member)
+		sort.Strings(team.Members)
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": member})
+
+	case http.MethodDelete:
+		if !util.StringSliceContains(team.Members, member) {
+			notFound(w)
+			return
+		}
+
+		team.Members = removeString(team.Members, member)
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		notFound(w)
+	}
+}
+
+func (s *Server) accountExists(org *Organization, name string) bool {
+	if quay.IsRobotUsername(name) {
+		parts := strings.SplitN(name, "+", 2)
+		if parts[0] != org.Name {
+			return false
+		}
+
+		_, exists := org.Robots[parts[1]]
+
+		return exists
+	}
+
+	_, exists := s.Users[name]
+
+	return exists
+}
+
+func (s *Server) handlePrototypes(w http.ResponseWriter, r *http.Request, org *Organization) {
+	switch r.Method {
+	case http.MethodGet:
+		prototypes := []*quay.Prototype{}
+		for _, prototype := range org.Prototypes {
+			prototypes = append(prototypes, prototype)
+		}
+
+		sort.Slice(prototypes, func(i, j int) bool {
+			return prototypes[i].ID < prototypes[j].ID
+		})
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{"prototypes": prototypes})
+
+	case http.MethodPost:
+		body := quay.CreateOrganizationPrototypeOptions{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !validRepositoryRole(body.Role) {
+			badRequest(w, "Invalid request body")
+			return
+		}
+
+		delegate := body.Delegate
+
+		switch delegate.Kind {
+		case quay.PrototypeDelegateTeam:
+			if _, exists := org.Teams[delegate.Name]; !exists {
+				badRequest(w, fmt.Sprintf("Unknown team: %s", delegate.Name))
+				return
+			}
+		case quay.PrototypeDelegateUser:
+			if !s.accountExists(org, delegate.Name) {
+				badRequest(w, fmt.Sprintf("Unknown user: %s", delegate.Name))
+				return
+			}
+
+			delegate.IsRobot = quay.IsRobotUsername(delegate.Name)
+		default:
+			badRequest(w, "Invalid delegate kind")
+			return
+		}
+
+		if body.ActivatingUser != nil {
+			if _, exists := s.Users[body.ActivatingUser.Name]; !exists {
+				badRequest(w, fmt.Sprintf("Unknown activating user: %s", body.ActivatingUser.Name))
+				return
+			}
+
+			body.ActivatingUser.Kind = quay.PrototypeDelegateUser
+		}
+
+		s.idCounter++
+
+		prototype := &quay.Prototype{
+			ID:             fmt.Sprintf("prototype-%d", s.idCounter),
+			Role:           body.Role,
+			Delegate:       delegate,
+			ActivatingUser: body.ActivatingUser,
+		}
+
+		org.Prototypes[prototype.ID] = prototype
+
+		writeJSON(w, http.StatusOK, prototype)
+
+	default:
+		notFound(w)
+	}
+}
+
+func (s *Server) handlePrototype(w http.ResponseWriter, r *http.Request, org *Organization, id string) {
+	prototype, exists := org.Prototypes[id]
+	if !exists {
+		notFound(w)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		body := struct {
+			Role quay.RepositoryRole `json:"role"`
+		}{}
+
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !validRepositoryRole(body.Role) {
+			badRequest(w, "Invalid role")
+			return
+		}
+
+		prototype.Role = body.Role
+
+		writeJSON(w, http.StatusOK, prototype)
+
+	case http.MethodDelete:
+		delete(org.Prototypes, id)
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		notFound(w)
+	}
+}
+
+func (s *Server) handleRepository(w http.ResponseWriter, r *http.Request, path string) {
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			s.listRepositories(w, r)
+		case http.MethodPost:
+			s.createRepository(w, r)
+		default:
+			notFound(w)
+		}
+
+		return
+	}
+
+	path = strings.TrimPrefix(path, "/")
+
+	var action, kind, subject string
+
+	if idx := strings.LastIndex(path, "/permissions/"); idx >= 0 {
+		rest := strings.Split(path[idx+len("/permissions/"):], "/")
+		path = path[:idx]
+		action = "permissions"
+		kind = rest[0]
+
+		if len(rest) > 1 {
+			subject = rest[1]
+		}
+	} else if trimmed, ok := strings.CutSuffix(path, "/changevisibility"); ok {
+		path = trimmed
+		action = "changevisibility"
+	}
+
+	namespace, name, ok := strings.Cut(path, "/")
+	if !ok {
+		notFound(w)
+		return
+	}
+
+	org, exists := s.Organizations[namespace]
+	if !exists {
+		notFound(w)
+		return
+	}
+
+	repo, exists := org.Repositories[name]
+
+	switch action {
+	case "permissions":
+		// quay.io does not complain about missing repositories when
+		// listing permissions, so we do not either
+		if !exists && r.Method == http.MethodGet {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"permissions": map[string]interface{}{}})
+			return
+		}
+
+		if !exists {
+			notFound(w)
+			return
+		}
+
+		s.handlePermissions(w, r, org, repo, kind, subject)
+
+	case "changevisibility":
+		if !exists || r.Method != http.MethodPost {
+			notFound(w)
+			return
+		}
+
+		body := struct {
+			Visibility quay.RepositoryVisibility `json:"visibility"`
+		}{}
+
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !validVisibility(body.Visibility) {
+			badRequest(w, "Invalid visibility")
+			return
+		}
+
+		repo.Visibility = body.Visibility
+
+		writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+
+	default:
+		if !exists {
+			notFound(w)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, repositoryResponse(namespace, name, repo))
+
+		case http.MethodPut:
+			body := quay.UpdateRepositoryOptions{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				badRequest(w, "Invalid request body")
+				return
+			}
+
+			repo.Description = body.Description
+
+			writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+
+		case http.MethodDelete:
+			delete(org.Repositories, name)
+
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			notFound(w)
+		}
+	}
+}
+
+func repositoryResponse(namespace string, name string, repo *Repository) quay.Repository {
+	return quay.Repository{
+		Kind:        string(quay.ImageRepository),
+		Namespace:   namespace,
+		Name:        name,
+		IsPublic:    repo.Visibility == quay.Public,
+		Description: repo.Description,
+	}
+}
+
+func (s *Server) listRepositories(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	org, exists := s.Organizations[query.Get("namespace")]
+	if !exists {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"repositories": []interface{}{}})
+		return
+	}
+
+	names := []string{}
+	for name := range org.Repositories {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	offset := 0
+	if nextPage := query.Get("next_page"); nextPage != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(nextPage)
+		if err != nil {
+			badRequest(w, "Invalid next_page token")
+			return
+		}
+
+		offset, err = strconv.Atoi(string(decoded))
+		if err != nil || offset < 0 || offset > len(names) {
+			badRequest(w, "Invalid next_page token")
+			return
+		}
+	}
+
+	pageSize := s.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
+	end := offset + pageSize
+	if end > len(names) {
+		end = len(names)
+	}
+
+	repos := []quay.Repository{}
+	for _, name := range names[offset:end] {
+		repos = append(repos, repositoryResponse(org.Name, name, org.Repositories[name]))
+	}
+
+	response := map[string]interface{}{"repositories": repos}
+	if end < len(names) {
+		response["next_page"] = base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(end)))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) createRepository(w http.ResponseWriter, r *http.Request) {
+	body := quay.CreateRepositoryOptions{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		badRequest(w, "Invalid request body")
+		return
+	}
+
+	org, exists := s.Organizations[body.Namespace]
+	if !exists {
+		notFound(w)
+		return
+	}
+
+	if !validVisibility(body.Visibility) {
+		badRequest(w, "Invalid visibility")
+		return
+	}
+
+	if _, exists := org.Repositories[body.Repository]; exists {
+		badRequest(w, "Repository already exists")
+		return
+	}
+
+	org.Repositories[body.Repository] = &Repository{
+		Visibility:  body.Visibility,
+		Description: body.Description,
+		Teams:       map[string]quay.RepositoryRole{},
+		Users:       map[string]quay.RepositoryRole{},
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"namespace": body.Namespace,
+		"name":      body.Repository,
+		"kind":      string(quay.ImageRepository),
+	})
+}
+
+func (s *Server) handlePermissions(w http.ResponseWriter, r *http.Request, org *Organization, repo *Repository, kind string, subject string) {
+	var permissions map[string]quay.RepositoryRole
+
+	switch kind {
+	case "user":
+		permissions = repo.Users
+	case "team":
+		permissions = repo.Teams
+	default:
+		notFound(w)
+		return
+	}
+
+	switch {
+	case subject == "" && r.Method == http.MethodGet:
+		result := map[string]quay.Permission{}
+		for name, role := range permissions {
+			result[name] = permissionResponse(kind, name, role)
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{"permissions": result})
+
+	case subject != "" && r.Method == http.MethodPut:
+		body := struct {
+			Role quay.RepositoryRole `json:"role"`
+		}{}
+
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || !validRepositoryRole(body.Role) {
+			badRequest(w, "Invalid role")
+			return
+		}
+
+		if kind == "team" {
+			if _, exists := org.Teams[subject]; !exists {
+				badRequest(w, fmt.Sprintf("Unknown team: %s", subject))
+				return
+			}
+		} else if !s.accountExists(org, subject) {
+			badRequest(w, fmt.Sprintf("Unknown user: %s", subject))
+			return
+		}
+
+		permissions[subject] = body.Role
+
+		writeJSON(w, http.StatusOK, permissionResponse(kind, subject, body.Role))
+
+	case subject != "" && r.Method == http.MethodDelete:
+		if _, exists := permissions[subject]; !exists {
+			notFound(w)
+			return
+		}
+
+		delete(permissions, subject)
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		notFound(w)
+	}
+}
+
+func permissionResponse(kind string, name string, role quay.RepositoryRole) quay.Permission {
+	permission := quay.Permission{
+		Role: role,
+		Name: name,
+	}
+
+	if kind == "user" {
+		permission.IsRobot = quay.IsRobotUsername(name)
+	}
+
+	return permission
+}
+
+func validTeamRole(role quay.TeamRole) bool {
+	for _, r := range quay.AllTeamRoles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validRepositoryRole(role quay.RepositoryRole) bool {
+	for _, r := range quay.AllRepositoryRoles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validVisibility(visibility quay.RepositoryVisibility) bool {
+	return visibility == quay.Public || visibility == quay.Private
+}
+
+func removeString(s []string, needle string) []string {
+	result := []string{}
+	for _, item := range s {
+		if item != needle {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
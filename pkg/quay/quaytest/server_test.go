@@ -0,0 +1,215 @@
+package quaytest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+	"github.com/kubermatic-labs/aquayman/pkg/quay/quaytest"
+)
+
+const testOrganization = "testorg"
+
+func newTestServer(t *testing.T) (*quaytest.Server, *quay.Client) {
+	t.Helper()
+
+	server, client := quaytest.NewClient(t)
+	server.AddUser("alice", "bob")
+	server.AddOrganization(testOrganization)
+
+	return server, client
+}
+
+func assertAPIError(t *testing.T, err error, status int, expected string) {
+	t.Helper()
+
+	var apiErr *quay.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected an API error, but got %v.", err)
+	}
+
+	if apiErr.Status != status || apiErr.Error() != expected {
+		t.Fatalf("Expected %d %q, but got %d %q.", status, expected, apiErr.Status, apiErr.Error())
+	}
+}
+
+func TestTeams(t *testing.T) {
+	ctx := context.Background()
+	server, client := newTestServer(t)
+
+	if err := client.UpsertTeam(ctx, testOrganization, "developers", quay.UpsertTeamOptions{Role: quay.MemberTeamRole, Description: "devs"}); err != nil {
+		t.Fatalf("Failed to create team: %v", err)
+	}
+
+	if err := client.AddUserToTeam(ctx, testOrganization, "developers", "alice"); err != nil {
+		t.Fatalf("Failed to add team member: %v", err)
+	}
+
+	members, err := client.GetTeamMembers(ctx, testOrganization, "developers", quay.GetTeamMembersOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list team members: %v", err)
+	}
+
+	if len(members) != 1 || members[0].Name != "alice" {
+		t.Fatalf("Expected alice to be the only member, but got %+v.", members)
+	}
+
+	if team := server.Organizations[testOrganization].Teams["developers"]; team.Description != "devs" {
+		t.Errorf("Expected team description to be stored, but got %q.", team.Description)
+	}
+
+	// quay.io only sets the undocumented "message" field here
+	err = client.AddUserToTeam(ctx, testOrganization, "developers", "alice")
+	assertAPIError(t, err, http.StatusBadRequest, "User alice is already a member of team developers")
+
+	err = client.AddUserToTeam(ctx, testOrganization, "developers", "mallory")
+	assertAPIError(t, err, http.StatusBadRequest, "invalid_request: Unknown user: mallory")
+
+	if err := client.DeleteTeam(ctx, testOrganization, "developers"); err != nil {
+		t.Fatalf("Failed to delete team: %v", err)
+	}
+
+	err = client.DeleteTeam(ctx, testOrganization, "developers")
+	assertAPIError(t, err, http.StatusNotFound, "not_found: Not Found")
+}
+
+func TestRobots(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestServer(t)
+
+	if err := client.CreateOrganizationRobot(ctx, testOrganization, "ci", quay.CreateOrganizationRobotOptions{Description: "CI robot"}); err != nil {
+		t.Fatalf("Failed to create robot: %v", err)
+	}
+
+	robot, err := client.GetOrganizationRobot(ctx, testOrganization, "ci")
+	if err != nil {
+		t.Fatalf("Failed to get robot: %v", err)
+	}
+
+	if robot.Name != testOrganization+"+ci" || robot.Description != "CI robot" || robot.Token == "" {
+		t.Fatalf("Expected robot with description and token, but got %+v.", robot)
+	}
+
+	// robots can be used like users in the organization
+	if err := client.UpsertTeam(ctx, testOrganization, "bots", quay.UpsertTeamOptions{Role: quay.MemberTeamRole}); err != nil {
+		t.Fatalf("Failed to create team: %v", err)
+	}
+
+	if err := client.AddUserToTeam(ctx, testOrganization, "bots", robot.Name); err != nil {
+		t.Fatalf("Failed to add robot to team: %v", err)
+	}
+
+	if err := client.DeleteOrganizationRobot(ctx, testOrganization, "ci"); err != nil {
+		t.Fatalf("Failed to delete robot: %v", err)
+	}
+
+	_, err = client.GetOrganizationRobot(ctx, testOrganization, "ci")
+	assertAPIError(t, err, http.StatusNotFound, "not_found: Not Found")
+}
+
+func TestRepositoryPagination(t *testing.T) {
+	ctx := context.Background()
+	server, client := newTestServer(t)
+
+	server.PageSize = 2
+
+	for i := 0; i < 5; i++ {
+		err := client.CreateRepository(ctx, quay.CreateRepositoryOptions{
+			Kind:       quay.ImageRepository,
+			Namespace:  testOrganization,
+			Repository: fmt.Sprintf("repo-%d", i),
+			Visibility: quay.Private,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create repository: %v", err)
+		}
+	}
+
+	repos, err := client.GetRepositories(ctx, quay.GetRepositoriesOptions{Namespace: testOrganization})
+	if err != nil {
+		t.Fatalf("Failed to list repositories: %v", err)
+	}
+
+	if len(repos) != 5 {
+		t.Fatalf("Expected 5 repositories across all pages, but got %d.", len(repos))
+	}
+
+	for i, repo := range repos {
+		if expected := fmt.Sprintf("repo-%d", i); repo.Name != expected || repo.Visibility() != quay.Private {
+			t.Errorf("Expected private repository %s, but got %+v.", expected, repo)
+		}
+	}
+}
+
+func TestRepositoryPermissions(t *testing.T) {
+	ctx := context.Background()
+	server, client := newTestServer(t)
+
+	org := server.Organizations[testOrganization]
+	org.Teams["developers"] = &quaytest.Team{Role: quay.MemberTeamRole}
+	org.Repositories["app"] = &quaytest.Repository{
+		Visibility: quay.Public,
+		Teams:      map[string]quay.RepositoryRole{},
+		Users:      map[string]quay.RepositoryRole{},
+	}
+
+	repo := testOrganization + "/app"
+
+	if err := client.SetTeamRepositoryPermissions(ctx, repo, "developers", quay.WriteRepositoryRole); err != nil {
+		t.Fatalf("Failed to set team permission: %v", err)
+	}
+
+	if err := client.SetUserRepositoryPermissions(ctx, repo, "bob", quay.ReadRepositoryRole); err != nil {
+		t.Fatalf("Failed to set user permission: %v", err)
+	}
+
+	teams, err := client.GetRepositoryTeamPermissions(ctx, repo)
+	if err != nil {
+		t.Fatalf("Failed to get team permissions: %v", err)
+	}
+
+	if teams["developers"].Role != quay.WriteRepositoryRole {
+		t.Errorf("Expected developers to have write role, but got %+v.", teams)
+	}
+
+	users, err := client.GetRepositoryUserPermissions(ctx, repo)
+	if err != nil {
+		t.Fatalf("Failed to get user permissions: %v", err)
+	}
+
+	if users["bob"].Role != quay.ReadRepositoryRole {
+		t.Errorf("Expected bob to have read role, but got %+v.", users)
+	}
+
+	if err := client.RemoveUserFromRepository(ctx, repo, "bob"); err != nil {
+		t.Fatalf("Failed to remove user permission: %v", err)
+	}
+
+	if _, exists := org.Repositories["app"].Users["bob"]; exists {
+		t.Error("Expected bob's permission to be removed.")
+	}
+
+	err = client.SetTeamRepositoryPermissions(ctx, testOrganization+"/missing", "developers", quay.ReadRepositoryRole)
+	assertAPIError(t, err, http.StatusNotFound, "not_found: Not Found")
+}
+
+func TestDryMode(t *testing.T) {
+	ctx := context.Background()
+	server, _ := newTestServer(t)
+
+	client, err := server.NewClient(true)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if err := client.UpsertTeam(ctx, testOrganization, "developers", quay.UpsertTeamOptions{Role: quay.MemberTeamRole}); err != nil {
+		t.Fatalf("Failed to create team: %v", err)
+	}
+
+	if len(server.Organizations[testOrganization].Teams) != 0 {
+		t.Error("Expected no changes in dry mode.")
+	}
+}
@@ -0,0 +1,251 @@
+package quay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRetryAfterIsCapped(t *testing.T) {
+	policy := RetryPolicy{
+		MaxRetries:   3,
+		InitialDelay: time.Second,
+		MaxDelay:     10 * time.Second,
+	}
+
+	if d := policy.delay(0, 5*time.Second); d != 5*time.Second {
+		t.Errorf("Expected Retry-After of 5s to be used as-is, but got %v.", d)
+	}
+
+	if d := policy.delay(0, 24*time.Hour); d != policy.MaxDelay {
+		t.Errorf("Expected Retry-After to be capped at %v, but got %v.", policy.MaxDelay, d)
+	}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		if d := policy.delay(attempt, 0); d > policy.MaxDelay {
+			t.Errorf("Expected delay for attempt %d to be at most %v, but got %v.", attempt, policy.MaxDelay, d)
+		}
+	}
+}
+
+// newFlakyServer returns a server that fails the first request with
+// the given status and then responds with 404.
+func newFlakyServer(t *testing.T, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if requests.Add(1) == 1 {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"error_message": "upstream failed", "title": "fault"}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"status": 404, "error_message": "Not Found", "title": "not_found"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func newTestClient(t *testing.T, url string) *Client {
+	t.Helper()
+
+	client, err := NewClient("token", 5*time.Second, false, Endpoint{APIURL: url})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	client.Retries = RetryPolicy{MaxRetries: 3}
+
+	return client
+}
+
+func TestRetriedDeleteTreats404AsSuccess(t *testing.T) {
+	server, requests := newFlakyServer(t, http.StatusBadGateway)
+	client := newTestClient(t, server.URL)
+
+	if err := client.DeleteRepository(context.Background(), "org/repo"); err != nil {
+		t.Fatalf("Expected retried deletion to succeed, but got: %v", err)
+	}
+
+	if n := requests.Load(); n != 2 {
+		t.Errorf("Expected 2 requests, but got %d.", n)
+	}
+}
+
+func TestDelete404WithoutRetryFails(t *testing.T) {
+	server, _ := newFlakyServer(t, http.StatusNotFound)
+	client := newTestClient(t, server.URL)
+
+	err := client.DeleteRepository(context.Background(), "org/repo")
+	if err == nil {
+		t.Fatal("Expected deleting a missing repository to fail.")
+	}
+
+	if !isNotFound(err) {
+		t.Errorf("Expected a 404 API error, but got: %v", err)
+	}
+}
+
+// newFailingServer returns a server that fails the first count
+// requests with the given status and Retry-After header and then
+// responds successfully.
+func newFailingServer(t *testing.T, count int32, status int, retryAfter string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if requests.Add(1) <= count {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"error_message": "try again", "title": "fault"}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func TestRetries(t *testing.T) {
+	testcases := []struct {
+		name        string
+		status      int
+		post        bool
+		retried     bool
+		rateLimited bool
+	}{
+		{
+			name:        "rate limited GET",
+			status:      http.StatusTooManyRequests,
+			retried:     true,
+			rateLimited: true,
+		},
+		{
+			name:    "bad gateway GET",
+			status:  http.StatusBadGateway,
+			retried: true,
+		},
+		{
+			name:        "rate limited POST",
+			status:      http.StatusTooManyRequests,
+			post:        true,
+			retried:     true,
+			rateLimited: true,
+		},
+		{
+			// the server might have processed the request already
+			name:   "bad gateway POST",
+			status: http.StatusBadGateway,
+			post:   true,
+		},
+		{
+			name:   "bad request GET",
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, requests := newFailingServer(t, 2, tc.status, "")
+			client := newTestClient(t, server.URL)
+
+			var err error
+			if tc.post {
+				err = client.CreateRepository(context.Background(), CreateRepositoryOptions{Namespace: "org", Repository: "repo"})
+			} else {
+				_, err = client.GetOrganizationRobot(context.Background(), "org", "ci")
+			}
+
+			expected := Stats{Requests: 1}
+			if tc.retried {
+				expected = Stats{Requests: 3, Retries: 2}
+				if tc.rateLimited {
+					expected.RateLimited = 2
+				}
+			}
+
+			if tc.retried != (err == nil) {
+				t.Fatalf("Expected success to be %v, but got %v.", tc.retried, err)
+			}
+
+			if n := requests.Load(); int64(n) != expected.Requests {
+				t.Errorf("Expected %d requests, but the server got %d.", expected.Requests, n)
+			}
+
+			if stats := client.Stats(); stats != expected {
+				t.Errorf("Expected stats %+v, but got %+v.", expected, stats)
+			}
+		})
+	}
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	server, _ := newFailingServer(t, 1, http.StatusTooManyRequests, "1")
+	client := newTestClient(t, server.URL)
+
+	start := time.Now()
+
+	if _, err := client.GetOrganizationRobot(context.Background(), "org", "ci"); err != nil {
+		t.Fatalf("Expected the retried request to succeed, but got: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected the client to wait for 1s as requested, but it retried after %v.", elapsed)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	server, requests := newFailingServer(t, 10, http.StatusServiceUnavailable, "")
+	client := newTestClient(t, server.URL)
+
+	if _, err := client.GetOrganizationRobot(context.Background(), "org", "ci"); err == nil {
+		t.Fatal("Expected the request to fail after all retries.")
+	}
+
+	if n := requests.Load(); n != 4 {
+		t.Errorf("Expected 1 request and 3 retries, but the server got %d requests.", n)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	server, requests := newFailingServer(t, 0, 0, "")
+	client := newTestClient(t, server.URL)
+
+	// allow one request every 50ms
+	client.Limiter = rate.NewLimiter(rate.Limit(20), 1)
+
+	start := time.Now()
+
+	for i := 0; i < 5; i++ {
+		if _, err := client.GetOrganizationRobot(context.Background(), "org", "ci"); err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected 5 requests to take about 200ms, but they took %v.", elapsed)
+	}
+
+	if n := requests.Load(); n != 5 {
+		t.Errorf("Expected 5 requests, but the server got %d.", n)
+	}
+}
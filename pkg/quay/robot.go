@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"sort"
 	"strings"
+	"time"
 )
 
 func IsRobotUsername(name string) bool {
@@ -17,6 +18,9 @@ type Robot struct {
 	Description string `json:"description"`
 	Token       string `json:"token"`
 
+	// Created is only returned by newer Quay versions.
+	Created string `json:"created,omitempty"`
+
 	// Repositories is only set if the permissions option is set
 	// when fetching robots.
 	Repositories []string `json:"repositories"`
@@ -28,6 +32,20 @@ type Robot struct {
 	} `json:"teams"`
 }
 
+// CreatedAt parses the creation timestamp, if Quay returned one.
+func (r *Robot) CreatedAt() (time.Time, bool) {
+	if r.Created == "" {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC1123Z, r.Created)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
 func (r *Robot) ShortName() string {
 	parts := strings.SplitN(r.Name, "+", 2)
 
@@ -95,3 +113,11 @@ func (c *Client) DeleteOrganizationRobot(ctx context.Context, org string, shortN
 
 	return c.call(ctx, "DELETE", path, nil, nil, nil)
 }
+
+func (c *Client) RegenerateOrganizationRobotToken(ctx context.Context, org string, shortName string) (*Robot, error) {
+	response := Robot{}
+	path := fmt.Sprintf("/organization/%s/robots/%s/regenerate", url.PathEscape(org), url.PathEscape(shortName))
+	err := c.call(ctx, "POST", path, nil, nil, &response)
+
+	return &response, err
+}
@@ -0,0 +1,80 @@
+// Package statefile stores information that Aquayman needs to remember
+// between runs, but that cannot be stored in Quay itself.
+package statefile
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+type StateFile struct {
+	// Robots maps full robot names ("[org]+[name]") to their state.
+	Robots map[string]RobotState `json:"robots,omitempty"`
+}
+
+type RobotState struct {
+	// RotatedAt is the last time the robot's token was regenerated.
+	RotatedAt time.Time `json:"rotatedAt,omitzero"`
+
+	// VaultSecrets lists all Vault secrets that contain fields
+	// written for this robot, so they can be cleaned up even if
+	// the robot is removed from the configuration.
+	VaultSecrets []VaultSecretState `json:"vaultSecrets,omitempty"`
+}
+
+// IsZero returns true if there is nothing to remember about a robot.
+func (s RobotState) IsZero() bool {
+	return s.RotatedAt.IsZero() && len(s.VaultSecrets) == 0
+}
+
+type VaultSecretState struct {
+	// Path is the logical path of the secret, e.g. "secret/customer-xyz".
+	Path string `json:"path"`
+
+	// Fields are the names of the fields written by Aquayman.
+	Fields []string `json:"fields"`
+}
+
+// Load reads a state file. A missing file is not an error
+// and results in an empty state.
+func Load(filename string) (*StateFile, error) {
+	state := &StateFile{
+		Robots: map[string]RobotState{},
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return state, nil
+		}
+
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(state); err != nil {
+		return nil, err
+	}
+
+	if state.Robots == nil {
+		state.Robots = map[string]RobotState{}
+	}
+
+	return state, nil
+}
+
+// Save writes the state file.
+func Save(state *StateFile, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(state)
+}
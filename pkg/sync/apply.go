@@ -0,0 +1,465 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/publisher"
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+	"github.com/kubermatic-labs/aquayman/pkg/util"
+)
+
+// ErrStateDrifted is returned when a plan is applied to an organization
+// that has changed since the plan was created.
+var ErrStateDrifted = errors.New("the organization has changed since the plan was created")
+
+// CheckDrift fetches the current organization state and returns
+// ErrStateDrifted if it does not match the state the plan was based on.
+func CheckDrift(ctx context.Context, client quay.API, plan *Plan, options Options) error {
+	state, err := FetchState(ctx, client, plan.Organization, options.Parallelism)
+	if err != nil {
+		return err
+	}
+
+	hash, err := state.Hash()
+	if err != nil {
+		return fmt.Errorf("failed to hash state: %v", err)
+	}
+
+	if hash != plan.StateHash {
+		return ErrStateDrifted
+	}
+
+	return nil
+}
+
+// LogPlan prints all actions of a plan, without performing them.
+func LogPlan(plan *Plan) {
+	logger := newActionLogger()
+	for _, action := range plan.Actions {
+		logger.log(action)
+	}
+}
+
+// Apply performs all actions in the plan, in order. Actions for
+// different repositories are applied concurrently, but in the same
+// order per repository. Afterwards, robot tokens are published if a
+// publisher is configured.
+func Apply(ctx context.Context, client quay.API, plan *Plan, options Options) error {
+	logger := newActionLogger()
+
+	var (
+		pub      publisher.Publisher
+		recorder *resultRecorder
+	)
+
+	if options.Publisher != nil {
+		recorder = newResultRecorder(options.Publisher)
+		pub = recorder
+	}
+
+	// robots and teams must be handled first, as repository
+	// permissions can refer to them
+	repoActions := [][]Action{}
+	repoIndex := map[string]int{}
+
+	for _, action := range plan.Actions {
+		if action.section() == "repositories" {
+			idx, exists := repoIndex[action.Repository]
+			if !exists {
+				idx = len(repoActions)
+				repoIndex[action.Repository] = idx
+				repoActions = append(repoActions, nil)
+			}
+
+			repoActions[idx] = append(repoActions[idx], action)
+			continue
+		}
+
+		logger.log(action)
+
+		if err := applyAction(ctx, client, plan, action, pub); err != nil {
+			return err
+		}
+	}
+
+	if len(repoActions) > 0 {
+		log.Println("⇄ Syncing repositories…")
+
+		loggers := make([]*actionLogger, len(repoActions))
+		errs := []error{}
+
+		apply := func(i int) error {
+			// buffer the output, so it can be printed in order
+			loggers[i] = newBufferedActionLogger("repositories")
+
+			for _, action := range repoActions[i] {
+				loggers[i].log(action)
+
+				if err := applyAction(ctx, client, plan, action, pub); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		util.Parallel(options.Parallelism, len(repoActions), apply, func(i int, err error) {
+			loggers[i].flush()
+
+			if err != nil {
+				log.Printf("    ⚠ %v", err)
+				errs = append(errs, err)
+			}
+		})
+
+		if err := errors.Join(errs...); err != nil {
+			return err
+		}
+	}
+
+	// Now that all robots have been created, we can sync their tokens to the publisher;
+	// this has the advantage of doing it for _all_ robots, not just those that were
+	// freshly created (i.e. putting a new VaultSecret path into the config will take
+	// effect without having to delete and recreate the robot); the disadvantage is that
+	// we check and update all robots in Vault all the time.
+	if pub != nil {
+		log.Println("⇄ Publishing robot tokens…")
+
+		err := publishRobots(ctx, plan, client, pub)
+		recorder.logSummary()
+
+		if err != nil {
+			return fmt.Errorf("failed to publish robots: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func applyAction(ctx context.Context, client quay.API, plan *Plan, action Action, pub publisher.Publisher) error {
+	org := plan.Organization
+	fullRepoName := org + "/" + action.Repository
+
+	switch action.Kind {
+	case CreateRobotAction:
+		createOpts := quay.CreateOrganizationRobotOptions{
+			Description: action.Description,
+		}
+
+		if err := client.CreateOrganizationRobot(ctx, org, action.Robot, createOpts); err != nil {
+			return fmt.Errorf("failed to create robot %s: %v", action.Robot, err)
+		}
+
+	case DeleteRobotAction:
+		if err := client.DeleteOrganizationRobot(ctx, org, action.Robot); err != nil {
+			return fmt.Errorf("failed to delete robot %s: %v", action.Robot, err)
+		}
+
+		// Robots that are not configured anymore can still be cleaned up
+		// by publishers that do not rely on the configuration to find
+		// the published credentials (e.g. local files).
+		robotConfig := plan.robotConfig(action.Robot)
+		if robotConfig == nil {
+			robotConfig = &config.RobotConfig{Name: action.Robot}
+		}
+
+		if pub != nil {
+			if _, err := pub.DeleteRobot(ctx, robotConfig); err != nil {
+				return fmt.Errorf("failed to delete robot %s: %v", action.Robot, err)
+			}
+		}
+
+	case CreateTeamAction, UpdateTeamAction:
+		options := quay.UpsertTeamOptions{
+			Role:        quay.TeamRole(action.Role),
+			Description: action.Description,
+		}
+
+		if err := client.UpsertTeam(ctx, org, action.Team, options); err != nil {
+			return fmt.Errorf("failed to ensure team %s: %v", action.Team, err)
+		}
+
+	case DeleteTeamAction:
+		if err := client.DeleteTeam(ctx, org, action.Team); err != nil {
+			return fmt.Errorf("failed to delete team %s: %v", action.Team, err)
+		}
+
+	case AddTeamMemberAction:
+		if err := client.AddUserToTeam(ctx, org, action.Team, action.User); err != nil {
+			return fmt.Errorf("failed to add member %s to team %s: %v", action.User, action.Team, err)
+		}
+
+	case RemoveTeamMemberAction:
+		if err := client.RemoveUserFromTeam(ctx, org, action.Team, action.User); err != nil {
+			return fmt.Errorf("failed to remove member %s from team %s: %v", action.User, action.Team, err)
+		}
+
+	case CreateRepositoryAction:
+		options := quay.CreateRepositoryOptions{
+			Namespace:   org,
+			Repository:  action.Repository,
+			Description: action.Description,
+			Visibility:  action.Visibility,
+		}
+
+		if err := client.CreateRepository(ctx, options); err != nil {
+			return fmt.Errorf("failed to create repository %s: %v", action.Repository, err)
+		}
+
+	case DeleteRepositoryAction:
+		if err := client.DeleteRepository(ctx, fullRepoName); err != nil {
+			return fmt.Errorf("failed to delete repository %s: %v", action.Repository, err)
+		}
+
+	case SetRepositoryVisibilityAction:
+		if err := client.ChangeRepositoryVisibility(ctx, fullRepoName, action.Visibility); err != nil {
+			return fmt.Errorf("failed to set visibility of %s: %v", action.Repository, err)
+		}
+
+	case SetRepositoryDescriptionAction:
+		options := quay.UpdateRepositoryOptions{
+			Description: action.Description,
+		}
+
+		if err := client.UpdateRepository(ctx, fullRepoName, options); err != nil {
+			return fmt.Errorf("failed to update description of %s: %v", action.Repository, err)
+		}
+
+	case SetRepositoryTeamAction:
+		if err := client.SetTeamRepositoryPermissions(ctx, fullRepoName, action.Team, quay.RepositoryRole(action.Role)); err != nil {
+			return fmt.Errorf("failed to set team permissions on %s: %v", action.Repository, err)
+		}
+
+	case RemoveRepositoryTeamAction:
+		// deleting a team also removes its repository permissions,
+		// so it's fine if the permission does not exist anymore
+		if err := client.RemoveTeamFromRepository(ctx, fullRepoName, action.Team); err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to remove team from %s: %v", action.Repository, err)
+		}
+
+	case SetRepositoryUserAction:
+		if err := client.SetUserRepositoryPermissions(ctx, fullRepoName, action.User, quay.RepositoryRole(action.Role)); err != nil {
+			return fmt.Errorf("failed to set user permissions on %s: %v", action.Repository, err)
+		}
+
+	case RemoveRepositoryUserAction:
+		// same for robots
+		if err := client.RemoveUserFromRepository(ctx, fullRepoName, action.User); err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to remove user from %s: %v", action.Repository, err)
+		}
+
+	case CreateDefaultPermissionAction:
+		options := quay.CreateOrganizationPrototypeOptions{
+			Role: quay.RepositoryRole(action.Role),
+		}
+
+		if action.Team != "" {
+			options.Delegate = quay.PrototypeIdentity{Name: action.Team, Kind: quay.PrototypeDelegateTeam}
+		} else {
+			options.Delegate = quay.PrototypeIdentity{Name: action.User, Kind: quay.PrototypeDelegateUser}
+		}
+
+		if action.ActivatingUser != "" {
+			options.ActivatingUser = &quay.PrototypeIdentity{Name: action.ActivatingUser}
+		}
+
+		if err := client.CreateOrganizationPrototype(ctx, org, options); err != nil {
+			return fmt.Errorf("failed to create default permission %s: %v", action.defaultPermissionKey(), err)
+		}
+
+	case UpdateDefaultPermissionAction:
+		if err := client.UpdateOrganizationPrototype(ctx, org, action.ID, quay.RepositoryRole(action.Role)); err != nil {
+			return fmt.Errorf("failed to update default permission %s: %v", action.defaultPermissionKey(), err)
+		}
+
+	case DeleteDefaultPermissionAction:
+		// deleting teams or robots also deletes their default permissions,
+		// so it's fine if it does not exist anymore
+		if err := client.DeleteOrganizationPrototype(ctx, org, action.ID); err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to delete default permission %s: %v", action.defaultPermissionKey(), err)
+		}
+
+	default:
+		return fmt.Errorf("unknown action %q", action.Kind)
+	}
+
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var apiErr *quay.APIError
+
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+func (p *Plan) robotConfig(name string) *config.RobotConfig {
+	for i, rc := range p.Robots {
+		if rc.Name == name {
+			return &p.Robots[i]
+		}
+	}
+
+	return nil
+}
+
+func publishRobots(ctx context.Context, plan *Plan, client quay.API, pub publisher.Publisher) error {
+	// list all robots that exist on quay.io
+	allRobots, err := client.GetOrganizationRobots(ctx, plan.Organization, quay.GetOrganizationRobotsOptions{
+		Token: boolPtr(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list existing organization robots: %w", err)
+	}
+
+	// publish as many robots as possible, even if some fail
+	var errs []error
+
+	for _, robot := range allRobots {
+		robotConfig := plan.robotConfig(robot.ShortName())
+
+		if robotConfig != nil {
+			if _, err := pub.UpdateRobot(ctx, robotConfig, robot.Token); err != nil {
+				log.Printf("  ⚠ ⚛ %s: %v", robotConfig.Name, err)
+				errs = append(errs, fmt.Errorf("failed to publish robot %s: %w", robotConfig.Name, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// actionLogger prints actions in the same hierarchical fashion
+// that is used throughout Aquayman, i.e. team members are printed
+// below their team and permissions below their repository.
+type actionLogger struct {
+	section string
+	team    string
+	repo    string
+
+	// lines is used to buffer the output, if buffered is true
+	buffered bool
+	lines    []string
+}
+
+func newActionLogger() *actionLogger {
+	return &actionLogger{}
+}
+
+// newBufferedActionLogger returns a logger that collects all
+// output until flush is called.
+func newBufferedActionLogger(section string) *actionLogger {
+	return &actionLogger{
+		section:  section,
+		buffered: true,
+	}
+}
+
+func (l *actionLogger) printf(format string, args ...interface{}) {
+	if l.buffered {
+		l.lines = append(l.lines, fmt.Sprintf(format, args...))
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (l *actionLogger) flush() {
+	for _, line := range l.lines {
+		log.Print(line)
+	}
+
+	l.lines = nil
+}
+
+func (a *Action) section() string {
+	switch a.Kind {
+	case CreateRobotAction, DeleteRobotAction:
+		return "robots"
+	case CreateTeamAction, UpdateTeamAction, DeleteTeamAction, AddTeamMemberAction, RemoveTeamMemberAction:
+		return "teams"
+	case CreateDefaultPermissionAction, UpdateDefaultPermissionAction, DeleteDefaultPermissionAction:
+		return "default permissions"
+	default:
+		return "repositories"
+	}
+}
+
+func (l *actionLogger) log(a Action) {
+	if section := a.section(); section != l.section {
+		l.printf("⇄ Syncing %s…", section)
+		l.section = section
+		l.team = ""
+		l.repo = ""
+	}
+
+	switch a.Kind {
+	case CreateRobotAction:
+		l.printf("  + ⚛ %s", a.Robot)
+	case DeleteRobotAction:
+		l.printf("  - ⚛ %s", a.Robot)
+
+	case CreateTeamAction:
+		l.printf("  + ⚑ %s", a.Team)
+		l.team = a.Team
+	case UpdateTeamAction:
+		l.printf("  ✎ ⚑ %s", a.Team)
+		l.team = a.Team
+	case DeleteTeamAction:
+		l.printf("  - ⚑ %s", a.Team)
+	case AddTeamMemberAction:
+		l.teamHeader(a.Team)
+		l.printf("    + ♟ %s", a.User)
+	case RemoveTeamMemberAction:
+		l.teamHeader(a.Team)
+		l.printf("    - ♟ %s", a.User)
+
+	case CreateDefaultPermissionAction:
+		l.printf("  + ⚙ %s (%s)", a.defaultPermissionKey(), a.Role)
+	case UpdateDefaultPermissionAction:
+		l.printf("  ✎ ⚙ %s (%s)", a.defaultPermissionKey(), a.Role)
+	case DeleteDefaultPermissionAction:
+		l.printf("  - ⚙ %s", a.defaultPermissionKey())
+
+	case CreateRepositoryAction:
+		l.printf("  + ⚒ %s", a.Repository)
+		l.repo = a.Repository
+	case DeleteRepositoryAction:
+		l.printf("  - ⚒ %s", a.Repository)
+	case SetRepositoryVisibilityAction:
+		l.repoHeader(a.Repository)
+		l.printf("    - set visibility to %s", a.Visibility)
+	case SetRepositoryDescriptionAction:
+		l.repoHeader(a.Repository)
+		l.printf("    - set description to %q", a.Description)
+	case SetRepositoryTeamAction:
+		l.repoHeader(a.Repository)
+		l.printf("    + ⚑ %s (%s)", a.Team, a.Role)
+	case RemoveRepositoryTeamAction:
+		l.repoHeader(a.Repository)
+		l.printf("    - ⚑ %s", a.Team)
+	case SetRepositoryUserAction:
+		l.repoHeader(a.Repository)
+		l.printf("    + ♟ %s (%s)", a.User, a.Role)
+	case RemoveRepositoryUserAction:
+		l.repoHeader(a.Repository)
+		l.printf("    - ♟ %s", a.User)
+	}
+}
+
+func (l *actionLogger) teamHeader(team string) {
+	if l.team != team {
+		l.printf("  ✎ ⚑ %s", team)
+		l.team = team
+	}
+}
+
+func (l *actionLogger) repoHeader(repo string) {
+	if l.repo != repo {
+		l.printf("  ✎ ⚒ %s", repo)
+		l.repo = repo
+	}
+}
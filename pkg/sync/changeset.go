@@ -0,0 +1,392 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type OutputFormat string
+
+const (
+	JSONOutput     OutputFormat = "json"
+	YAMLOutput     OutputFormat = "yaml"
+	MarkdownOutput OutputFormat = "markdown"
+)
+
+var AllOutputFormats = []OutputFormat{JSONOutput, YAMLOutput, MarkdownOutput}
+
+type ChangeType string
+
+const (
+	Created ChangeType = "create"
+	Updated ChangeType = "update"
+	Deleted ChangeType = "delete"
+)
+
+// Change describes how a single object is modified. Before and After
+// contain only the attributes that are relevant for the change.
+type Change struct {
+	Type   ChangeType        `json:"type" yaml:"type"`
+	Name   string            `json:"name" yaml:"name"`
+	Before map[string]string `json:"before,omitempty" yaml:"before,omitempty"`
+	After  map[string]string `json:"after,omitempty" yaml:"after,omitempty"`
+}
+
+// Changeset is a machine-readable representation of a plan.
+type Changeset struct {
+	Organization string   `json:"organization" yaml:"organization"`
+	Robots       []Change `json:"robots" yaml:"robots"`
+	Teams        []Change `json:"teams" yaml:"teams"`
+	TeamMembers  []Change `json:"teamMembers" yaml:"teamMembers"`
+	Repositories []Change `json:"repositories" yaml:"repositories"`
+
+	DefaultPermissions []Change `json:"defaultPermissions" yaml:"defaultPermissions"`
+}
+
+// Empty returns true if the changeset contains no changes.
+func (c *Changeset) Empty() bool {
+	return c.Len() == 0
+}
+
+// Len returns the number of changed objects.
+func (c *Changeset) Len() int {
+	return len(c.Robots) + len(c.Teams) + len(c.TeamMembers) + len(c.Repositories) + len(c.DefaultPermissions)
+}
+
+// NewChangeset groups the actions of a plan by object and combines
+// them with the previous state of each object.
+func NewChangeset(plan *Plan) *Changeset {
+	state := plan.State
+	if state == nil {
+		state = &State{}
+	}
+
+	cs := &Changeset{
+		Organization: plan.Organization,
+		Robots:       []Change{},
+		Teams:        []Change{},
+		TeamMembers:  []Change{},
+		Repositories: []Change{},
+
+		DefaultPermissions: []Change{},
+	}
+
+	// repository actions are merged into one change per repository
+	repoChanges := map[string]*Change{}
+	repoNames := []string{}
+
+	repoChange := func(name string, changeType ChangeType) *Change {
+		change, exists := repoChanges[name]
+		if !exists {
+			change = &Change{
+				Type:   changeType,
+				Name:   name,
+				Before: map[string]string{},
+				After:  map[string]string{},
+			}
+
+			repoChanges[name] = change
+			repoNames = append(repoNames, name)
+		}
+
+		return change
+	}
+
+	for _, action := range plan.Actions {
+		switch action.Kind {
+		case CreateRobotAction:
+			cs.Robots = append(cs.Robots, Change{
+				Type:  Created,
+				Name:  action.Robot,
+				After: map[string]string{"description": action.Description},
+			})
+
+		case DeleteRobotAction:
+			change := Change{
+				Type: Deleted,
+				Name: action.Robot,
+			}
+
+			if robot := state.robot(action.Robot); robot != nil {
+				change.Before = map[string]string{"description": robot.Description}
+			}
+
+			cs.Robots = append(cs.Robots, change)
+
+		case CreateTeamAction:
+			cs.Teams = append(cs.Teams, Change{
+				Type:  Created,
+				Name:  action.Team,
+				After: map[string]string{"role": action.Role, "description": action.Description},
+			})
+
+		case UpdateTeamAction:
+			change := Change{
+				Type:  Updated,
+				Name:  action.Team,
+				After: map[string]string{"role": action.Role, "description": action.Description},
+			}
+
+			if team := state.team(action.Team); team != nil {
+				change.Before = map[string]string{"role": string(team.Role), "description": team.Description}
+			}
+
+			cs.Teams = append(cs.Teams, change)
+
+		case DeleteTeamAction:
+			change := Change{
+				Type: Deleted,
+				Name: action.Team,
+			}
+
+			if team := state.team(action.Team); team != nil {
+				change.Before = map[string]string{"role": string(team.Role), "description": team.Description}
+			}
+
+			cs.Teams = append(cs.Teams, change)
+
+		case AddTeamMemberAction:
+			cs.TeamMembers = append(cs.TeamMembers, Change{
+				Type:  Created,
+				Name:  action.Team,
+				After: map[string]string{"member": action.User},
+			})
+
+		case RemoveTeamMemberAction:
+			cs.TeamMembers = append(cs.TeamMembers, Change{
+				Type:   Deleted,
+				Name:   action.Team,
+				Before: map[string]string{"member": action.User},
+			})
+
+		case CreateDefaultPermissionAction:
+			cs.DefaultPermissions = append(cs.DefaultPermissions, Change{
+				Type:  Created,
+				Name:  action.defaultPermissionKey(),
+				After: map[string]string{"role": action.Role},
+			})
+
+		case UpdateDefaultPermissionAction, DeleteDefaultPermissionAction:
+			change := Change{
+				Type: Updated,
+				Name: action.defaultPermissionKey(),
+			}
+
+			if action.Kind == DeleteDefaultPermissionAction {
+				change.Type = Deleted
+			} else {
+				change.After = map[string]string{"role": action.Role}
+			}
+
+			if perm := state.defaultPermission(change.Name); perm != nil {
+				change.Before = map[string]string{"role": string(perm.Role)}
+			}
+
+			cs.DefaultPermissions = append(cs.DefaultPermissions, change)
+
+		case CreateRepositoryAction:
+			change := repoChange(action.Repository, Created)
+			change.After["visibility"] = string(action.Visibility)
+			change.After["description"] = action.Description
+
+		case DeleteRepositoryAction:
+			change := repoChange(action.Repository, Deleted)
+
+			if repo := state.repository(action.Repository); repo != nil {
+				change.Before["visibility"] = string(repo.Visibility)
+				change.Before["description"] = repo.Description
+
+				for team, role := range repo.Teams {
+					change.Before["team/"+team] = string(role)
+				}
+
+				for user, role := range repo.Users {
+					change.Before["user/"+user] = string(role)
+				}
+			}
+
+		default:
+			change := repoChange(action.Repository, Updated)
+			repo := state.repository(action.Repository)
+
+			switch action.Kind {
+			case SetRepositoryVisibilityAction:
+				if repo != nil {
+					change.Before["visibility"] = string(repo.Visibility)
+				}
+				change.After["visibility"] = string(action.Visibility)
+
+			case SetRepositoryDescriptionAction:
+				if repo != nil {
+					change.Before["description"] = repo.Description
+				}
+				change.After["description"] = action.Description
+
+			case SetRepositoryTeamAction, RemoveRepositoryTeamAction:
+				key := "team/" + action.Team
+				if repo != nil {
+					if role, exists := repo.Teams[action.Team]; exists {
+						change.Before[key] = string(role)
+					}
+				}
+
+				if action.Kind == SetRepositoryTeamAction {
+					change.After[key] = action.Role
+				}
+
+			case SetRepositoryUserAction, RemoveRepositoryUserAction:
+				key := "user/" + action.User
+				if repo != nil {
+					if role, exists := repo.Users[action.User]; exists {
+						change.Before[key] = string(role)
+					}
+				}
+
+				if action.Kind == SetRepositoryUserAction {
+					change.After[key] = action.Role
+				}
+			}
+		}
+	}
+
+	for _, name := range repoNames {
+		change := repoChanges[name]
+
+		if len(change.Before) == 0 {
+			change.Before = nil
+		}
+
+		if len(change.After) == 0 {
+			change.After = nil
+		}
+
+		cs.Repositories = append(cs.Repositories, *change)
+	}
+
+	return cs
+}
+
+// LogSummary prints one line per changed object.
+func (c *Changeset) LogSummary() {
+	logChanges("robot", c.Robots)
+	logChanges("team", c.Teams)
+	logChanges("team member", c.TeamMembers)
+	logChanges("repository", c.Repositories)
+	logChanges("default permission", c.DefaultPermissions)
+}
+
+func logChanges(kind string, changes []Change) {
+	for _, change := range changes {
+		switch {
+		case kind == "team member" && change.Type == Created:
+			log.Printf("  ≠ %s %s: %s is missing", kind, change.Name, change.After["member"])
+		case kind == "team member" && change.Type == Deleted:
+			log.Printf("  ≠ %s %s: %s is unexpected", kind, change.Name, change.Before["member"])
+		case change.Type == Created:
+			log.Printf("  ≠ %s %s: missing", kind, change.Name)
+		case change.Type == Deleted:
+			log.Printf("  ≠ %s %s: unexpected", kind, change.Name)
+		default:
+			log.Printf("  ≠ %s %s: %s differ", kind, change.Name, strings.Join(changedAttributes(change), ", "))
+		}
+	}
+}
+
+func changedAttributes(change Change) []string {
+	attributes := []string{}
+	for key := range change.Before {
+		attributes = append(attributes, key)
+	}
+
+	for key := range change.After {
+		if _, exists := change.Before[key]; !exists {
+			attributes = append(attributes, key)
+		}
+	}
+
+	sort.Strings(attributes)
+
+	return attributes
+}
+
+// Write encodes the changeset in the given format.
+func (c *Changeset) Write(w io.Writer, format OutputFormat) error {
+	switch format {
+	case JSONOutput:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(c)
+
+	case YAMLOutput:
+		encoder := yaml.NewEncoder(w)
+		defer encoder.Close()
+
+		return encoder.Encode(c)
+
+	case MarkdownOutput:
+		return c.writeMarkdown(w)
+
+	default:
+		return fmt.Errorf("unknown output format %q, must be one of %v", format, AllOutputFormats)
+	}
+}
+
+func (c *Changeset) writeMarkdown(w io.Writer) error {
+	var buf strings.Builder
+
+	fmt.Fprintf(&buf, "## Changes for organization `%s`\n\n", c.Organization)
+
+	if c.Empty() {
+		buf.WriteString("No changes.\n")
+	} else {
+		writeMarkdownSection(&buf, "Robots", c.Robots)
+		writeMarkdownSection(&buf, "Teams", c.Teams)
+		writeMarkdownSection(&buf, "Team Members", c.TeamMembers)
+		writeMarkdownSection(&buf, "Repositories", c.Repositories)
+		writeMarkdownSection(&buf, "Default Permissions", c.DefaultPermissions)
+	}
+
+	_, err := io.WriteString(w, buf.String())
+
+	return err
+}
+
+func writeMarkdownSection(buf *strings.Builder, title string, changes []Change) {
+	if len(changes) == 0 {
+		return
+	}
+
+	fmt.Fprintf(buf, "### %s\n\n", title)
+	buf.WriteString("| Name | Change | Before | After |\n")
+	buf.WriteString("|------|--------|--------|-------|\n")
+
+	for _, change := range changes {
+		fmt.Fprintf(buf, "| `%s` | %s | %s | %s |\n", change.Name, change.Type, markdownValues(change.Before), markdownValues(change.After))
+	}
+
+	buf.WriteString("\n")
+}
+
+func markdownValues(values map[string]string) string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, key := range keys {
+		value := strings.ReplaceAll(values[key], "|", "\\|")
+		parts = append(parts, fmt.Sprintf("%s: `%s`", key, value))
+	}
+
+	return strings.Join(parts, "<br>")
+}
@@ -0,0 +1,447 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+	"github.com/kubermatic-labs/aquayman/pkg/util"
+)
+
+type ActionKind string
+
+const (
+	CreateRobotAction ActionKind = "create-robot"
+	DeleteRobotAction ActionKind = "delete-robot"
+
+	CreateTeamAction       ActionKind = "create-team"
+	UpdateTeamAction       ActionKind = "update-team"
+	DeleteTeamAction       ActionKind = "delete-team"
+	AddTeamMemberAction    ActionKind = "add-team-member"
+	RemoveTeamMemberAction ActionKind = "remove-team-member"
+
+	CreateRepositoryAction         ActionKind = "create-repository"
+	DeleteRepositoryAction         ActionKind = "delete-repository"
+	SetRepositoryVisibilityAction  ActionKind = "set-repository-visibility"
+	SetRepositoryDescriptionAction ActionKind = "set-repository-description"
+	SetRepositoryTeamAction        ActionKind = "set-repository-team"
+	RemoveRepositoryTeamAction     ActionKind = "remove-repository-team"
+	SetRepositoryUserAction        ActionKind = "set-repository-user"
+	RemoveRepositoryUserAction     ActionKind = "remove-repository-user"
+
+	CreateDefaultPermissionAction ActionKind = "create-default-permission"
+	UpdateDefaultPermissionAction ActionKind = "update-default-permission"
+	DeleteDefaultPermissionAction ActionKind = "delete-default-permission"
+)
+
+// Action is a single change that needs to be made to an organization.
+// Depending on the kind, only some of the fields are set.
+type Action struct {
+	Kind        ActionKind                `json:"kind"`
+	Robot       string                    `json:"robot,omitempty"`
+	Team        string                    `json:"team,omitempty"`
+	Repository  string                    `json:"repository,omitempty"`
+	User        string                    `json:"user,omitempty"`
+	Role        string                    `json:"role,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Visibility  quay.RepositoryVisibility `json:"visibility,omitempty"`
+
+	// these are only used for default permissions
+	ID             string `json:"id,omitempty"`
+	ActivatingUser string `json:"activatingUser,omitempty"`
+}
+
+// Plan is the list of actions required to bring an organization
+// in line with its configuration.
+type Plan struct {
+	Organization string    `json:"organization"`
+	CreatedAt    time.Time `json:"createdAt"`
+
+	// StateHash is the hash of the organization state the plan was
+	// based on. Applying a plan file is refused if the state has
+	// changed in the meantime.
+	StateHash string `json:"stateHash"`
+
+	// State is the organization state the plan was based on.
+	State *State `json:"state"`
+
+	Actions []Action `json:"actions"`
+
+	// Robots are the configured robots, required to publish their
+	// tokens after the plan has been applied.
+	Robots []config.RobotConfig `json:"robots,omitempty"`
+}
+
+// Empty returns true if the plan contains no actions.
+func (p *Plan) Empty() bool {
+	return len(p.Actions) == 0
+}
+
+// CreatePlan compares the configuration against the given state
+// and returns all actions necessary to reconcile the organization.
+func CreatePlan(cfg *config.Config, state *State, options Options) (*Plan, error) {
+	hash, err := state.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash state: %v", err)
+	}
+
+	// plan against the actual users instead of group references
+	cfg, err = cfg.ExpandGroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand groups: %v", err)
+	}
+
+	plan := &Plan{
+		Organization: cfg.Organization,
+		CreatedAt:    time.Now().UTC(),
+		StateHash:    hash,
+		State:        state,
+		Actions:      []Action{},
+		Robots:       cfg.Robots,
+	}
+
+	plan.Actions = append(plan.Actions, planRobots(cfg, state)...)
+	plan.Actions = append(plan.Actions, planTeams(cfg, state)...)
+	plan.Actions = append(plan.Actions, planDefaultPermissions(cfg, state)...)
+	plan.Actions = append(plan.Actions, planRepositories(cfg, state, options)...)
+
+	return plan, nil
+}
+
+func planRobots(cfg *config.Config, state *State) []Action {
+	actions := []Action{}
+	expectedRobots := []string{}
+
+	// create missing robots
+	for _, robot := range cfg.Robots {
+		// do not create robots that are marked for deletion (this flag is mainly
+		// a workaround for proper cleanup in Vault)
+		if robot.Deleted {
+			continue
+		}
+
+		expectedRobots = append(expectedRobots, robot.Name)
+
+		// do nothing to existing robots, the quay.io API does not offer an endpoint
+		// to update a robot's description
+		if state.robot(robot.Name) != nil {
+			continue
+		}
+
+		actions = append(actions, Action{
+			Kind:        CreateRobotAction,
+			Robot:       robot.Name,
+			Description: robot.Description,
+		})
+	}
+
+	// remove overhanging robots
+	for _, robot := range state.Robots {
+		if !util.StringSliceContains(expectedRobots, robot.Name) {
+			actions = append(actions, Action{
+				Kind:  DeleteRobotAction,
+				Robot: robot.Name,
+			})
+		}
+	}
+
+	return actions
+}
+
+func planTeams(cfg *config.Config, state *State) []Action {
+	actions := []Action{}
+	expectedTeams := []string{}
+
+	for _, team := range cfg.Teams {
+		expectedTeams = append(expectedTeams, team.Name)
+
+		current := state.team(team.Name)
+		currentMembers := []string{}
+
+		if current == nil {
+			actions = append(actions, Action{
+				Kind:        CreateTeamAction,
+				Team:        team.Name,
+				Role:        string(team.Role),
+				Description: team.Description,
+			})
+		} else {
+			currentMembers = current.Members
+
+			if current.Role != team.Role || current.Description != team.Description {
+				actions = append(actions, Action{
+					Kind:        UpdateTeamAction,
+					Team:        team.Name,
+					Role:        string(team.Role),
+					Description: team.Description,
+				})
+			}
+		}
+
+		for _, member := range currentMembers {
+			if !util.StringSliceContains(team.Members, member) {
+				actions = append(actions, Action{
+					Kind: RemoveTeamMemberAction,
+					Team: team.Name,
+					User: member,
+				})
+			}
+		}
+
+		for _, member := range team.Members {
+			if !util.StringSliceContains(currentMembers, member) {
+				actions = append(actions, Action{
+					Kind: AddTeamMemberAction,
+					Team: team.Name,
+					User: member,
+				})
+			}
+		}
+	}
+
+	for _, team := range state.Teams {
+		if !util.StringSliceContains(expectedTeams, team.Name) {
+			actions = append(actions, Action{
+				Kind: DeleteTeamAction,
+				Team: team.Name,
+			})
+		}
+	}
+
+	return actions
+}
+
+func planDefaultPermissions(cfg *config.Config, state *State) []Action {
+	actions := []Action{}
+	expectedKeys := []string{}
+
+	for _, perm := range cfg.DefaultPermissions {
+		key := perm.Key()
+		expectedKeys = append(expectedKeys, key)
+
+		current := state.defaultPermission(key)
+		if current == nil {
+			actions = append(actions, Action{
+				Kind:           CreateDefaultPermissionAction,
+				Team:           perm.Team,
+				User:           perm.User,
+				Role:           string(perm.Role),
+				ActivatingUser: perm.ActivatingUser,
+			})
+		} else if current.Role != perm.Role {
+			actions = append(actions, Action{
+				Kind:           UpdateDefaultPermissionAction,
+				ID:             current.ID,
+				Team:           perm.Team,
+				User:           perm.User,
+				Role:           string(perm.Role),
+				ActivatingUser: perm.ActivatingUser,
+			})
+		}
+	}
+
+	for _, perm := range state.DefaultPermissions {
+		permConfig := perm.Config()
+
+		if !util.StringSliceContains(expectedKeys, permConfig.Key()) {
+			actions = append(actions, Action{
+				Kind:           DeleteDefaultPermissionAction,
+				ID:             perm.ID,
+				Team:           perm.Team,
+				User:           perm.User,
+				ActivatingUser: perm.ActivatingUser,
+			})
+		}
+	}
+
+	return actions
+}
+
+// defaultPermissionKey returns the same key as config.DefaultPermissionConfig.Key().
+func (a *Action) defaultPermissionKey() string {
+	perm := config.DefaultPermissionConfig{
+		Team:           a.Team,
+		User:           a.User,
+		ActivatingUser: a.ActivatingUser,
+	}
+
+	return perm.Key()
+}
+
+func planRepositories(cfg *config.Config, state *State, options Options) []Action {
+	actions := []Action{}
+
+	// update/delete existing repos
+	currentRepoNames := []string{}
+	for _, repo := range state.Repositories {
+		currentRepoNames = append(currentRepoNames, repo.Name)
+
+		repoConfig := cfg.GetRepositoryConfig(repo.Name)
+		if repoConfig == nil {
+			if options.DeleteDanglingRepositories {
+				actions = append(actions, Action{
+					Kind:       DeleteRepositoryAction,
+					Repository: repo.Name,
+				})
+			}
+
+			continue
+		}
+
+		actions = append(actions, planRepository(repo, repoConfig)...)
+	}
+
+	// create missing repos
+	if options.CreateMissingRepositories {
+		for _, repoConfig := range cfg.Repositories {
+			// ignore wildcard rules
+			if repoConfig.IsWildcard() {
+				continue
+			}
+
+			if !util.StringSliceContains(currentRepoNames, repoConfig.Name) {
+				actions = append(actions, Action{
+					Kind:        CreateRepositoryAction,
+					Repository:  repoConfig.Name,
+					Visibility:  repoConfig.Visibility,
+					Description: repoConfig.Description,
+				})
+
+				repo := RepositoryState{
+					Name:        repoConfig.Name,
+					Visibility:  repoConfig.Visibility,
+					Description: repoConfig.Description,
+				}
+
+				actions = append(actions, planRepository(repo, &repoConfig)...)
+			}
+		}
+	}
+
+	return actions
+}
+
+func planRepository(repo RepositoryState, repoConfig *config.RepositoryConfig) []Action {
+	actions := []Action{}
+
+	if repo.Visibility != repoConfig.Visibility {
+		actions = append(actions, Action{
+			Kind:       SetRepositoryVisibilityAction,
+			Repository: repo.Name,
+			Visibility: repoConfig.Visibility,
+		})
+	}
+
+	if repo.Description != repoConfig.Description {
+		actions = append(actions, Action{
+			Kind:        SetRepositoryDescriptionAction,
+			Repository:  repo.Name,
+			Description: repoConfig.Description,
+		})
+	}
+
+	for _, teamName := range sortedKeys(repo.Teams) {
+		expectedRole, exists := repoConfig.Teams[teamName]
+		if !exists {
+			actions = append(actions, Action{
+				Kind:       RemoveRepositoryTeamAction,
+				Repository: repo.Name,
+				Team:       teamName,
+			})
+		} else if expectedRole != repo.Teams[teamName] {
+			actions = append(actions, Action{
+				Kind:       SetRepositoryTeamAction,
+				Repository: repo.Name,
+				Team:       teamName,
+				Role:       string(expectedRole),
+			})
+		}
+	}
+
+	for _, teamName := range sortedKeys(repoConfig.Teams) {
+		if _, exists := repo.Teams[teamName]; !exists {
+			actions = append(actions, Action{
+				Kind:       SetRepositoryTeamAction,
+				Repository: repo.Name,
+				Team:       teamName,
+				Role:       string(repoConfig.Teams[teamName]),
+			})
+		}
+	}
+
+	for _, userName := range sortedKeys(repo.Users) {
+		expectedRole, exists := repoConfig.Users[userName]
+		if !exists {
+			actions = append(actions, Action{
+				Kind:       RemoveRepositoryUserAction,
+				Repository: repo.Name,
+				User:       userName,
+			})
+		} else if expectedRole != repo.Users[userName] {
+			actions = append(actions, Action{
+				Kind:       SetRepositoryUserAction,
+				Repository: repo.Name,
+				User:       userName,
+				Role:       string(expectedRole),
+			})
+		}
+	}
+
+	for _, userName := range sortedKeys(repoConfig.Users) {
+		if _, exists := repo.Users[userName]; !exists {
+			actions = append(actions, Action{
+				Kind:       SetRepositoryUserAction,
+				Repository: repo.Name,
+				User:       userName,
+				Role:       string(repoConfig.Users[userName]),
+			})
+		}
+	}
+
+	return actions
+}
+
+func sortedKeys(m map[string]quay.RepositoryRole) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// SavePlan writes the plan as JSON into the given file.
+func SavePlan(plan *Plan, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(plan)
+}
+
+// LoadPlan reads a plan previously written by SavePlan.
+func LoadPlan(filename string) (*Plan, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	plan := &Plan{}
+	if err := json.NewDecoder(f).Decode(plan); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
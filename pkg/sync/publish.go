@@ -0,0 +1,85 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/publisher"
+)
+
+// resultRecorder wraps a publisher, logs every secret that was
+// changed and counts the outcomes, so they can be summarized.
+type resultRecorder struct {
+	pub    publisher.Publisher
+	counts map[publisher.Outcome]int
+}
+
+func newResultRecorder(pub publisher.Publisher) *resultRecorder {
+	return &resultRecorder{
+		pub:    pub,
+		counts: map[publisher.Outcome]int{},
+	}
+}
+
+func (r *resultRecorder) UpdateRobot(ctx context.Context, robot *config.RobotConfig, token string) ([]publisher.Result, error) {
+	results, err := r.pub.UpdateRobot(ctx, robot, token)
+	r.record(robot, results)
+
+	return results, err
+}
+
+func (r *resultRecorder) DeleteRobot(ctx context.Context, robot *config.RobotConfig) ([]publisher.Result, error) {
+	results, err := r.pub.DeleteRobot(ctx, robot)
+	r.record(robot, results)
+
+	return results, err
+}
+
+func (r *resultRecorder) record(robot *config.RobotConfig, results []publisher.Result) {
+	for _, result := range results {
+		r.counts[result.Outcome]++
+		logResult(robot.Name, result)
+	}
+}
+
+// logSummary prints how many secrets were created, updated etc.
+func (r *resultRecorder) logSummary() {
+	total := 0
+	parts := []string{}
+
+	for _, outcome := range []publisher.Outcome{publisher.Created, publisher.Updated, publisher.Unchanged, publisher.Deleted} {
+		total += r.counts[outcome]
+		parts = append(parts, fmt.Sprintf("%d %s", r.counts[outcome], outcome))
+	}
+
+	if total > 0 {
+		log.Printf("ℹ Published secrets: %s.", strings.Join(parts, ", "))
+	}
+}
+
+// logResult prints a single changed secret; unchanged secrets
+// are only counted, to keep the output short.
+func logResult(robot string, result publisher.Result) {
+	symbol := ""
+
+	switch result.Outcome {
+	case publisher.Created:
+		symbol = "+"
+	case publisher.Updated:
+		symbol = "✎"
+	case publisher.Deleted:
+		symbol = "-"
+	default:
+		return
+	}
+
+	name := result.Secret
+	if result.Publisher != "" {
+		name = fmt.Sprintf("%s %s", result.Publisher, result.Secret)
+	}
+
+	log.Printf("  %s ⚿ %s (%s)", symbol, name, robot)
+}
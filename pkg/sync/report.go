@@ -0,0 +1,79 @@
+package sync
+
+import (
+	"log"
+)
+
+// Report collects the results of all organizations that were
+// processed in one run, so that they can be summarized at the end.
+type Report struct {
+	Results []OrganizationResult
+}
+
+type OrganizationResult struct {
+	Organization string
+
+	// Changes is the number of changed objects (see Changeset).
+	Changes int
+
+	// Applied is true if the changes were actually performed.
+	Applied bool
+
+	Err error
+}
+
+// Add records the result for an organization. The changeset can be
+// nil if planning failed.
+func (r *Report) Add(organization string, changeset *Changeset, applied bool, err error) {
+	result := OrganizationResult{
+		Organization: organization,
+		Applied:      applied,
+		Err:          err,
+	}
+
+	if changeset != nil {
+		result.Changes = changeset.Len()
+	}
+
+	r.Results = append(r.Results, result)
+}
+
+// Failed returns true if any organization failed.
+func (r *Report) Failed() bool {
+	for _, result := range r.Results {
+		if result.Err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Changed returns true if any organization has changes.
+func (r *Report) Changed() bool {
+	for _, result := range r.Results {
+		if result.Changes > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LogSummary prints one line per organization.
+func (r *Report) LogSummary() {
+	log.Println("ℹ Summary:")
+
+	for _, result := range r.Results {
+		switch {
+		case result.Err != nil:
+			log.Printf("  ⚠ %s: %v", result.Organization, result.Err)
+		case result.Changes == 0:
+			log.Printf("  ✓ %s: up-to-date", result.Organization)
+		case result.Applied:
+			log.Printf("  ✓ %s: %d change(s) applied", result.Organization, result.Changes)
+		default:
+			log.Printf("  ≠ %s: %d change(s) pending", result.Organization, result.Changes)
+		}
+	}
+}
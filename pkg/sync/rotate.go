@@ -0,0 +1,132 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/publisher"
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+	"github.com/kubermatic-labs/aquayman/pkg/statefile"
+)
+
+// DueRobots returns the short names of all existing robots whose
+// rotateAfter interval has passed. If a robot's token has never been
+// rotated, its creation time is used instead. Robots with an unknown
+// age are not rotated, but recorded in the state, so that their
+// interval starts now.
+func DueRobots(ctx context.Context, cfg *config.Config, client quay.API, state *statefile.StateFile, now time.Time) ([]string, error) {
+	robots, err := client.GetOrganizationRobots(ctx, cfg.Organization, quay.GetOrganizationRobotsOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list existing organization robots: %w", err)
+	}
+
+	existing := map[string]quay.Robot{}
+	for _, robot := range robots {
+		existing[robot.ShortName()] = robot
+	}
+
+	due := []string{}
+
+	for _, robotConfig := range cfg.Robots {
+		if robotConfig.Deleted {
+			continue
+		}
+
+		interval, err := robotConfig.RotationInterval()
+		if err != nil {
+			return nil, fmt.Errorf("invalid rotateAfter for robot %s: %w", robotConfig.Name, err)
+		}
+
+		if interval <= 0 {
+			continue
+		}
+
+		// robots that do not exist yet will get a fresh token anyway
+		robot, exists := existing[robotConfig.Name]
+		if !exists {
+			continue
+		}
+
+		robotState := state.Robots[robot.Name]
+
+		lastRotation := robotState.RotatedAt
+		if lastRotation.IsZero() {
+			lastRotation, _ = robot.CreatedAt()
+		}
+
+		if lastRotation.IsZero() {
+			robotState.RotatedAt = now.UTC()
+			state.Robots[robot.Name] = robotState
+			continue
+		}
+
+		if now.Sub(lastRotation) >= interval {
+			due = append(due, robotConfig.Name)
+		}
+	}
+
+	return due, nil
+}
+
+// RotateRobots regenerates the tokens of the given robots (identified
+// by their short names) and publishes the new tokens right away. The
+// rotation time is recorded in the state. The names of all rotated
+// robots are returned, even if an error occurred midway. As a rotation
+// invalidates the old token, nothing is rotated unless all robots are
+// configured and a publisher is given.
+func RotateRobots(ctx context.Context, cfg *config.Config, client quay.API, names []string, pub publisher.Publisher, state *statefile.StateFile) ([]string, error) {
+	rotated := []string{}
+
+	if pub == nil {
+		return rotated, errors.New("no publisher is enabled, new tokens could not be published")
+	}
+
+	robotConfigs := map[string]*config.RobotConfig{}
+
+	for _, name := range names {
+		for i, rc := range cfg.Robots {
+			if rc.Name == name && !rc.Deleted {
+				robotConfigs[name] = &cfg.Robots[i]
+				break
+			}
+		}
+
+		if robotConfigs[name] == nil {
+			return rotated, fmt.Errorf("robot %s is not configured", name)
+		}
+	}
+
+	for _, name := range names {
+		log.Printf("  ↻ ⚛ %s", name)
+
+		robot, err := client.RegenerateOrganizationRobotToken(ctx, cfg.Organization, name)
+		if err != nil {
+			return rotated, fmt.Errorf("failed to regenerate token for robot %s: %w", name, err)
+		}
+
+		rotated = append(rotated, name)
+
+		if state != nil {
+			fullName := fmt.Sprintf("%s+%s", cfg.Organization, name)
+
+			robotState := state.Robots[fullName]
+			robotState.RotatedAt = time.Now().UTC()
+			state.Robots[fullName] = robotState
+		}
+
+		results, err := pub.UpdateRobot(ctx, robotConfigs[name], robot.Token)
+		for _, result := range results {
+			logResult(name, result)
+		}
+
+		if err != nil {
+			return rotated, fmt.Errorf("failed to publish new token for robot %s: %w", name, err)
+		}
+	}
+
+	return rotated, nil
+}
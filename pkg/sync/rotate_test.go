@@ -0,0 +1,147 @@
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/publisher"
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+	"github.com/kubermatic-labs/aquayman/pkg/quay/quaytest"
+	"github.com/kubermatic-labs/aquayman/pkg/statefile"
+)
+
+// tokenRecorder is a publisher that remembers the published tokens.
+type tokenRecorder struct {
+	tokens map[string]string
+}
+
+func (r *tokenRecorder) UpdateRobot(ctx context.Context, robot *config.RobotConfig, token string) ([]publisher.Result, error) {
+	r.tokens[robot.Name] = token
+	return nil, nil
+}
+
+func (r *tokenRecorder) DeleteRobot(ctx context.Context, robot *config.RobotConfig) ([]publisher.Result, error) {
+	delete(r.tokens, robot.Name)
+	return nil, nil
+}
+
+func newRotationTest(t *testing.T) (*quaytest.Organization, quay.API, *config.Config) {
+	t.Helper()
+
+	server, client := newTestServer(t)
+
+	org := server.Organizations[testOrganization]
+	org.Robots["ci"] = &quaytest.Robot{Token: "ci-token", Created: time.Now().Add(-48 * time.Hour)}
+	org.Robots["legacy"] = &quaytest.Robot{Token: "legacy-token"}
+
+	cfg := &config.Config{
+		Organization: testOrganization,
+		Robots: []config.RobotConfig{
+			{Name: "ci", RotateAfter: "24h"},
+			{Name: "legacy", RotateAfter: "24h"},
+		},
+	}
+
+	return org, client, cfg
+}
+
+func TestRotateRobots(t *testing.T) {
+	ctx := context.Background()
+	org, client, cfg := newRotationTest(t)
+
+	pub := &tokenRecorder{tokens: map[string]string{}}
+	state := &statefile.StateFile{Robots: map[string]statefile.RobotState{}}
+
+	rotated, err := RotateRobots(ctx, cfg, client, []string{"ci"}, pub, state)
+	if err != nil {
+		t.Fatalf("Rotation failed: %v", err)
+	}
+
+	if len(rotated) != 1 || rotated[0] != "ci" {
+		t.Fatalf("Expected ci to be rotated, but got %v.", rotated)
+	}
+
+	token := org.Robots["ci"].Token
+	if token == "ci-token" {
+		t.Fatal("Expected the token to be regenerated.")
+	}
+
+	if pub.tokens["ci"] != token {
+		t.Errorf("Expected new token %q to be published, but got %q.", token, pub.tokens["ci"])
+	}
+
+	if state.Robots[testOrganization+"+ci"].RotatedAt.IsZero() {
+		t.Error("Expected the rotation to be recorded in the state.")
+	}
+}
+
+func TestRotateRobotsRefusesUnsafeRotations(t *testing.T) {
+	testcases := []struct {
+		name  string
+		names []string
+		pub   publisher.Publisher
+	}{
+		{
+			name:  "unknown robot",
+			names: []string{"ci", "typo"},
+			pub:   &tokenRecorder{tokens: map[string]string{}},
+		},
+		{
+			name:  "no publisher",
+			names: []string{"ci"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			org, client, cfg := newRotationTest(t)
+
+			rotated, err := RotateRobots(context.Background(), cfg, client, tc.names, tc.pub, nil)
+			if err == nil {
+				t.Fatal("Expected rotation to fail.")
+			}
+
+			if len(rotated) > 0 {
+				t.Errorf("Expected no robots to be rotated, but got %v.", rotated)
+			}
+
+			if token := org.Robots["ci"].Token; token != "ci-token" {
+				t.Errorf("Expected the token to be left alone, but it was changed to %q.", token)
+			}
+		})
+	}
+}
+
+func TestDueRobots(t *testing.T) {
+	ctx := context.Background()
+	_, client, cfg := newRotationTest(t)
+
+	now := time.Now()
+	state := &statefile.StateFile{Robots: map[string]statefile.RobotState{}}
+
+	due, err := DueRobots(ctx, cfg, client, state, now)
+	if err != nil {
+		t.Fatalf("Failed to determine due robots: %v", err)
+	}
+
+	// legacy has no creation date and must not be rotated on every run
+	if len(due) != 1 || due[0] != "ci" {
+		t.Fatalf("Expected only ci to be due, but got %v.", due)
+	}
+
+	if recorded := state.Robots[testOrganization+"+legacy"].RotatedAt; !recorded.Equal(now.UTC()) {
+		t.Fatalf("Expected legacy to be recorded with the current time, but got %v.", recorded)
+	}
+
+	// a day later, the recorded time makes it due
+	due, err = DueRobots(ctx, cfg, client, state, now.Add(25*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to determine due robots: %v", err)
+	}
+
+	if len(due) != 2 {
+		t.Fatalf("Expected both robots to be due, but got %v.", due)
+	}
+}
@@ -0,0 +1,299 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+	"github.com/kubermatic-labs/aquayman/pkg/util"
+)
+
+// State is a snapshot of everything Aquayman manages inside an
+// organization. It is used to compute a plan and to detect whether
+// the organization has changed since a plan was created.
+type State struct {
+	Organization string            `json:"organization"`
+	Robots       []RobotState      `json:"robots"`
+	Teams        []TeamState       `json:"teams"`
+	Repositories []RepositoryState `json:"repositories"`
+
+	DefaultPermissions []DefaultPermissionState `json:"defaultPermissions"`
+}
+
+type RobotState struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type TeamState struct {
+	Name        string        `json:"name"`
+	Role        quay.TeamRole `json:"role"`
+	Description string        `json:"description"`
+	Members     []string      `json:"members"`
+}
+
+type RepositoryState struct {
+	Name        string                         `json:"name"`
+	Visibility  quay.RepositoryVisibility      `json:"visibility"`
+	Description string                         `json:"description"`
+	Teams       map[string]quay.RepositoryRole `json:"teams"`
+	Users       map[string]quay.RepositoryRole `json:"users"`
+}
+
+type DefaultPermissionState struct {
+	ID             string              `json:"id"`
+	Team           string              `json:"team,omitempty"`
+	User           string              `json:"user,omitempty"`
+	Role           quay.RepositoryRole `json:"role"`
+	ActivatingUser string              `json:"activatingUser,omitempty"`
+}
+
+func (p *DefaultPermissionState) Config() config.DefaultPermissionConfig {
+	return config.DefaultPermissionConfig{
+		Team:           p.Team,
+		User:           p.User,
+		Role:           p.Role,
+		ActivatingUser: p.ActivatingUser,
+	}
+}
+
+// FetchState retrieves the current state of the organization. This
+// only performs read operations and is therefore safe to use at any time.
+// Repositories are fetched using up to parallelism concurrent workers.
+func FetchState(ctx context.Context, client quay.API, organization string, parallelism int) (*State, error) {
+	state := &State{
+		Organization: organization,
+	}
+
+	if err := fetchRobots(ctx, client, state); err != nil {
+		return nil, fmt.Errorf("failed to fetch robots: %v", err)
+	}
+
+	if err := fetchTeams(ctx, client, state); err != nil {
+		return nil, fmt.Errorf("failed to fetch teams: %v", err)
+	}
+
+	if err := fetchRepositories(ctx, client, state, parallelism); err != nil {
+		return nil, fmt.Errorf("failed to fetch repositories: %v", err)
+	}
+
+	if err := fetchDefaultPermissions(ctx, client, state); err != nil {
+		return nil, fmt.Errorf("failed to fetch default permissions: %v", err)
+	}
+
+	return state, nil
+}
+
+func fetchRobots(ctx context.Context, client quay.API, state *State) error {
+	robots, err := client.GetOrganizationRobots(ctx, state.Organization, quay.GetOrganizationRobotsOptions{})
+	if err != nil {
+		return err
+	}
+
+	state.Robots = []RobotState{}
+	for _, robot := range robots {
+		state.Robots = append(state.Robots, RobotState{
+			Name:        robot.ShortName(),
+			Description: robot.Description,
+		})
+	}
+
+	sort.Slice(state.Robots, func(i, j int) bool {
+		return state.Robots[i].Name < state.Robots[j].Name
+	})
+
+	return nil
+}
+
+func fetchTeams(ctx context.Context, client quay.API, state *State) error {
+	org, err := client.GetOrganization(ctx, state.Organization)
+	if err != nil {
+		return err
+	}
+
+	state.Teams = []TeamState{}
+
+	for _, teamName := range org.OrderedTeams {
+		team := org.Teams[teamName]
+
+		options := quay.GetTeamMembersOptions{
+			IncludePending: boolPtr(true),
+		}
+
+		members, err := client.GetTeamMembers(ctx, state.Organization, teamName, options)
+		if err != nil {
+			return fmt.Errorf("failed to list members of team %s: %v", teamName, err)
+		}
+
+		memberNames := []string{}
+		for _, member := range members {
+			memberNames = append(memberNames, member.Name)
+		}
+
+		sort.Strings(memberNames)
+
+		state.Teams = append(state.Teams, TeamState{
+			Name:        teamName,
+			Role:        team.Role,
+			Description: team.Description,
+			Members:     memberNames,
+		})
+	}
+
+	return nil
+}
+
+func fetchRepositories(ctx context.Context, client quay.API, state *State, parallelism int) error {
+	repos, err := client.GetRepositories(ctx, quay.GetRepositoriesOptions{Namespace: state.Organization})
+	if err != nil {
+		return err
+	}
+
+	state.Repositories = make([]RepositoryState, len(repos))
+
+	var errs []error
+
+	fetch := func(i int) error {
+		repo := repos[i]
+
+		teamPermissions, err := client.GetRepositoryTeamPermissions(ctx, repo.FullName())
+		if err != nil {
+			return fmt.Errorf("failed to get team permissions for %s: %v", repo.Name, err)
+		}
+
+		teams := map[string]quay.RepositoryRole{}
+		for _, team := range teamPermissions {
+			teams[team.Name] = team.Role
+		}
+
+		userPermissions, err := client.GetRepositoryUserPermissions(ctx, repo.FullName())
+		if err != nil {
+			return fmt.Errorf("failed to get user permissions for %s: %v", repo.Name, err)
+		}
+
+		users := map[string]quay.RepositoryRole{}
+		for _, user := range userPermissions {
+			users[user.Name] = user.Role
+		}
+
+		state.Repositories[i] = RepositoryState{
+			Name:        repo.Name,
+			Visibility:  repo.Visibility(),
+			Description: repo.Description,
+			Teams:       teams,
+			Users:       users,
+		}
+
+		return nil
+	}
+
+	util.Parallel(parallelism, len(repos), fetch, func(_ int, err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	})
+
+	// do not let the API's ordering affect the state's hash
+	sort.Slice(state.Repositories, func(i, j int) bool {
+		return state.Repositories[i].Name < state.Repositories[j].Name
+	})
+
+	return errors.Join(errs...)
+}
+
+func fetchDefaultPermissions(ctx context.Context, client quay.API, state *State) error {
+	prototypes, err := client.GetOrganizationPrototypes(ctx, state.Organization)
+	if err != nil {
+		return err
+	}
+
+	state.DefaultPermissions = []DefaultPermissionState{}
+
+	for _, prototype := range prototypes {
+		perm := DefaultPermissionState{
+			ID:   prototype.ID,
+			Role: prototype.Role,
+		}
+
+		if prototype.Delegate.Kind == quay.PrototypeDelegateTeam {
+			perm.Team = prototype.Delegate.Name
+		} else {
+			perm.User = prototype.Delegate.Name
+		}
+
+		if prototype.ActivatingUser != nil {
+			perm.ActivatingUser = prototype.ActivatingUser.Name
+		}
+
+		state.DefaultPermissions = append(state.DefaultPermissions, perm)
+	}
+
+	sort.Slice(state.DefaultPermissions, func(i, j int) bool {
+		a := state.DefaultPermissions[i].Config()
+		b := state.DefaultPermissions[j].Config()
+
+		return a.Key() < b.Key()
+	})
+
+	return nil
+}
+
+// Hash returns a checksum over the entire state. Two states with
+// the same hash are considered identical.
+func (s *State) Hash() (string, error) {
+	encoded, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(encoded)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (s *State) robot(name string) *RobotState {
+	for i, robot := range s.Robots {
+		if robot.Name == name {
+			return &s.Robots[i]
+		}
+	}
+
+	return nil
+}
+
+func (s *State) team(name string) *TeamState {
+	for i, team := range s.Teams {
+		if team.Name == name {
+			return &s.Teams[i]
+		}
+	}
+
+	return nil
+}
+
+func (s *State) repository(name string) *RepositoryState {
+	for i, repo := range s.Repositories {
+		if repo.Name == name {
+			return &s.Repositories[i]
+		}
+	}
+
+	return nil
+}
+
+func (s *State) defaultPermission(key string) *DefaultPermissionState {
+	for i, perm := range s.DefaultPermissions {
+		permConfig := perm.Config()
+		if permConfig.Key() == key {
+			return &s.DefaultPermissions[i]
+		}
+	}
+
+	return nil
+}
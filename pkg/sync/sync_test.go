@@ -0,0 +1,339 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kubermatic-labs/aquayman/pkg/config"
+	"github.com/kubermatic-labs/aquayman/pkg/quay"
+	"github.com/kubermatic-labs/aquayman/pkg/quay/quaytest"
+)
+
+const testOrganization = "testorg"
+
+func newTestServer(t *testing.T) (*quaytest.Server, *quay.Client) {
+	t.Helper()
+
+	server, client := quaytest.NewClient(t)
+	server.AddUser("alice", "bob", "carol")
+	server.AddOrganization(testOrganization)
+
+	return server, client
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Organization: testOrganization,
+		Robots: []config.RobotConfig{
+			{Name: "ci", Description: "CI robot"},
+		},
+		Teams: []config.TeamConfig{
+			{
+				Name:    "developers",
+				Role:    quay.MemberTeamRole,
+				Members: []string{"alice", "bob", testOrganization + "+ci"},
+			},
+			{
+				Name:    "admins",
+				Role:    quay.AdminTeamRole,
+				Members: []string{"carol"},
+			},
+		},
+		Repositories: []config.RepositoryConfig{
+			{
+				Name:        "app",
+				Visibility:  quay.Public,
+				Description: "the app",
+				Teams: map[string]quay.RepositoryRole{
+					"developers": quay.WriteRepositoryRole,
+				},
+				Users: map[string]quay.RepositoryRole{
+					"carol":                  quay.AdminRepositoryRole,
+					testOrganization + "+ci": quay.ReadRepositoryRole,
+				},
+			},
+			{
+				Name:       "internal-*",
+				Visibility: quay.Private,
+				Teams: map[string]quay.RepositoryRole{
+					"admins": quay.AdminRepositoryRole,
+				},
+			},
+		},
+		DefaultPermissions: []config.DefaultPermissionConfig{
+			{Team: "developers", Role: quay.ReadRepositoryRole},
+		},
+	}
+}
+
+func testOptions() Options {
+	options := DefaultOptions()
+	options.CreateMissingRepositories = true
+	options.DeleteDanglingRepositories = true
+	options.Parallelism = 4
+
+	return options
+}
+
+func TestSyncConverges(t *testing.T) {
+	ctx := context.Background()
+	server, client := newTestServer(t)
+
+	// something to clean up
+	org := server.Organizations[testOrganization]
+	org.Robots["old"] = &quaytest.Robot{Token: "old-token"}
+	org.Teams["contractors"] = &quaytest.Team{Role: quay.MemberTeamRole, Members: []string{"bob"}}
+	org.Repositories["internal-tools"] = &quaytest.Repository{
+		Visibility: quay.Public,
+		Teams:      map[string]quay.RepositoryRole{"contractors": quay.ReadRepositoryRole},
+		Users:      map[string]quay.RepositoryRole{},
+	}
+	org.Repositories["dangling"] = &quaytest.Repository{
+		Visibility: quay.Public,
+		Teams:      map[string]quay.RepositoryRole{},
+		Users:      map[string]quay.RepositoryRole{},
+	}
+
+	cfg := testConfig()
+
+	if err := Sync(ctx, cfg, client, testOptions()); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	plan, err := BuildPlan(ctx, cfg, client, testOptions())
+	if err != nil {
+		t.Fatalf("Failed to build plan: %v", err)
+	}
+
+	if !plan.Empty() {
+		t.Fatalf("Expected no further changes after syncing, but got %d: %+v", len(plan.Actions), plan.Actions)
+	}
+
+	if _, exists := org.Robots["old"]; exists {
+		t.Error("Robot old should have been deleted.")
+	}
+
+	if _, exists := org.Repositories["dangling"]; exists {
+		t.Error("Repository dangling should have been deleted.")
+	}
+
+	tools := org.Repositories["internal-tools"]
+	if tools.Visibility != quay.Private {
+		t.Errorf("Expected internal-tools to be private, but is %s.", tools.Visibility)
+	}
+
+	if role := tools.Teams["admins"]; role != quay.AdminRepositoryRole {
+		t.Errorf("Expected admins to have admin role on internal-tools, but got %q.", role)
+	}
+
+	app, exists := org.Repositories["app"]
+	if !exists {
+		t.Fatal("Repository app should have been created.")
+	}
+
+	if role := app.Users[testOrganization+"+ci"]; role != quay.ReadRepositoryRole {
+		t.Errorf("Expected robot to have read role on app, but got %q.", role)
+	}
+
+	if members := org.Teams["developers"].Members; len(members) != 3 {
+		t.Errorf("Expected developers team to have 3 members, but got %v.", members)
+	}
+}
+
+func TestFetchStatePagination(t *testing.T) {
+	ctx := context.Background()
+	server, client := newTestServer(t)
+
+	server.PageSize = 2
+
+	org := server.Organizations[testOrganization]
+	for i := 0; i < 7; i++ {
+		org.Repositories[fmt.Sprintf("repo-%d", i)] = &quaytest.Repository{
+			Visibility: quay.Public,
+			Teams:      map[string]quay.RepositoryRole{},
+			Users:      map[string]quay.RepositoryRole{},
+		}
+	}
+
+	state, err := FetchState(ctx, client, testOrganization, 3)
+	if err != nil {
+		t.Fatalf("Failed to fetch state: %v", err)
+	}
+
+	if len(state.Repositories) != 7 {
+		t.Fatalf("Expected 7 repositories across all pages, but got %d.", len(state.Repositories))
+	}
+
+	for i, repo := range state.Repositories {
+		if expected := fmt.Sprintf("repo-%d", i); repo.Name != expected {
+			t.Errorf("Expected repository %d to be %s, but got %s.", i, expected, repo.Name)
+		}
+	}
+}
+
+func TestStateHashIsStable(t *testing.T) {
+	ctx := context.Background()
+	server, client := newTestServer(t)
+
+	if err := Sync(ctx, testConfig(), client, testOptions()); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	hashes := map[string]struct{}{}
+
+	for _, pageSize := range []int{1, 2, 100} {
+		server.PageSize = pageSize
+
+		state, err := FetchState(ctx, client, testOrganization, 4)
+		if err != nil {
+			t.Fatalf("Failed to fetch state: %v", err)
+		}
+
+		hash, err := state.Hash()
+		if err != nil {
+			t.Fatalf("Failed to hash state: %v", err)
+		}
+
+		hashes[hash] = struct{}{}
+	}
+
+	if len(hashes) != 1 {
+		t.Fatalf("Expected the same hash regardless of pagination, but got %d different hashes.", len(hashes))
+	}
+}
+
+func TestSyncRetriesFailedRequests(t *testing.T) {
+	ctx := context.Background()
+	server, client := newTestServer(t)
+
+	server.FailRequests(1, http.StatusBadGateway, 0)
+	server.FailRequests(1, http.StatusTooManyRequests, 0)
+	server.FailRequests(1, http.StatusServiceUnavailable, 0)
+
+	if err := Sync(ctx, testConfig(), client, testOptions()); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	stats := client.Stats()
+	if stats.Retries != 3 {
+		t.Errorf("Expected 3 retries, but got %d.", stats.Retries)
+	}
+
+	if stats.RateLimited != 1 {
+		t.Errorf("Expected 1 rate limited request, but got %d.", stats.RateLimited)
+	}
+}
+
+func TestSyncGivesUpAfterMaxRetries(t *testing.T) {
+	ctx := context.Background()
+	server, client := newTestServer(t)
+
+	server.FailRequests(10, http.StatusBadGateway, 0)
+
+	err := Sync(ctx, testConfig(), client, testOptions())
+	if err == nil {
+		t.Fatal("Expected sync to fail, but it succeeded.")
+	}
+
+	if stats := client.Stats(); stats.Retries != 3 {
+		t.Errorf("Expected 3 retries, but got %d.", stats.Retries)
+	}
+}
+
+func TestApplyReportsAPIErrors(t *testing.T) {
+	testcases := []struct {
+		name     string
+		action   Action
+		setup    func(org *quaytest.Organization)
+		expected string
+	}{
+		{
+			name:     "unknown user",
+			action:   Action{Kind: AddTeamMemberAction, Team: "developers", User: "mallory"},
+			expected: "invalid_request: Unknown user: mallory",
+		},
+		{
+			// quay.io only sets the undocumented "message" field here
+			name:   "already a member",
+			action: Action{Kind: AddTeamMemberAction, Team: "developers", User: "alice"},
+			setup: func(org *quaytest.Organization) {
+				org.Teams["developers"].Members = []string{"alice"}
+			},
+			expected: "User alice is already a member of team developers",
+		},
+		{
+			name:     "missing team",
+			action:   Action{Kind: DeleteTeamAction, Team: "ghosts"},
+			expected: "not_found: Not Found",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			server, client := newTestServer(t)
+
+			org := server.Organizations[testOrganization]
+			org.Teams["developers"] = &quaytest.Team{Role: quay.MemberTeamRole}
+
+			if tc.setup != nil {
+				tc.setup(org)
+			}
+
+			plan := &Plan{
+				Organization: testOrganization,
+				Actions:      []Action{tc.action},
+			}
+
+			err := Apply(ctx, client, plan, testOptions())
+			if err == nil {
+				t.Fatal("Expected apply to fail, but it succeeded.")
+			}
+
+			if !strings.Contains(err.Error(), tc.expected) {
+				t.Fatalf("Expected error to contain %q, but got %q.", tc.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestApplyToleratesMissingDefaultPermission(t *testing.T) {
+	ctx := context.Background()
+	_, client := newTestServer(t)
+
+	plan := &Plan{
+		Organization: testOrganization,
+		Actions: []Action{
+			{Kind: DeleteDefaultPermissionAction, ID: "does-not-exist", Team: "developers"},
+		},
+	}
+
+	if err := Apply(ctx, client, plan, testOptions()); err != nil {
+		t.Fatalf("Expected a 404 to be ignored, but got: %v", err)
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	ctx := context.Background()
+	server, client := newTestServer(t)
+
+	plan, err := BuildPlan(ctx, testConfig(), client, testOptions())
+	if err != nil {
+		t.Fatalf("Failed to build plan: %v", err)
+	}
+
+	if err := CheckDrift(ctx, client, plan, testOptions()); err != nil {
+		t.Fatalf("Expected no drift, but got: %v", err)
+	}
+
+	server.Lock()
+	server.Organizations[testOrganization].Robots["sneaky"] = &quaytest.Robot{}
+	server.Unlock()
+
+	if err := CheckDrift(ctx, client, plan, testOptions()); err != ErrStateDrifted {
+		t.Fatalf("Expected ErrStateDrifted, but got: %v", err)
+	}
+}
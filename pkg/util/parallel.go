@@ -0,0 +1,71 @@
+package util
+
+import (
+	"sync"
+)
+
+// Parallel calls fn for every index from 0 to n-1, using at most
+// parallelism concurrent goroutines (values below 1 mean 1). Once
+// an index and all indices before it have been processed, done is
+// called with its result. This allows callers to produce ordered,
+// deterministic output while the work itself happens concurrently.
+// done is never called concurrently.
+func Parallel(parallelism int, n int, fn func(i int) error, done func(i int, err error)) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	type result struct {
+		index int
+		err   error
+	}
+
+	indices := make(chan int)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < parallelism && w < n; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range indices {
+				results <- result{index: i, err: fn(i)}
+			}
+		}()
+	}
+
+	go func() {
+		for i := 0; i < n; i++ {
+			indices <- i
+		}
+
+		close(indices)
+		wg.Wait()
+		close(results)
+	}()
+
+	finished := map[int]error{}
+	next := 0
+
+	for r := range results {
+		finished[r.index] = r.err
+
+		for {
+			err, ok := finished[next]
+			if !ok {
+				break
+			}
+
+			delete(finished, next)
+
+			if done != nil {
+				done(next, err)
+			}
+
+			next++
+		}
+	}
+}
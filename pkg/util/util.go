@@ -1,5 +1,11 @@
 package util
 
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
 func StringSliceContains(s []string, needle string) bool {
 	for _, item := range s {
 		if item == needle {
@@ -9,3 +15,16 @@ func StringSliceContains(s []string, needle string) bool {
 
 	return false
 }
+
+// ParseDuration works like time.ParseDuration, but additionally
+// supports a "d" suffix for whole days, e.g. "90d".
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+
+	return time.ParseDuration(s)
+}